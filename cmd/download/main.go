@@ -5,10 +5,13 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/PuerkitoBio/goquery"
 	"github.com/mmcdole/gofeed"
 	"github.com/urfave/cli/v2"
 	"oxide-search/manifest"
@@ -41,7 +44,17 @@ func Download(ctx *cli.Context) error {
 	const maxEpisodes = 10
 	var processedEpisodes = 0
 	for _, item := range feed.Items {
-		if _, exists := manifestData.Episodes[item.GUID]; exists {
+		if episode, exists := manifestData.Episodes[item.GUID]; exists {
+			// Episodes downloaded before the feed, speakers and links were recorded get them filled in
+			episode.Feed = feed.Title
+			episode.Speakers = speakers(item)
+			if itemLinks, err := links(item); err != nil {
+				// A broken link in one item shouldn't stop the download, its links are left as they were
+				fmt.Printf("skipping the links of podcast item %s: %s\n", item.GUID, err)
+			} else {
+				episode.Links = itemLinks
+			}
+			manifestData.Episodes[item.GUID] = episode
 			fmt.Printf("skipping existing item %s\n", item.GUID)
 			continue
 		}
@@ -86,6 +99,10 @@ func Download(ctx *cli.Context) error {
 			return fmt.Errorf("downloaded file was not the expected length: expected %d and got %d bytes", expectedLength, written)
 		}
 		_ = resp.Body.Close()
+		showNotesLinks, err := links(item)
+		if err != nil {
+			fmt.Printf("skipping the links of podcast item %s: %s\n", item.GUID, err)
+		}
 		manifestData.Episodes[item.GUID] = manifest.EpisodeData{
 			Title:       item.Title,
 			Description: item.Description,
@@ -93,6 +110,9 @@ func Download(ctx *cli.Context) error {
 			Filename:    filename,
 			GUID:        item.GUID,
 			Published:   item.Published,
+			Feed:        feed.Title,
+			Speakers:    speakers(item),
+			Links:       showNotesLinks,
 		}
 		time.Sleep(time.Millisecond * 2000) // Be nice to transistor.fm
 	}
@@ -104,3 +124,72 @@ func Download(ctx *cli.Context) error {
 
 	return nil
 }
+
+// speakers lists the people named for an episode in the feed, from its podcast:person tags and its authors
+func speakers(item *gofeed.Item) []string {
+	var names []string
+	for _, person := range item.Extensions["podcast"]["person"] {
+		names = append(names, strings.TrimSpace(person.Value))
+	}
+	for _, author := range item.Authors {
+		names = append(names, strings.TrimSpace(author.Name))
+	}
+
+	seen := make(map[string]bool)
+	var unique []string
+	for _, name := range names {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		unique = append(unique, name)
+	}
+	return unique
+}
+
+// links pulls the links out of the show notes of an episode, which the feed has as HTML in its description and
+// sometimes its content too. Relative links are resolved against the episode page, and links that aren't to a web
+// page, like mailto links, are left out
+func links(item *gofeed.Item) ([]manifest.Link, error) {
+	base, err := url.Parse(item.Link)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse link %s of podcast item %s: %w", item.Link, item.GUID, err)
+	}
+
+	var found []manifest.Link
+	seen := make(map[string]int)
+	for _, showNotes := range []string{item.Description, item.Content} {
+		document, err := goquery.NewDocumentFromReader(strings.NewReader(showNotes))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse show notes of podcast item %s: %w", item.GUID, err)
+		}
+		document.Find("a[href]").Each(func(_ int, a *goquery.Selection) {
+			href, err := url.Parse(strings.TrimSpace(a.AttrOr("href", "")))
+			if err != nil {
+				return
+			}
+			resolved := base.ResolveReference(href)
+			if resolved.Scheme != "http" && resolved.Scheme != "https" {
+				return
+			}
+			resolved.Host = strings.ToLower(resolved.Host)
+			resolved.Fragment = ""
+			text := strings.Join(strings.Fields(a.Text()), " ")
+
+			// The same link is often in both the description and the content, keep the first with any text
+			if i, ok := seen[resolved.String()]; ok {
+				if found[i].Text == "" {
+					found[i].Text = text
+				}
+				return
+			}
+			seen[resolved.String()] = len(found)
+			found = append(found, manifest.Link{
+				URL:    resolved.String(),
+				Text:   text,
+				Domain: strings.TrimPrefix(resolved.Hostname(), "www."),
+			})
+		})
+	}
+	return found, nil
+}
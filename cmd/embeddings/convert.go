@@ -0,0 +1,95 @@
+package embeddings
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli/v2"
+
+	"oxide-search/embedding"
+	"oxide-search/manifest"
+)
+
+var ConvertFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model whose embeddings should be converted, defaults to the active model",
+	},
+	&cli.StringFlag{
+		Name:  "format",
+		Usage: "format to convert embeddings to, one of json, binary or binary16",
+		Value: string(embedding.FormatBinary),
+	},
+	&cli.BoolFlag{
+		Name:  "npy",
+		Usage: "also export the vectors of each episode as a numpy .npy file",
+	},
+	&cli.BoolFlag{
+		Name:  "remove-json",
+		Usage: "remove the original JSON embeddings files once they've been converted",
+	},
+}
+
+// Convert rewrites the stored embeddings for every episode in the manifest into the requested format, which is
+// mostly useful for moving older JSON embeddings over to the binary format
+func Convert(ctx *cli.Context) error {
+	format, err := embedding.ParseFormat(ctx.String("format"))
+	if err != nil {
+		return err
+	}
+
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+
+	manifestData, err := manifest.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load data manifest: %w", err)
+	}
+
+	for _, episode := range manifestData.Episodes {
+		embeddings, err := embedding.Load(dataDirectory, model.Name, episode.GUID)
+		if err != nil {
+			fmt.Printf("skipping episode %s (%s): %s\n", episode.GUID, episode.Title, err)
+			continue
+		}
+
+		err = embedding.Save(dataDirectory, model.Name, episode.GUID, embeddings, format)
+		if err != nil {
+			return err
+		}
+
+		if ctx.Bool("npy") {
+			vectors := make([][]float32, len(embeddings))
+			for i := range embeddings {
+				vectors[i] = embeddings[i].Vector
+			}
+			npyFile, err := os.Create(embedding.NpyPath(dataDirectory, model.Name, episode.GUID))
+			if err != nil {
+				return fmt.Errorf("failed to create npy file for episode %s: %w", episode.GUID, err)
+			}
+			err = embedding.WriteNpy(npyFile, vectors)
+			_ = npyFile.Close()
+			if err != nil {
+				return fmt.Errorf("failed to export episode %s to npy: %w", episode.GUID, err)
+			}
+		}
+
+		if ctx.Bool("remove-json") && format != embedding.FormatJSON {
+			err = os.Remove(embedding.JSONPath(dataDirectory, model.Name, episode.GUID))
+			if err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove json embeddings for episode %s: %w", episode.GUID, err)
+			}
+			if model.Name == embedding.DefaultModel {
+				if err := embedding.RemoveLegacyJSON(dataDirectory, episode.GUID); err != nil {
+					return err
+				}
+			}
+		}
+
+		fmt.Printf("converted %d %s embeddings for %s (%s) to %s\n", len(embeddings), model.Name, episode.GUID, episode.Title, format)
+	}
+
+	return nil
+}
@@ -1,11 +1,13 @@
 package embeddings
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/sashabaranov/go-openai"
 	"github.com/urfave/cli/v2"
@@ -21,73 +23,227 @@ const (
 	vectorSize = 500
 )
 
+var Flags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model to use, defaults to the active model",
+	},
+	&cli.StringFlag{
+		Name:  "format",
+		Usage: "format to store embeddings in, one of json, binary or binary16",
+		Value: string(embedding.FormatBinary),
+	},
+	&cli.BoolFlag{
+		Name:  "force",
+		Usage: "embed every episode again, even the ones whose embeddings are already complete",
+	},
+}, EmbedderFlags...)
+
+// EmbedderFlags configure how requests are made to the embeddings API
+var EmbedderFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:  "workers",
+		Usage: "number of embedding requests to have in flight at once",
+		Value: 4,
+	},
+	&cli.IntFlag{
+		Name:  "requests-per-minute",
+		Usage: "requests per minute rate limit for the embeddings API",
+		Value: 3000,
+	},
+	&cli.IntFlag{
+		Name:  "tokens-per-minute",
+		Usage: "tokens per minute rate limit for the embeddings API",
+		Value: 1000000,
+	},
+	&cli.IntFlag{
+		Name:  "max-request-tokens",
+		Usage: "maximum estimated tokens to pack into a single embeddings request",
+		Value: 100000,
+	},
+	&cli.IntFlag{
+		Name:  "max-retries",
+		Usage: "number of times to retry a rate limited or failed embeddings request",
+		Value: 5,
+	},
+	&cli.BoolFlag{
+		Name:  "allow-gaps",
+		Usage: "write out partial embeddings and record the chunks that failed instead of failing the run",
+	},
+}
+
+// chunkTranscript splits a transcript into overlapping windows of about vectorSize words, returning the text of
+// each window and the offset of its first word
+func chunkTranscript(transcript string) ([]string, []int) {
+	stringField := strings.Fields(transcript)
+
+	var chunks []string
+	var offsets []int
+	for _, window := range embedding.Windows(len(stringField), vectorSize) {
+		chunks = append(chunks, strings.Join(stringField[window.Start:window.End], " "))
+		offsets = append(offsets, window.Start)
+	}
+
+	return chunks, offsets
+}
+
+// EmbedderConfig builds the embedder configuration for a model from EmbedderFlags
+func EmbedderConfig(ctx *cli.Context, model string) embedding.EmbedderConfig {
+	return embedding.EmbedderConfig{
+		Model:             openai.EmbeddingModel(model),
+		Workers:           ctx.Int("workers"),
+		RequestsPerMinute: ctx.Int("requests-per-minute"),
+		TokensPerMinute:   ctx.Int("tokens-per-minute"),
+		MaxRequestTokens:  ctx.Int("max-request-tokens"),
+		MaxRetries:        ctx.Int("max-retries"),
+		AllowGaps:         ctx.Bool("allow-gaps"),
+		OnBatch: func(batch int, batches int, inputs int, tokens int) {
+			fmt.Printf("submitted embeddings batch %d/%d of %d inputs (~%d tokens)\n", batch, batches, inputs, tokens)
+		},
+		OnRetry: func(inputs int, delay time.Duration, err error) {
+			fmt.Printf("retrying embeddings request of %d inputs in %s after error: %s\n", inputs, delay, err)
+		},
+	}
+}
+
 func Embed(ctx *cli.Context) error {
+	format, err := embedding.ParseFormat(ctx.String("format"))
+	if err != nil {
+		return err
+	}
+
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+
+	return Generate(ctx.Context, EmbedderConfig(ctx, model.Name), format, ctx.Bool("force"))
+}
+
+// Generate embeds every transcript in the manifest with the configured model, and stores them in the data
+// directory alongside any embeddings from other models. Episodes that already have complete embeddings from the
+// model are skipped unless force is set, and if embedding fails the episodes that were fully embedded are still
+// saved, so running it again only embeds what's left
+func Generate(ctx context.Context, config embedding.EmbedderConfig, format embedding.Format, force bool) error {
+	model := string(config.Model)
 	manifestData, err := manifest.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load data manifest: %w", err)
 	}
 
-	// Split the transcripts up into 500~ word chunks and submit them to openai to create embeddings, which we
-	// then store alongside the files
-	openaiClient := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	// Split the transcripts up into 500~ word chunks, then submit all the chunks from every episode to openai
+	// together so they can be packed into as few requests as possible
+	type episodeChunks struct {
+		episode manifest.EpisodeData
+		start   int
+		chunks  []string
+		offsets []int
+	}
+	var episodes []episodeChunks
+	var inputs []string
+	skipped := 0
 	for _, episode := range manifestData.Episodes {
+		chunks, offsets := chunkTranscript(episode.Transcript)
+		if !force && embedded(model, episode.GUID, chunks) {
+			skipped++
+			continue
+		}
+		fmt.Printf("generating vectors for %d chunks of %d words from the transcript of %s (%s)\n", len(chunks), vectorSize, episode.GUID, episode.Title)
+		episodes = append(episodes, episodeChunks{episode: episode, start: len(inputs), chunks: chunks, offsets: offsets})
+		inputs = append(inputs, chunks...)
+	}
 
-		stringField := strings.Fields(episode.Transcript)
-		fmt.Printf("generating vectors for %d batches of %d words at a time from the transcript of %d words\n", len(stringField)/vectorSize, vectorSize, len(episode.Transcript))
-
-		index := 0
-		embeddings := make([]embedding.Storage, 0)
-		for index < len(stringField)-vectorSize {
+	if skipped > 0 {
+		fmt.Printf("skipped %d episodes which are already embedded with %s\n", skipped, model)
+	}
+	if len(inputs) == 0 {
+		return nil
+	}
 
-			// Take overlapping sets of windows to use as embeddings, for example of our index is 1000 and our window size is 500
-			var batches []string
-			// Take the window on the current index, 1000-1500
-			if index+vectorSize <= len(stringField) {
-				batches = append(batches, strings.Join(stringField[index:index+vectorSize], " "))
-			}
-			// Slide the window forwards and take the terms 1250-1750
-			if index+vectorSize+(vectorSize/2) <= len(stringField) {
-				batches = append(batches, strings.Join(stringField[index+(vectorSize/2):index+vectorSize+(vectorSize/2)], " "))
-			}
-			// Slide the window backwards and take the terms 750-1250
-			if index > vectorSize {
-				batches = append(batches, strings.Join(stringField[index-(vectorSize/2):index+(vectorSize/2)], " "))
-			}
+	embedder := embedding.NewEmbedder(openai.NewClient(os.Getenv("OPENAI_API_KEY")), config)
+	vectors, gaps, embedErr := embedder.Embed(ctx, inputs)
+	if vectors == nil {
+		return fmt.Errorf("failed to generate embeddings: %w", embedErr)
+	}
 
-			index += vectorSize
+	gapErrors := make(map[int]string, len(gaps))
+	for _, gap := range gaps {
+		gapErrors[gap.Input] = gap.Error
+	}
 
-			embeddingResponse, err := openaiClient.CreateEmbeddings(ctx.Context, openai.EmbeddingRequestStrings{
-				Input: batches,
-				Model: openai.AdaEmbeddingV2,
-			})
-			if err != nil {
-				e := fmt.Errorf("Error generating embeddings for chunk at indices %d:%d of episode %s: %w\n", index, index+vectorSize, episode.GUID, err)
-				fmt.Println(e)
+	saved := 0
+	for _, e := range episodes {
+		// After a failure only the episodes whose every chunk was embedded are saved, the rest are left for next time
+		if embedErr != nil && slices.ContainsFunc(vectors[e.start:e.start+len(e.chunks)], func(vector []float32) bool { return vector == nil }) {
+			continue
+		}
+		saved++
+
+		embeddings := make([]embedding.Storage, 0, len(e.chunks))
+		var episodeGaps []embedding.Gap
+		for i, chunk := range e.chunks {
+			vector := vectors[e.start+i]
+			if vector == nil {
+				episodeGaps = append(episodeGaps, embedding.Gap{Input: i, Error: gapErrors[e.start+i]})
 				continue
 			}
-
-			for i := range embeddingResponse.Data {
-				embeddings = append(embeddings, embedding.Storage{
-					GUID:       episode.GUID,
-					VectorSize: vectorSize,
-					Model:      "text-embedding-ada-002",
-					Vector:     embeddingResponse.Data[i].Embedding,
-					Content:    batches[i],
-				})
-			}
-
+			embeddings = append(embeddings, embedding.Storage{
+				GUID:       e.episode.GUID,
+				VectorSize: vectorSize,
+				Offset:     e.offsets[i],
+				Chunk:      i,
+				Model:      model,
+				Vector:     vector,
+				Content:    chunk,
+			})
 		}
 
-		embeddingBytes, err := json.MarshalIndent(embeddings, "", " ")
+		err = embedding.Save(dataDirectory, model, e.episode.GUID, embeddings, format)
 		if err != nil {
-			return fmt.Errorf("failed to serialize embeddings data for episode %s: %w", episode.GUID, err)
+			return err
 		}
 
-		err = os.WriteFile(filepath.Join(dataDirectory, fmt.Sprintf("%s.embeddings.json", episode.GUID)), embeddingBytes, 0644)
+		// Record which chunks are missing next to the embeddings, rather than silently leaving holes in the episode
+		gapsFile := embedding.GapsPath(dataDirectory, model, e.episode.GUID)
+		if len(episodeGaps) == 0 {
+			_ = os.Remove(gapsFile)
+			continue
+		}
+		gapBytes, err := json.MarshalIndent(episodeGaps, "", " ")
 		if err != nil {
-			return fmt.Errorf("failed to write embeddings data for episode %s: %w", episode.GUID, err)
+			return fmt.Errorf("failed to serialize embedding gaps for episode %s: %w", e.episode.GUID, err)
 		}
+		err = os.WriteFile(gapsFile, gapBytes, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write embedding gaps for episode %s: %w", e.episode.GUID, err)
+		}
+		fmt.Printf("%d of %d chunks of episode %s could not be embedded, see %s\n", len(episodeGaps), len(e.chunks), e.episode.GUID, gapsFile)
+	}
+
+	if embedErr != nil {
+		return fmt.Errorf("failed to generate embeddings, the %d of %d episodes embedded before the failure were saved: %w", saved, len(episodes), embedErr)
+	}
+	if len(gaps) > 0 {
+		fmt.Printf("%d of %d chunks could not be embedded\n", len(gaps), len(inputs))
 	}
 
 	return nil
 }
+
+// embedded is true if an episode already has embeddings from the model for every chunk of its transcript as it's
+// chunked now, with none of them missing
+func embedded(model string, GUID string, chunks []string) bool {
+	if _, err := os.Stat(embedding.GapsPath(dataDirectory, model, GUID)); err == nil {
+		return false
+	}
+	existing, err := embedding.Load(dataDirectory, model, GUID)
+	if err != nil || len(existing) != len(chunks) {
+		return false
+	}
+	for i := range existing {
+		if existing[i].Content != chunks[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,183 @@
+package entities
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/urfave/cli/v2"
+
+	"oxide-search/embedding"
+	"oxide-search/entities"
+	"oxide-search/manifest"
+)
+
+const (
+	dataDirectory = "data"
+)
+
+var Flags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model whose chunks to extract entities from, defaults to the active model",
+	},
+	&cli.StringFlag{
+		Name:  "extractor",
+		Usage: "how to find entities, chat asks a chat model and rules matches the names in data/entities.json and a built in list",
+		Value: entities.ExtractorChat,
+	},
+	&cli.StringFlag{
+		Name:  "chat-model",
+		Usage: "chat model used to extract the entities",
+		Value: openai.GPT3Dot5Turbo,
+	},
+	&cli.IntFlag{
+		Name:  "workers",
+		Usage: "number of chunks to extract entities from at once",
+		Value: 4,
+	},
+	&cli.BoolFlag{
+		Name:  "force",
+		Usage: "extract entities again for chunks that already have them",
+	},
+}
+
+// Extract finds the companies, people, products, projects and papers named in each transcript chunk, then writes a
+// glossary page for each of them listing every mention
+func Extract(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+
+	var extractor entities.Extractor
+	switch ctx.String("extractor") {
+	case entities.ExtractorChat:
+		extractor = entities.Chat{Client: openai.NewClient(os.Getenv("OPENAI_API_KEY")), Model: ctx.String("chat-model")}
+	case entities.ExtractorRules:
+		known, err := entities.LoadKnown(dataDirectory)
+		if err != nil {
+			return err
+		}
+		extractor = entities.NewRules(known)
+	default:
+		return fmt.Errorf("unknown extractor %q, expected %s or %s", ctx.String("extractor"), entities.ExtractorChat, entities.ExtractorRules)
+	}
+
+	manifestData, err := manifest.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load data manifest: %w", err)
+	}
+	guids := make([]string, 0, len(manifestData.Episodes))
+	for guid := range manifestData.Episodes {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+
+	var extracted []*entities.Episode
+	for _, guid := range guids {
+		episode := manifestData.Episodes[guid]
+		chunks, err := embedding.Load(dataDirectory, model.Name, episode.GUID)
+		if err != nil {
+			fmt.Printf("skipping episode %s (%s): %s\n", episode.GUID, episode.Title, err)
+			continue
+		}
+
+		existing, err := entities.Load(dataDirectory, episode.GUID)
+		if err != nil {
+			return err
+		}
+		// Entities from another extractor are replaced, rather than mixing the two
+		previous := make(map[string]entities.Chunk)
+		if existing != nil && existing.Extractor == ctx.String("extractor") && !ctx.Bool("force") {
+			for _, c := range existing.Chunks {
+				previous[c.Content] = c
+			}
+		}
+
+		// Reuse entities for any chunks we've seen before, and extract them from the rest
+		episodeEntities := &entities.Episode{GUID: episode.GUID, Extractor: ctx.String("extractor"), Chunks: make([]entities.Chunk, len(chunks))}
+		var missing []int
+		for i, chunk := range chunks {
+			if c, ok := previous[chunk.Content]; ok {
+				c.VectorId = chunk.Chunk
+				episodeEntities.Chunks[i] = c
+				continue
+			}
+			episodeEntities.Chunks[i] = entities.Chunk{VectorId: chunk.Chunk, Content: chunk.Content}
+			missing = append(missing, i)
+		}
+
+		if len(missing) > 0 {
+			fmt.Printf("extracting entities from %d chunks of episode %s (%s)\n", len(missing), episode.GUID, episode.Title)
+		}
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var firstErr error
+		failed := make(map[int]bool)
+		work := make(chan int)
+		for w := 0; w < max(ctx.Int("workers"), 1); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range work {
+					found, err := extractor.Extract(ctx.Context, chunks[i].Content)
+					mu.Lock()
+					if err != nil {
+						failed[i] = true
+						if firstErr == nil {
+							firstErr = fmt.Errorf("failed to extract entities from chunk %d of episode %s: %w", i, episode.GUID, err)
+						}
+					}
+					episodeEntities.Chunks[i].Entities = found
+					mu.Unlock()
+				}
+			}()
+		}
+		for _, i := range missing {
+			work <- i
+		}
+		close(work)
+		wg.Wait()
+
+		// Save every episode as soon as it's done, so a failure part way through the archive doesn't lose what's
+		// been extracted already. Chunks that failed are left out, to be extracted again next time
+		if firstErr != nil {
+			var done []entities.Chunk
+			for i, c := range episodeEntities.Chunks {
+				if !failed[i] {
+					done = append(done, c)
+				}
+			}
+			episodeEntities.Chunks = done
+		}
+		err = entities.Save(dataDirectory, episodeEntities)
+		if err != nil {
+			return err
+		}
+		if firstErr != nil {
+			return firstErr
+		}
+
+		extracted = append(extracted, episodeEntities)
+	}
+
+	// The same entity can be written differently from chunk to chunk, so once everything has been extracted the
+	// entities are saved again renamed to match their pages
+	pages := entities.Pages(extracted, manifestData.Episodes)
+	entities.Canonicalize(extracted, pages)
+	for _, episodeEntities := range extracted {
+		err = entities.Save(dataDirectory, episodeEntities)
+		if err != nil {
+			return err
+		}
+	}
+	err = entities.WritePages(dataDirectory, pages)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("wrote glossary pages for %d entities from %d episodes, run index to make them searchable\n", len(pages), len(extracted))
+	return nil
+}
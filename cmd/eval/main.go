@@ -0,0 +1,214 @@
+package eval
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/urfave/cli/v2"
+
+	"oxide-search/cmd/index"
+	"oxide-search/cmd/query"
+	"oxide-search/embedding"
+	"oxide-search/expand"
+	"oxide-search/search"
+)
+
+const (
+	dataDirectory = "data"
+)
+
+var Flags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:     "queries",
+		Usage:    `JSON lines file of evaluation queries, like {"Query": "...", "GUID": "<guid of the episode that answers it>"}`,
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model to search with, defaults to the active model",
+	},
+	&cli.IntFlag{
+		Name:  "size",
+		Usage: "number of results to consider for each query",
+		Value: 10,
+	},
+	index.StoreFlag,
+}, append(append(query.HybridFlags, query.DiversityFlags...), query.ExpandFlags...)...)
+
+type evalQuery struct {
+	Query string
+	GUID  string
+}
+
+// strategy is one way of retrieving results for a query, which are compared against each other
+type strategy struct {
+	name     string
+	retrieve func(ctx context.Context, store search.VectorStore, queryText string, queryVector []float32, size int) ([]search.SearchHit, error)
+}
+
+// strategies are compared for every evaluation, the hybrid ones use the weights from the command line with each
+// kind of fusion, and the diversified one the diversity settings from the command line. Each query transformation
+// turned on from the command line adds a strategy searching with it, as they each cost a chat completion per query
+func strategies(hybrid search.HybridConfig, diversity search.DiversityConfig, expansions expand.Config, expander *expand.Expander, embedder *embedding.Embedder) []strategy {
+	hybridStrategy := func(fusion string) strategy {
+		config := hybrid
+		config.Fusion = fusion
+		return strategy{
+			name: "hybrid-" + fusion,
+			retrieve: func(ctx context.Context, store search.VectorStore, queryText string, queryVector []float32, size int) ([]search.SearchHit, error) {
+				return store.QueryHybrid(ctx, queryText, queryVector, size, config, search.Filter{})
+			},
+		}
+	}
+
+	expandStrategy := func(name string, config expand.Config) strategy {
+		return strategy{
+			name: "chunks+questions+" + name,
+			retrieve: func(ctx context.Context, store search.VectorStore, queryText string, _ []float32, size int) ([]search.SearchHit, error) {
+				variants, err := expander.Expand(ctx, queryText, config)
+				if err != nil {
+					return nil, err
+				}
+				inputs := make([]string, len(variants))
+				for i := range variants {
+					inputs[i] = variants[i].Text
+				}
+				vectors, _, err := embedder.Embed(ctx, inputs)
+				if err != nil {
+					return nil, fmt.Errorf("failed to generate vectors for query variants: %w", err)
+				}
+				return expand.Search(ctx, variants, vectors, size, func(ctx context.Context, _ string, vector []float32) ([]search.SearchHit, error) {
+					return store.QueryEmbedding(ctx, vector, size, size*2, search.Filter{})
+				})
+			},
+		}
+	}
+
+	compared := []strategy{
+		{
+			name: "chunks",
+			retrieve: func(ctx context.Context, store search.VectorStore, _ string, queryVector []float32, size int) ([]search.SearchHit, error) {
+				return store.QueryChunks(ctx, queryVector, size, size, search.Filter{})
+			},
+		},
+		{
+			name: "chunks+questions",
+			retrieve: func(ctx context.Context, store search.VectorStore, _ string, queryVector []float32, size int) ([]search.SearchHit, error) {
+				return store.QueryEmbedding(ctx, queryVector, size, size*2, search.Filter{})
+			},
+		},
+		{
+			name: "chunks+questions+mmr",
+			retrieve: func(ctx context.Context, store search.VectorStore, _ string, queryVector []float32, size int) ([]search.SearchHit, error) {
+				candidates := diversity.CandidateCount(size)
+				results, err := store.QueryEmbedding(ctx, queryVector, candidates, candidates*2, search.Filter{})
+				if err != nil {
+					return nil, err
+				}
+				return search.Diversify(queryVector, results, size, diversity), nil
+			},
+		},
+		hybridStrategy(search.FusionRRF),
+		hybridStrategy(search.FusionWeighted),
+	}
+	if expansions.SubQueries {
+		compared = append(compared, expandStrategy("subqueries", expand.Config{SubQueries: true}))
+	}
+	if expansions.HyDE {
+		compared = append(compared, expandStrategy("hyde", expand.Config{HyDE: true}))
+	}
+	if expansions.Glossary {
+		compared = append(compared, expandStrategy("glossary", expand.Config{Glossary: true}))
+	}
+	return compared
+}
+
+// Evaluate measures how well each retrieval strategy finds the episode that answers a set of known queries, by
+// the fraction of queries where the episode is in the results at all and the mean reciprocal rank of its first
+// appearance
+func Evaluate(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+
+	queriesFile, err := os.Open(ctx.String("queries"))
+	if err != nil {
+		return fmt.Errorf("failed to open evaluation queries: %w", err)
+	}
+	defer queriesFile.Close()
+
+	var queries []evalQuery
+	scanner := bufio.NewScanner(queriesFile)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var q evalQuery
+		if err := json.Unmarshal(scanner.Bytes(), &q); err != nil {
+			return fmt.Errorf("failed to parse evaluation query %q: %w", scanner.Text(), err)
+		}
+		queries = append(queries, q)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read evaluation queries: %w", err)
+	}
+	if len(queries) == 0 {
+		return fmt.Errorf("no evaluation queries found")
+	}
+
+	inputs := make([]string, len(queries))
+	for i := range queries {
+		inputs[i] = queries[i].Query
+	}
+	openaiClient := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	embedder := embedding.NewEmbedder(openaiClient, embedding.EmbedderConfig{Model: openai.EmbeddingModel(model.Name), MaxRetries: 3})
+	queryVectors, _, err := embedder.Embed(ctx.Context, inputs)
+	if err != nil {
+		return fmt.Errorf("failed to generate vectors for evaluation queries: %w", err)
+	}
+
+	store, err := search.OpenStore(ctx.String("store"), dataDirectory, model.Name)
+	if err != nil {
+		return err
+	}
+
+	diversity := query.DiversityConfig(ctx)
+	if err := diversity.Validate(); err != nil {
+		return err
+	}
+
+	size := ctx.Int("size")
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "strategy\thit@%d\tMRR\n", size)
+	expander, err := expand.NewExpander(openaiClient, dataDirectory)
+	if err != nil {
+		return err
+	}
+
+	for _, s := range strategies(query.HybridConfig(ctx), diversity, query.ExpandConfig(ctx), expander, embedder) {
+		var hits int
+		var reciprocalRanks float64
+		for i, q := range queries {
+			results, err := s.retrieve(ctx.Context, store, q.Query, queryVectors[i], size)
+			if err != nil {
+				return fmt.Errorf("%s failed for query %q: %w", s.name, q.Query, err)
+			}
+			for rank, result := range results {
+				if result.GUID == q.GUID {
+					hits++
+					reciprocalRanks += 1 / float64(rank+1)
+					break
+				}
+			}
+		}
+		fmt.Fprintf(w, "%s\t%.3f\t%.3f\n", s.name, float64(hits)/float64(len(queries)), reciprocalRanks/float64(len(queries)))
+	}
+
+	return w.Flush()
+}
@@ -1,112 +1,504 @@
 package index
 
 import (
-	"bytes"
-	"crypto/tls"
-	"encoding/json"
+	"context"
 	"fmt"
-	"net/http"
-	"os"
-	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/opensearch-project/opensearch-go"
-	"github.com/opensearch-project/opensearch-go/opensearchapi"
 	"github.com/urfave/cli/v2"
 
 	"oxide-search/embedding"
+	"oxide-search/entities"
 	"oxide-search/manifest"
+	"oxide-search/questions"
 	"oxide-search/search"
+	"oxide-search/summary"
+	"oxide-search/topics"
 )
 
 const (
 	dataDirectory = "data"
 )
 
+var Flags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model whose index to manage, defaults to the active model",
+	},
+}, append(SettingsFlags, BulkFlags...)...)
+
+// StoreFlag picks which kind of vector store to index into or search
+var StoreFlag = &cli.StringFlag{
+	Name:    "store",
+	Usage:   "vector store to use, either opensearch or local for an embedded store in the data directory that needs no external services",
+	Value:   search.StoreOpenSearch,
+	EnvVars: []string{"OXIDE_VECTOR_STORE"},
+}
+
+var IndexFlags = append([]cli.Flag{
+	StoreFlag,
+	&cli.BoolFlag{
+		Name:  "force",
+		Usage: "reindex every episode, even the ones that haven't changed since they were last indexed",
+	},
+}, Flags...)
+
+// BulkFlags configure how documents are written to the index
+var BulkFlags = []cli.Flag{
+	&cli.IntFlag{
+		Name:  "bulk-max-bytes",
+		Usage: "maximum size of a single bulk indexing request",
+		Value: search.DefaultBulkConfig().MaxBytes,
+	},
+	&cli.IntFlag{
+		Name:  "bulk-retries",
+		Usage: "number of times to retry documents that failed with a retryable error",
+		Value: search.DefaultBulkConfig().MaxRetries,
+	},
+	&cli.BoolFlag{
+		Name:  "refresh",
+		Usage: "wait for indexed documents to become visible to searches",
+	},
+}
+
+// BulkConfig builds the bulk indexing configuration from BulkFlags
+func BulkConfig(ctx *cli.Context) search.BulkConfig {
+	config := search.DefaultBulkConfig()
+	config.MaxBytes = ctx.Int("bulk-max-bytes")
+	config.MaxRetries = ctx.Int("bulk-retries")
+	config.WaitForRefresh = ctx.Bool("refresh")
+	config.OnRetry = func(index string, retrying int, items int) {
+		fmt.Printf("retrying %d of %d bulk items to %s\n", retrying, items, index)
+	}
+	return config
+}
+
+// SettingsFlags configure the knn settings of newly created indexes
+var SettingsFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "space-type",
+		Usage: "vector similarity space for the knn index",
+		Value: "cosinesimil",
+	},
+	&cli.StringFlag{
+		Name:  "engine",
+		Usage: "knn engine to build new versions of the index with, one of lucene, faiss or nmslib. Only lucene and faiss filter while searching, filtered searches of an nmslib index are exact searches",
+		Value: "lucene",
+	},
+	&cli.IntFlag{
+		Name:  "m",
+		Usage: "number of bidirectional links per node in the HNSW graph",
+		Value: 16,
+	},
+	&cli.IntFlag{
+		Name:  "ef-construction",
+		Usage: "size of the candidate list used while building the HNSW graph",
+		Value: 100,
+	},
+	&cli.IntFlag{
+		Name:  "ef-search",
+		Usage: "size of the candidate list used while searching the HNSW graph",
+		Value: 100,
+	},
+}
+
+// Settings builds the settings for a models index from SettingsFlags
+func Settings(ctx *cli.Context, model embedding.Model) search.IndexSettings {
+	settings := search.DefaultIndexSettings(model.Dimension)
+	settings.SpaceType = ctx.String("space-type")
+	settings.Engine = ctx.String("engine")
+	settings.M = ctx.Int("m")
+	settings.EfConstruction = ctx.Int("ef-construction")
+	settings.EfSearch = ctx.Int("ef-search")
+	return settings
+}
+
 func Index(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+
+	switch ctx.String("store") {
+	case search.StoreLocal:
+		return IndexLocal(model)
+	case search.StoreOpenSearch:
+	default:
+		return fmt.Errorf("unknown vector store %q, expected %s or %s", ctx.String("store"), search.StoreOpenSearch, search.StoreLocal)
+	}
+
+	client, err := search.NewClient()
+	if err != nil {
+		return err
+	}
+
+	return IndexModel(ctx.Context, client, model, Settings(ctx, model), BulkConfig(ctx), ctx.Bool("force"))
+}
+
+// IndexLocal writes every episodes documents from a model into a fresh local store, replacing the old one
+func IndexLocal(model embedding.Model) error {
 	manifestData, err := manifest.Load()
 	if err != nil {
 		return fmt.Errorf("failed to load data manifest: %w", err)
 	}
 
-	client, err := opensearch.NewClient(opensearch.Config{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-		Addresses: []string{"https://localhost:9200"},
-		Username:  "admin", // For testing only. Don't store credentials in code.
-		Password:  "admin",
-	})
+	clustering, err := topics.Load(dataDirectory, model.Name)
+	if err != nil {
+		return err
+	}
+
+	store := search.NewLocalStore(search.LocalStorePath(dataDirectory, search.IndexName(model.Name)))
+	for _, episode := range manifestData.Episodes {
+		documents, err := episodeDocuments(episode, model, clustering)
+		if err != nil {
+			return err
+		}
+		store.Put(documents...)
+	}
+
+	err = store.Save()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Indexed %d %s documents into the local store\n", store.Len(), model.Name)
+	return nil
+}
+
+// IndexModel loads the embeddings from a model into the live version of that models index, building the first
+// version if there isn't one yet. It refuses to write to an index whose mapping doesn't match the settings.
+// Episodes which haven't changed since they were last indexed are skipped unless force is set
+func IndexModel(ctx context.Context, client *opensearch.Client, model embedding.Model, settings search.IndexSettings, bulk search.BulkConfig, force bool) error {
+	alias := search.IndexName(model.Name)
+	live, err := search.LiveIndex(ctx, client, alias)
+	if err != nil {
+		return err
+	}
+	if live == "" {
+		fmt.Printf("index %s does not exist yet, building its first version\n", alias)
+		return Rebuild(ctx, client, model, settings, bulk, 0)
+	}
+
+	err = search.EnsureIndex(ctx, client, live, settings)
+	if err != nil {
+		return err
+	}
+
+	state, err := loadState()
+	if err != nil {
+		return err
+	}
+	if force {
+		delete(state, live)
+	}
+
+	_, _, err = indexEpisodes(ctx, client, live, model, bulk, state, true)
+	return err
+}
+
+// Rebuild indexes everything from a model into a brand new version of its index, and only once that version has
+// passed a sanity check is the alias swapped over to it. The previous versions are kept around for rolling back,
+// unless keep is set, in which case only that many of the newest versions are kept
+func Rebuild(ctx context.Context, client *opensearch.Client, model embedding.Model, settings search.IndexSettings, bulk search.BulkConfig, keep int) error {
+	alias := search.IndexName(model.Name)
+	versions, err := search.IndexVersions(ctx, client, alias)
+	if err != nil {
+		return err
+	}
+	next := search.VersionName(alias, 1)
+	if len(versions) > 0 {
+		next = search.VersionName(alias, versions[len(versions)-1].Version+1)
+	}
+
+	state, err := loadState()
+	if err != nil {
+		return err
+	}
+
+	err = search.CreateIndex(ctx, client, next, settings)
+	if err != nil {
+		return err
+	}
+	count, probe, err := indexEpisodes(ctx, client, next, model, bulk, state, false)
+	if err != nil {
+		return fmt.Errorf("failed to build %s, %s has been left in place: %w", next, alias, err)
+	}
+	if probe == nil {
+		return fmt.Errorf("no documents were indexed into %s, %s has been left in place", next, alias)
+	}
+	err = search.SanityCheck(ctx, client, next, count, *probe)
+	if err != nil {
+		return fmt.Errorf("%s failed its sanity check, %s has been left in place: %w", next, alias, err)
+	}
+
+	err = search.SwapAlias(ctx, client, alias, next)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s now points to %s\n", alias, next)
+
+	if keep > 0 && len(versions)+1 > keep {
+		var deleted []string
+		for _, old := range versions[:len(versions)+1-keep] {
+			err = search.DeleteIndex(ctx, client, old.Name)
+			if err != nil {
+				return err
+			}
+			deleted = append(deleted, old.Name)
+			fmt.Printf("deleted old index version %s\n", old.Name)
+		}
+		return state.forget(deleted...)
+	}
+
+	return nil
+}
+
+// indexEpisodes writes every episodes documents from a model into a physical index, returning how many documents
+// were written and one of them to probe the index with. When the index already holds documents, episodes whose
+// documents are unchanged since they were last indexed are skipped, and any documents left over from an older
+// version of a changed episode are deleted
+func indexEpisodes(ctx context.Context, client *opensearch.Client, index string, model embedding.Model, bulk search.BulkConfig, state indexState, existing bool) (int, *search.Document, error) {
+	manifestData, err := manifest.Load()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to load data manifest: %w", err)
+	}
+
+	clustering, err := topics.Load(dataDirectory, model.Name)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	indexer := search.NewBulkIndexer(client, index, bulk)
+	var probe *search.Document
+
+	previous := state[index]
+	indexed := make(map[string]episodeState, len(manifestData.Episodes))
+	// changed are the reindexed episodes and the ids of their documents, anything else of theirs is stale
+	type changedEpisode struct {
+		GUID string
+		Ids  []string
+	}
+	var changed []changedEpisode
+	skipped, deleted := 0, 0
 
 	// For each episode, load the embeddings and index them into opensearch in a document that includes their
 	// text content and some episode information
 	for _, episode := range manifestData.Episodes {
-		embeddingBytes, err := os.ReadFile(filepath.Join(dataDirectory, fmt.Sprintf("%s.embeddings.json", episode.GUID)))
+		documents, err := episodeDocuments(episode, model, clustering)
 		if err != nil {
-			return fmt.Errorf("could not load episode embeddings: %w", err)
+			return 0, nil, err
+		}
+		if probe == nil && len(documents) > 0 {
+			probe = &documents[0]
 		}
 
-		var embeddings []embedding.Storage
-		err = json.Unmarshal(embeddingBytes, &embeddings)
+		hash, err := documentsHash(documents)
 		if err != nil {
-			return fmt.Errorf("could not load episode embeddings: %w", err)
+			return 0, nil, err
+		}
+		current := episodeState{Hash: hash, Docs: len(documents)}
+		if existing && previous[episode.GUID] == current {
+			indexed[episode.GUID] = current
+			skipped++
+			continue
 		}
 
-		var bulkRequest bytes.Buffer
-
-		for i, e := range embeddings {
-			var doc search.Document
-			doc.Id = fmt.Sprintf("episode-%s-embedding-%d", episode.GUID, i)
-			doc.Title = episode.Title
-			doc.GUID = episode.GUID
-			doc.Published = episode.Published
-			doc.Link = episode.Link
-			doc.Description = episode.Description
-			doc.VectorId = i
-
-			doc.Transcript = e.Content
-			doc.Vectors = e.Vector
-			docBody, err := json.Marshal(doc)
+		for _, doc := range documents {
+			err = indexer.Add(ctx, doc)
 			if err != nil {
-				return fmt.Errorf("failed to build search document for episode %s, embedding %d: %w", episode.GUID, i, err)
+				return 0, nil, err
 			}
-			indexRequestBody, err := json.Marshal(
-				struct {
-					Index struct {
-						IndexName string `json:"_index"`
-						Id        string `json:"_id"`
-					} `json:"index"`
-				}{
-					struct {
-						IndexName string `json:"_index"`
-						Id        string `json:"_id"`
-					}{
-						"oxide",
-						fmt.Sprintf("episode-%s-embedding-%d", episode.GUID, i),
-					},
-				})
-			if err != nil {
-				return fmt.Errorf("failed to build indexing directive for episode %s, embedding %d: %w", episode.GUID, i, err)
+		}
+
+		if existing {
+			ids := make([]string, len(documents))
+			for i, doc := range documents {
+				ids[i] = doc.Id
 			}
+			changed = append(changed, changedEpisode{GUID: episode.GUID, Ids: ids})
+		}
 
-			bulkRequest.WriteString(string(indexRequestBody) + "\n")
-			bulkRequest.WriteString(string(docBody) + "\n")
+		indexed[episode.GUID] = current
+		fmt.Printf("Queued %d %s documents for %s (%s)\n", len(documents), model.Name, episode.GUID, episode.Title)
+	}
+
+	err = indexer.Close(ctx)
+	if err != nil {
+		return 0, nil, err
+	}
+	// Stale documents are only deleted once every replacement has been written, so a failed write never leaves an
+	// episode missing from the index
+	for _, episode := range changed {
+		stale, err := search.DeleteStale(ctx, client, index, episode.GUID, episode.Ids)
+		if err != nil {
+			return 0, nil, err
 		}
+		deleted += stale
+	}
+	fmt.Printf("Indexed %d documents into %s, skipped %d unchanged episodes and deleted %d stale documents\n", indexer.Written(), index, skipped, deleted)
 
-		req := opensearchapi.BulkRequest{
-			Index: "oxide",
-			Body:  bytes.NewReader(bulkRequest.Bytes()),
+	// Episodes that have been dropped from the manifest are left alone until they're pruned
+	if existing {
+		for guid, s := range previous {
+			if _, ok := indexed[guid]; !ok {
+				indexed[guid] = s
+			}
 		}
+	}
+	state[index] = indexed
+	err = state.save()
+	if err != nil {
+		return 0, nil, err
+	}
 
-		insertResponse, err := req.Do(ctx.Context, client)
-		if err != nil {
-			return fmt.Errorf("error indexing embedding for episode %s %w", episode.GUID, err)
+	return indexer.Written(), probe, nil
+}
+
+// episodeDocuments builds every search document for an episode, its transcript chunks along with whatever
+// summaries and questions have been embedded by the model
+func episodeDocuments(episode manifest.EpisodeData, model embedding.Model, clustering *topics.Clustering) ([]search.Document, error) {
+	embeddings, err := embedding.Load(dataDirectory, model.Name, episode.GUID)
+	if err != nil {
+		return nil, err
+	}
+	embedding.RecoverOffsets(episode.Transcript, embeddings)
+	episodeEntities, err := entities.Load(dataDirectory, episode.GUID)
+	if err != nil {
+		return nil, err
+	}
+
+	published := publishedAt(episode)
+	documents := make([]search.Document, 0, len(embeddings))
+	for _, e := range embeddings {
+		var doc search.Document
+		doc.Id = fmt.Sprintf("episode-%s-embedding-%d", episode.GUID, e.Chunk)
+		doc.Title = episode.Title
+		doc.GUID = episode.GUID
+		doc.Published = episode.Published
+		doc.PublishedAt = published
+		doc.Link = episode.Link
+		doc.Description = episode.Description
+		doc.Links = episode.Links
+		doc.DocType = search.DocTypeChunk
+		doc.VectorId = e.Chunk
+		doc.Offset = e.Offset
+		if topic := clustering.ChunkTopic(episode.GUID, e.Chunk); topic != "" {
+			doc.Topics = []string{topic}
 		}
-		if insertResponse.StatusCode >= 300 {
-			return fmt.Errorf("unexpected indexing response writing embeddings for episode %s: %s", episode.GUID, insertResponse.String())
+		doc.Entities = episodeEntities.ChunkNames(e.Chunk)
+		doc.Timestamps = episode.TimestampsBetween(e.Offset, e.Offset+len(strings.Fields(e.Content)))
+
+		doc.Transcript = e.Content
+		doc.Vectors = e.Vector
+		documents = append(documents, doc)
+	}
+
+	summaryDocuments, err := summaryDocuments(episode, published, model, clustering)
+	if err != nil {
+		return nil, err
+	}
+	for i := range summaryDocuments {
+		summaryDocuments[i].Entities = episodeEntities.Names()
+	}
+	documents = append(documents, summaryDocuments...)
+
+	questionDocuments, err := questionDocuments(episode, published, model, clustering)
+	if err != nil {
+		return nil, err
+	}
+	for i := range questionDocuments {
+		questionDocuments[i].Entities = episodeEntities.ChunkNames(questionDocuments[i].VectorId)
+	}
+	documents = append(documents, questionDocuments...)
+
+	return documents, nil
+}
+
+// summaryDocuments builds search documents for an episodes summary and chapter summaries, if they have been
+// generated and embedded by the model
+func summaryDocuments(episode manifest.EpisodeData, published *time.Time, model embedding.Model, clustering *topics.Clustering) ([]search.Document, error) {
+	episodeSummary, err := summary.Load(dataDirectory, episode.GUID)
+	if err != nil || episodeSummary == nil {
+		return nil, err
+	}
+
+	base := search.Document{EpisodeData: episode, PublishedAt: published}
+	base.Transcript = ""
+	base.Timestamps = nil
+	base.Topics = clustering.EpisodeTopics(episode.GUID)
+
+	var documents []search.Document
+	if vector, ok := episodeSummary.Vectors[model.Name]; ok {
+		doc := base
+		doc.Id = fmt.Sprintf("episode-%s-summary", episode.GUID)
+		doc.DocType = search.DocTypeSummary
+		doc.Summary = episodeSummary.Summary
+		doc.Vectors = vector
+		documents = append(documents, doc)
+	}
+	for i, chapter := range episodeSummary.Chapters {
+		vector, ok := chapter.Vectors[model.Name]
+		if !ok {
+			continue
 		}
+		doc := base
+		doc.Id = fmt.Sprintf("episode-%s-chapter-%d", episode.GUID, i)
+		doc.DocType = search.DocTypeChapter
+		doc.VectorId = i
+		doc.Summary = chapter.Summary
+		doc.Chapter = chapter.Title
+		doc.Vectors = vector
+		documents = append(documents, doc)
+	}
 
-		fmt.Printf("Indexed %d embedding documents for %s (%s)\n", len(embeddings), episode.GUID, episode.Title)
+	return documents, nil
+}
+
+// questionDocuments builds search documents for the synthetic questions generated for an episodes chunks, if they
+// have been generated and embedded by the model. Each one points back to the chunk it was generated from
+func questionDocuments(episode manifest.EpisodeData, published *time.Time, model embedding.Model, clustering *topics.Clustering) ([]search.Document, error) {
+	episodeQuestions, err := questions.Load(dataDirectory, episode.GUID)
+	if err != nil || episodeQuestions == nil {
+		return nil, err
 	}
 
-	return nil
+	base := search.Document{EpisodeData: episode, PublishedAt: published}
+	base.Transcript = ""
+	base.Timestamps = nil
+
+	var documents []search.Document
+	for _, chunk := range episodeQuestions.Chunks {
+		vectors := chunk.Vectors[model.Name]
+		if len(vectors) != len(chunk.Questions) {
+			continue
+		}
+		parentId := fmt.Sprintf("episode-%s-embedding-%d", episode.GUID, chunk.VectorId)
+		for i, question := range chunk.Questions {
+			doc := base
+			doc.Id = fmt.Sprintf("%s-question-%d", parentId, i)
+			doc.DocType = search.DocTypeQuestion
+			doc.VectorId = chunk.VectorId
+			doc.Question = question
+			doc.ParentId = parentId
+			if topic := clustering.ChunkTopic(episode.GUID, chunk.VectorId); topic != "" {
+				doc.Topics = []string{topic}
+			}
+			doc.Vectors = vectors[i]
+			documents = append(documents, doc)
+		}
+	}
+
+	return documents, nil
+}
+
+// publishedAt parses when an episode was published, once for all of its documents. An episode whose date can't be
+// parsed is reported and indexed without one, so date filters leave it out
+func publishedAt(episode manifest.EpisodeData) *time.Time {
+	published, err := episode.PublishedTime()
+	if err != nil {
+		fmt.Printf("indexing episode %s without a publication date: %s\n", episode.GUID, err)
+		return nil
+	}
+	return &published
 }
@@ -0,0 +1,324 @@
+package index
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"oxide-search/embedding"
+	"oxide-search/manifest"
+	"oxide-search/search"
+)
+
+var RebuildFlags = append([]cli.Flag{
+	&cli.IntFlag{
+		Name:  "keep",
+		Usage: "number of the newest index versions to keep after rebuilding, 0 keeps all of them",
+	},
+}, Flags...)
+
+var DeleteFlags = append([]cli.Flag{
+	&cli.IntFlag{
+		Name:  "version",
+		Usage: "index version to delete, the live version can't be deleted",
+	},
+	&cli.BoolFlag{
+		Name:  "all",
+		Usage: "delete every version of the index, including the live one",
+	},
+}, Flags...)
+
+var PruneFlags = append([]cli.Flag{
+	&cli.BoolFlag{
+		Name:  "dry-run",
+		Usage: "only list the episodes that would be pruned",
+	},
+}, Flags...)
+
+var RollbackFlags = append([]cli.Flag{
+	&cli.IntFlag{
+		Name:  "to",
+		Usage: "index version to roll back to, defaults to the version before the live one",
+	},
+}, Flags...)
+
+// Create creates an empty new version of a models index, with the knn settings from the command line. It only
+// becomes live if there is no live version yet
+func Create(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	client, err := search.NewClient()
+	if err != nil {
+		return err
+	}
+
+	alias := search.IndexName(model.Name)
+	versions, err := search.IndexVersions(ctx.Context, client, alias)
+	if err != nil {
+		return err
+	}
+	index := search.VersionName(alias, 1)
+	if len(versions) > 0 {
+		index = search.VersionName(alias, versions[len(versions)-1].Version+1)
+	}
+
+	err = search.CreateIndex(ctx.Context, client, index, Settings(ctx, model))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("created index %s for %d dimension %s vectors\n", index, model.Dimension, model.Name)
+
+	live, err := search.LiveIndex(ctx.Context, client, alias)
+	if err != nil {
+		return err
+	}
+	if live == "" {
+		err = search.SwapAlias(ctx.Context, client, alias, index)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s now points to %s\n", alias, index)
+	}
+	return nil
+}
+
+func Delete(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	client, err := search.NewClient()
+	if err != nil {
+		return err
+	}
+
+	alias := search.IndexName(model.Name)
+	versions, err := search.IndexVersions(ctx.Context, client, alias)
+	if err != nil {
+		return err
+	}
+
+	var toDelete []string
+	switch {
+	case ctx.Bool("all"):
+		for _, v := range versions {
+			toDelete = append(toDelete, v.Name)
+		}
+		// Also clean up an index from before versioning that's sitting on the alias name
+		live, err := search.LiveIndex(ctx.Context, client, alias)
+		if err != nil {
+			return err
+		}
+		if live == alias {
+			toDelete = append(toDelete, alias)
+		}
+	case ctx.IsSet("version"):
+		for _, v := range versions {
+			if v.Version != ctx.Int("version") {
+				continue
+			}
+			if v.Live {
+				return fmt.Errorf("%s is live, roll back or rebuild before deleting it", v.Name)
+			}
+			toDelete = append(toDelete, v.Name)
+		}
+		if len(toDelete) == 0 {
+			return fmt.Errorf("%s has no version %d", alias, ctx.Int("version"))
+		}
+	default:
+		return fmt.Errorf("pass either --version or --all to choose which versions of %s to delete", alias)
+	}
+
+	state, err := loadState()
+	if err != nil {
+		return err
+	}
+	for _, index := range toDelete {
+		err = search.DeleteIndex(ctx.Context, client, index)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("deleted index %s\n", index)
+	}
+	return state.forget(toDelete...)
+}
+
+// Describe prints the settings and mapping of the live version of a models index, and whether they match the
+// command line settings
+func Describe(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	client, err := search.NewClient()
+	if err != nil {
+		return err
+	}
+
+	alias := search.IndexName(model.Name)
+	description, err := search.DescribeIndex(ctx.Context, client, alias)
+	if err != nil {
+		return err
+	}
+	if description == nil {
+		return fmt.Errorf("index %s does not exist", alias)
+	}
+
+	fmt.Print(description)
+	settings := Settings(ctx, model)
+	if err := search.CheckMapping(description, settings); err != nil {
+		fmt.Println(err)
+	} else {
+		fmt.Println("mapping matches")
+	}
+	if description.Settings.Engine != settings.Engine {
+		fmt.Printf("built with the %s engine rather than %s, run index rebuild to switch engines\n", description.Settings.Engine, settings.Engine)
+	}
+	return nil
+}
+
+func RebuildIndex(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	client, err := search.NewClient()
+	if err != nil {
+		return err
+	}
+
+	return Rebuild(ctx.Context, client, model, Settings(ctx, model), BulkConfig(ctx), ctx.Int("keep"))
+}
+
+func Versions(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	client, err := search.NewClient()
+	if err != nil {
+		return err
+	}
+
+	alias := search.IndexName(model.Name)
+	versions, err := search.IndexVersions(ctx.Context, client, alias)
+	if err != nil {
+		return err
+	}
+	if len(versions) == 0 {
+		fmt.Printf("%s has no versions\n", alias)
+	}
+	for _, v := range versions {
+		live := ""
+		if v.Live {
+			live = " (live)"
+		}
+		fmt.Printf("%s: %d documents%s\n", v.Name, v.DocCount, live)
+	}
+	return nil
+}
+
+// Rollback points the alias of a models index back at an older version
+func Rollback(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	client, err := search.NewClient()
+	if err != nil {
+		return err
+	}
+
+	alias := search.IndexName(model.Name)
+	versions, err := search.IndexVersions(ctx.Context, client, alias)
+	if err != nil {
+		return err
+	}
+
+	var target *search.IndexVersion
+	for i, v := range versions {
+		if ctx.IsSet("to") && v.Version == ctx.Int("to") {
+			target = &versions[i]
+		}
+		if !ctx.IsSet("to") && v.Live && i > 0 {
+			target = &versions[i-1]
+		}
+	}
+	if target == nil {
+		return fmt.Errorf("no version of %s to roll back to", alias)
+	}
+
+	err = search.SwapAlias(ctx.Context, client, alias, target.Name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s now points to %s\n", alias, target.Name)
+	return nil
+}
+
+// Prune deletes every document in the live version of a models index that belongs to an episode which has been
+// dropped from the manifest
+func Prune(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	client, err := search.NewClient()
+	if err != nil {
+		return err
+	}
+
+	manifestData, err := manifest.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load data manifest: %w", err)
+	}
+	inManifest := make(map[string]bool, len(manifestData.Episodes))
+	for _, episode := range manifestData.Episodes {
+		inManifest[episode.GUID] = true
+	}
+
+	alias := search.IndexName(model.Name)
+	live, err := search.LiveIndex(ctx.Context, client, alias)
+	if err != nil {
+		return err
+	}
+	if live == "" {
+		return fmt.Errorf("index %s does not exist", alias)
+	}
+
+	indexed, err := search.IndexedEpisodes(ctx.Context, client, live)
+	if err != nil {
+		return err
+	}
+	var orphans []string
+	for _, guid := range indexed {
+		if !inManifest[guid] {
+			orphans = append(orphans, guid)
+			fmt.Printf("episode %s is no longer in the manifest\n", guid)
+		}
+	}
+	if len(orphans) == 0 {
+		fmt.Printf("%s has nothing to prune\n", live)
+		return nil
+	}
+	if ctx.Bool("dry-run") {
+		return nil
+	}
+
+	deleted, err := search.DeleteEpisodes(ctx.Context, client, live, orphans)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("deleted %d documents for %d episodes from %s\n", deleted, len(orphans), live)
+
+	state, err := loadState()
+	if err != nil {
+		return err
+	}
+	for _, guid := range orphans {
+		delete(state[live], guid)
+	}
+	return state.save()
+}
@@ -0,0 +1,87 @@
+package index
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"oxide-search/search"
+)
+
+const (
+	stateFile = "index-state.json"
+)
+
+// episodeState is what was last indexed for an episode, so that unchanged episodes can be skipped
+type episodeState struct {
+	// Hash covers every document indexed for the episode, including their vectors
+	Hash string
+	Docs int
+}
+
+// indexState tracks the indexed episodes of each physical index, keyed by index name and then episode GUID
+type indexState map[string]map[string]episodeState
+
+func loadState() (indexState, error) {
+	stateBytes, err := os.ReadFile(filepath.Join(dataDirectory, stateFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return indexState{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read index state: %w", err)
+	}
+
+	var state indexState
+	err = json.Unmarshal(stateBytes, &state)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse index state: %w", err)
+	}
+	if state == nil {
+		state = indexState{}
+	}
+	return state, nil
+}
+
+func (s indexState) save() error {
+	stateBytes, err := json.MarshalIndent(s, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal index state: %w", err)
+	}
+	tmp := filepath.Join(dataDirectory, stateFile+".tmp")
+	err = os.WriteFile(tmp, stateBytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write index state: %w", err)
+	}
+	err = os.Rename(tmp, filepath.Join(dataDirectory, stateFile))
+	if err != nil {
+		return fmt.Errorf("failed to write index state: %w", err)
+	}
+	return nil
+}
+
+// forget drops the state of indexes that have been deleted
+func (s indexState) forget(indexes ...string) error {
+	for _, index := range indexes {
+		delete(s, index)
+	}
+	return s.save()
+}
+
+// documentsHash fingerprints the documents built for an episode, any change to their content, vectors or count
+// changes the hash
+func documentsHash(documents []search.Document) (string, error) {
+	hash := sha256.New()
+	for _, doc := range documents {
+		docBytes, err := json.Marshal(doc)
+		if err != nil {
+			return "", fmt.Errorf("failed to build search document %s: %w", doc.Id, err)
+		}
+		hash.Write(docBytes)
+		hash.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
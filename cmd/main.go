@@ -4,8 +4,14 @@ import (
 	"log"
 	"os"
 	"oxide-search/cmd/embeddings"
+	"oxide-search/cmd/entities"
+	"oxide-search/cmd/eval"
 	"oxide-search/cmd/index"
+	"oxide-search/cmd/migrate"
 	"oxide-search/cmd/query"
+	"oxide-search/cmd/questions"
+	"oxide-search/cmd/summarize"
+	"oxide-search/cmd/topics"
 	"oxide-search/cmd/transcribe"
 
 	"github.com/urfave/cli/v2"
@@ -33,17 +39,148 @@ func main() {
 				Aliases: []string{"e"},
 				Usage:   "Generate embeddings from transcriptions",
 				Action:  embeddings.Embed,
+				Flags:   embeddings.Flags,
+			},
+			{
+				Name:   "summarize",
+				Usage:  "Generate and embed summaries of each episode and its chapters, for hierarchical search",
+				Action: summarize.Summarize,
+				Flags:  summarize.Flags,
+			},
+			{
+				Name:   "questions",
+				Usage:  "Generate and embed synthetic questions that each transcript chunk answers",
+				Action: questions.GenerateQuestions,
+				Flags:  questions.Flags,
+			},
+			{
+				Name:   "topics",
+				Usage:  "Cluster the transcript chunks into topics and have GPT label them, index stores them on the chunks and episodes",
+				Action: topics.Topics,
+				Flags:  topics.Flags,
+			},
+			{
+				Name:   "entities",
+				Usage:  "Extract the companies, people, products, projects and papers named in the transcripts, and write a glossary page for each",
+				Action: entities.Extract,
+				Flags:  entities.Flags,
+			},
+			{
+				Name:   "convert-embeddings",
+				Usage:  "Convert stored embeddings between formats, and optionally export them for numpy",
+				Action: embeddings.Convert,
+				Flags:  embeddings.ConvertFlags,
 			},
 			{
 				Name:    "index",
 				Aliases: []string{"i"},
-				Usage:   "Load embeddings into an Opensearch index",
+				Usage:   "Load embeddings into an Opensearch index, skipping episodes that haven't changed",
 				Action:  index.Index,
+				Flags:   index.IndexFlags,
+				Subcommands: []*cli.Command{
+					{
+						Name:   "create",
+						Usage:  "Create an empty new version of the index for an embedding model",
+						Action: index.Create,
+						Flags:  index.Flags,
+					},
+					{
+						Name:   "delete",
+						Usage:  "Delete versions of the index for an embedding model",
+						Action: index.Delete,
+						Flags:  index.DeleteFlags,
+					},
+					{
+						Name:   "describe",
+						Usage:  "Show the settings and mapping of the live index for an embedding model",
+						Action: index.Describe,
+						Flags:  index.Flags,
+					},
+					{
+						Name:   "rebuild",
+						Usage:  "Index everything into a new version of the index, and swap it live once it passes a sanity check",
+						Action: index.RebuildIndex,
+						Flags:  index.RebuildFlags,
+					},
+					{
+						Name:   "versions",
+						Usage:  "List the versions of the index for an embedding model",
+						Action: index.Versions,
+						Flags:  index.Flags,
+					},
+					{
+						Name:   "rollback",
+						Usage:  "Point the index alias back at an older version",
+						Action: index.Rollback,
+						Flags:  index.RollbackFlags,
+					},
+					{
+						Name:   "prune",
+						Usage:  "Delete documents for episodes that are no longer in the manifest from the live index",
+						Action: index.Prune,
+						Flags:  index.PruneFlags,
+					},
+				},
 			}, {
 				Name:    "query",
 				Aliases: []string{"q"},
 				Usage:   "Make a query with embedding context",
 				Action:  query.Query,
+				Flags:   query.Flags,
+			},
+			{
+				Name:      "search",
+				Usage:     "Page through the transcript chunks nearest to a query, without asking GPT about them",
+				ArgsUsage: "<query>",
+				Action:    query.Search,
+				Flags:     query.SearchFlags,
+			},
+			{
+				Name:      "similar",
+				Usage:     "Find chunks from other episodes similar to a chunk",
+				ArgsUsage: "<chunk id>",
+				Action:    query.Similar,
+				Flags:     query.SimilarFlags,
+			},
+			{
+				Name:      "quote",
+				Usage:     "Find every place a phrase was said in the transcripts",
+				ArgsUsage: "<phrase>",
+				Action:    query.Quote,
+				Flags:     query.QuoteFlags,
+			},
+			{
+				Name:      "related",
+				Usage:     "Find the episodes most like an episode",
+				ArgsUsage: "<episode guid>",
+				Action:    query.Related,
+				Flags:     query.RelatedFlags,
+			},
+			{
+				Name:      "links",
+				Usage:     "Search the links in the show notes by their text or URL",
+				ArgsUsage: "[text]",
+				Action:    query.Links,
+				Flags:     query.LinksFlags,
+			},
+			{
+				Name:      "timeline",
+				Usage:     "Chart when and how often the archive discussed something, by the chunks similar to a query",
+				ArgsUsage: "<query>",
+				Action:    query.Timeline,
+				Flags:     query.TimelineFlags,
+			},
+			{
+				Name:   "eval",
+				Usage:  "Compare how well each retrieval strategy finds the episodes answering a set of known queries",
+				Action: eval.Evaluate,
+				Flags:  eval.Flags,
+			},
+			{
+				Name:   "migrate-embeddings",
+				Usage:  "Re-embed and index everything with a new embedding model, then make it the active model",
+				Action: migrate.MigrateEmbeddings,
+				Flags:  migrate.Flags,
 			},
 		},
 	}
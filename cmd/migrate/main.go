@@ -0,0 +1,91 @@
+package migrate
+
+import (
+	"fmt"
+
+	"github.com/urfave/cli/v2"
+
+	"oxide-search/cmd/embeddings"
+	"oxide-search/cmd/index"
+	"oxide-search/embedding"
+	"oxide-search/search"
+)
+
+const (
+	dataDirectory = "data"
+)
+
+var Flags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:     "to",
+		Usage:    "embedding model to migrate to",
+		Required: true,
+	},
+	&cli.StringFlag{
+		Name:  "format",
+		Usage: "format to store the new embeddings in, one of json, binary or binary16",
+		Value: string(embedding.FormatBinary),
+	},
+	&cli.BoolFlag{
+		Name:  "no-activate",
+		Usage: "embed and index with the new model, but leave the current model active",
+	},
+	index.StoreFlag,
+}, append(embeddings.EmbedderFlags, append(index.SettingsFlags, index.BulkFlags...)...)...)
+
+// MigrateEmbeddings re-embeds every transcript with a new model and indexes them into that models own index. The
+// active model keeps serving queries the whole time, and is only switched over once everything has finished
+func MigrateEmbeddings(ctx *cli.Context) error {
+	format, err := embedding.ParseFormat(ctx.String("format"))
+	if err != nil {
+		return err
+	}
+	target, err := embedding.LookupModel(ctx.String("to"))
+	if err != nil {
+		return err
+	}
+	active, err := embedding.ActiveModel(dataDirectory)
+	if err != nil {
+		return err
+	}
+	if active == target.Name {
+		return fmt.Errorf("%s is already the active embedding model", target.Name)
+	}
+
+	fmt.Printf("migrating embeddings from %s to %s, %s will stay active until the migration completes\n", active, target.Name, active)
+
+	err = embeddings.Generate(ctx.Context, embeddings.EmbedderConfig(ctx, target.Name), format, false)
+	if err != nil {
+		return fmt.Errorf("failed to generate %s embeddings: %w", target.Name, err)
+	}
+
+	err = indexModel(ctx, target)
+	if err != nil {
+		return fmt.Errorf("failed to index %s embeddings: %w", target.Name, err)
+	}
+
+	if ctx.Bool("no-activate") {
+		fmt.Printf("%s embeddings are indexed in %s, leaving %s active\n", target.Name, search.IndexName(target.Name), active)
+		return nil
+	}
+
+	err = embedding.SetActiveModel(dataDirectory, target.Name)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s is now the active embedding model, %s embeddings and index %s have been kept\n", target.Name, active, search.IndexName(active))
+
+	return nil
+}
+
+// indexModel builds a new version of the models index in whichever store was picked
+func indexModel(ctx *cli.Context, model embedding.Model) error {
+	if ctx.String("store") == search.StoreLocal {
+		return index.IndexLocal(model)
+	}
+	client, err := search.NewClient()
+	if err != nil {
+		return err
+	}
+	return index.Rebuild(ctx.Context, client, model, index.Settings(ctx, model), index.BulkConfig(ctx), 0)
+}
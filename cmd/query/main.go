@@ -1,56 +1,361 @@
 package query
 
 import (
-	"crypto/tls"
+	"context"
 	"fmt"
-	"net/http"
 	"os"
 	"oxide-search/meta"
 	"time"
 
-	"github.com/opensearch-project/opensearch-go"
 	"github.com/sashabaranov/go-openai"
 	"github.com/urfave/cli/v2"
 
+	"oxide-search/cmd/index"
+	"oxide-search/embedding"
+	"oxide-search/expand"
+	"oxide-search/rerank"
 	"oxide-search/search"
 )
 
+const (
+	dataDirectory = "data"
+)
+
+var Flags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model to search with, defaults to the active model",
+	},
+	&cli.BoolFlag{
+		Name:  "hierarchical",
+		Usage: "pick the most relevant episodes by their summaries first, then search for context within them",
+	},
+	&cli.IntFlag{
+		Name:  "episodes",
+		Usage: "number of episodes to search within for a hierarchical search",
+		Value: 3,
+	},
+	index.StoreFlag,
+	&cli.IntFlag{
+		Name:  "context-radius",
+		Usage: fmt.Sprintf("how far either side of each result, in words, to pull in neighboring chunks for context, at most %d", search.MaxContextRadius),
+		Value: search.DefaultContextRadius,
+	},
+	&cli.BoolFlag{
+		Name:  "hybrid",
+		Usage: "combine a BM25 text search on the transcripts with the vector search, to catch exact terms the embeddings blur",
+	},
+	&cli.BoolFlag{
+		Name:  "explain",
+		Usage: "print how opensearch scored each result",
+	},
+	&cli.BoolFlag{
+		Name:  "diversify",
+		Usage: "pick results that cover more distinct parts of the transcripts, rather than overlapping chunks of one discussion",
+	},
+}, append(append(append(append(HybridFlags, DiversityFlags...), RerankFlags...), ExpandFlags...), FilterFlags...)...)
+
+// HybridFlags configure how a hybrid search fuses its text and vector results
+var HybridFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "fusion",
+		Usage: "how to fuse text and vector results, rrf for reciprocal rank fusion or weighted for normalized scores",
+		Value: search.DefaultHybridConfig().Fusion,
+	},
+	&cli.Float64Flag{
+		Name:  "text-weight",
+		Usage: "weight of the BM25 text results in a hybrid search",
+		Value: search.DefaultHybridConfig().TextWeight,
+	},
+	&cli.Float64Flag{
+		Name:  "vector-weight",
+		Usage: "weight of the vector results in a hybrid search",
+		Value: search.DefaultHybridConfig().VectorWeight,
+	},
+	&cli.IntFlag{
+		Name:  "rank-constant",
+		Usage: "k in the reciprocal rank fusion score 1/(k+rank)",
+		Value: search.DefaultHybridConfig().RankConstant,
+	},
+}
+
+// DiversityFlags configure how a diversified search trades relevance against covering more distinct results
+var DiversityFlags = []cli.Flag{
+	&cli.Float64Flag{
+		Name:  "lambda",
+		Usage: "maximal marginal relevance trade off, 1 only considers relevance to the query and 0 only difference from the other results",
+		Value: search.DefaultDiversityConfig().Lambda,
+	},
+	&cli.IntFlag{
+		Name:  "max-per-episode",
+		Usage: "most results to take from any one episode in a diversified search, 0 for no limit",
+		Value: search.DefaultDiversityConfig().MaxPerEpisode,
+	},
+	&cli.IntFlag{
+		Name:  "candidates",
+		Usage: "number of results to fetch and diversify",
+		Value: search.DefaultDiversityConfig().Candidates,
+	},
+}
+
+// RerankFlags pick a reranker to reorder the top search results before they're used as context
+var RerankFlags = []cli.Flag{
+	&cli.StringFlag{
+		Name:    "reranker",
+		Usage:   "rerank the top results with a cross-encoder service, a chat model (chat) or not at all (none)",
+		EnvVars: []string{"OXIDE_RERANKER"},
+		Value:   rerank.KindNone,
+	},
+	&cli.StringFlag{
+		Name:    "rerank-url",
+		Usage:   "base url of a text embeddings inference service running a reranker model, for the cross-encoder reranker",
+		EnvVars: []string{"OXIDE_RERANK_URL"},
+	},
+	&cli.IntFlag{
+		Name:  "rerank-candidates",
+		Usage: "number of top results to rerank",
+		Value: rerank.DefaultCandidates,
+	},
+}
+
+// ExpandFlags turn on transformations of the query, each variant of the query is searched and the results fused
+var ExpandFlags = []cli.Flag{
+	&cli.BoolFlag{
+		Name:  "sub-queries",
+		Usage: "also search with several more specific queries a chat model rewrites the query into",
+	},
+	&cli.BoolFlag{
+		Name:  "hyde",
+		Usage: "also search with a hypothetical answer a chat model writes for the query",
+	},
+	&cli.BoolFlag{
+		Name:  "glossary",
+		Usage: "also search with any acronyms in the query spelled out, from the defaults and data/glossary.json",
+	},
+}
+
+// FilterFlags restrict a search to matching episodes
+var FilterFlags = []cli.Flag{
+	&cli.TimestampFlag{
+		Name:   "from",
+		Usage:  "only search episodes published on or after this date",
+		Layout: time.DateOnly,
+	},
+	&cli.TimestampFlag{
+		Name:   "until",
+		Usage:  "only search episodes published before this date",
+		Layout: time.DateOnly,
+	},
+	&cli.StringSliceFlag{
+		Name:  "episode",
+		Usage: "only search episodes with this GUID, can be repeated",
+	},
+	&cli.StringSliceFlag{
+		Name:  "exclude-episode",
+		Usage: "leave out episodes with this GUID, can be repeated",
+	},
+	&cli.StringFlag{
+		Name:  "feed",
+		Usage: "only search episodes from the podcast feed with this title",
+	},
+	&cli.StringFlag{
+		Name:  "speaker",
+		Usage: "only search episodes that list this speaker in their feed",
+	},
+	&cli.StringFlag{
+		Name:  "title",
+		Usage: "only search episodes whose title contains this, ignoring case",
+	},
+	&cli.StringFlag{
+		Name:  "topic",
+		Usage: "only search chunks labelled with this topic by the topics command",
+	},
+	&cli.StringFlag{
+		Name:  "entity",
+		Usage: "only search chunks naming this entity, as found by the entities command",
+	},
+	&cli.StringFlag{
+		Name:  "domain",
+		Usage: "only search episodes linking to this domain in their show notes, without any www",
+	},
+}
+
+// Filter builds the search filter from FilterFlags
+func Filter(ctx *cli.Context) search.Filter {
+	return search.Filter{
+		PublishedFrom:  ctx.Timestamp("from"),
+		PublishedUntil: ctx.Timestamp("until"),
+		GUIDs:          ctx.StringSlice("episode"),
+		ExcludeGUIDs:   ctx.StringSlice("exclude-episode"),
+		Feed:           ctx.String("feed"),
+		Speaker:        ctx.String("speaker"),
+		TitleContains:  ctx.String("title"),
+		Topic:          ctx.String("topic"),
+		Entity:         ctx.String("entity"),
+		Domain:         ctx.String("domain"),
+	}
+}
+
+// HybridConfig builds the hybrid search configuration from HybridFlags
+func HybridConfig(ctx *cli.Context) search.HybridConfig {
+	config := search.DefaultHybridConfig()
+	config.Fusion = ctx.String("fusion")
+	config.TextWeight = ctx.Float64("text-weight")
+	config.VectorWeight = ctx.Float64("vector-weight")
+	config.RankConstant = ctx.Int("rank-constant")
+	return config
+}
+
+// DiversityConfig builds the diversity configuration from DiversityFlags
+func DiversityConfig(ctx *cli.Context) search.DiversityConfig {
+	return search.DiversityConfig{
+		Lambda:        ctx.Float64("lambda"),
+		MaxPerEpisode: ctx.Int("max-per-episode"),
+		Candidates:    ctx.Int("candidates"),
+	}
+}
+
+// Reranker builds the reranker from RerankFlags
+func Reranker(ctx *cli.Context, client *openai.Client) (rerank.Reranker, error) {
+	return rerank.Open(ctx.String("reranker"), ctx.String("rerank-url"), client)
+}
+
+// ExpandConfig builds the query transformations from ExpandFlags
+func ExpandConfig(ctx *cli.Context) expand.Config {
+	return expand.Config{
+		SubQueries: ctx.Bool("sub-queries"),
+		HyDE:       ctx.Bool("hyde"),
+		Glossary:   ctx.Bool("glossary"),
+	}
+}
+
 func Query(ctx *cli.Context) error {
 	userQuery := "Tell me about fan power consumption in oxide racks"
+	// The flags are checked before anything is sent to openai
+	if ctx.Bool("hierarchical") && ctx.Bool("hybrid") {
+		return fmt.Errorf("--hierarchical and --hybrid can't be combined")
+	}
+	if ctx.Bool("hybrid") {
+		if err := HybridConfig(ctx).Validate(); err != nil {
+			return err
+		}
+	}
+	if ctx.Int("context-radius") > search.MaxContextRadius {
+		return fmt.Errorf("context radius can be at most %d words", search.MaxContextRadius)
+	}
+	diversity := DiversityConfig(ctx)
+	if err := diversity.Validate(); err != nil {
+		return err
+	}
+
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
 
-	// Get an embedding of the users input query so we can find local context for its content
 	openaiClient := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	variants := []expand.Variant{{Kind: expand.KindOriginal, Text: userQuery}}
+	if config := ExpandConfig(ctx); config.Enabled() {
+		expander, err := expand.NewExpander(openaiClient, dataDirectory)
+		if err != nil {
+			return err
+		}
+		variants, err = expander.Expand(ctx.Context, userQuery, config)
+		if err != nil {
+			return err
+		}
+		for _, variant := range variants[1:] {
+			fmt.Printf("Query variant (%s): %s\n", variant.Kind, variant.Text)
+		}
+	}
+
+	// Get an embedding of the users input query, and any variants of it, so we can find local context for its content
+	inputs := make([]string, len(variants))
+	for i := range variants {
+		inputs[i] = variants[i].Text
+	}
 	queryEmbeddingResponse, err := openaiClient.CreateEmbeddings(ctx.Context, openai.EmbeddingRequestStrings{
-		Input: []string{userQuery},
-		Model: openai.AdaEmbeddingV2,
+		Input: inputs,
+		Model: openai.EmbeddingModel(model.Name),
 	})
 	if err != nil {
 		return fmt.Errorf("failed to generate vectors for query: %w", err)
 	}
-	queryVector := queryEmbeddingResponse.Data[0].Embedding
+	queryVectors := make([][]float32, len(queryEmbeddingResponse.Data))
+	for _, e := range queryEmbeddingResponse.Data {
+		queryVectors[e.Index] = e.Embedding
+	}
+	queryVector := queryVectors[0]
 
 	// Now search for neighbors of the embedding in our index to build context for the response
-	client, err := opensearch.NewClient(opensearch.Config{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-		Addresses: []string{"https://localhost:9200"},
-		Username:  "admin", // For testing only. Don't store credentials in code.
-		Password:  "admin",
-	})
+	store, err := search.OpenStore(ctx.String("store"), dataDirectory, model.Name)
+	if err != nil {
+		return err
+	}
 
-	searchResults, err := search.QueryEmbedding(ctx.Context, client, queryVector, 10, 2)
+	filter := Filter(ctx)
+	reranker, err := Reranker(ctx, openaiClient)
+	if err != nil {
+		return err
+	}
+	_, noRerank := reranker.(rerank.None)
+
+	// Diversifying and reranking both fetch more candidates than they need to pick the results from, diversity
+	// picks the candidates for reranking when both are used
+	candidates, size := 10, 10
+	if !noRerank {
+		candidates = max(candidates, ctx.Int("rerank-candidates"))
+		size = candidates
+	}
+	if ctx.Bool("diversify") {
+		size = diversity.CandidateCount(size)
+	}
+
+	searchCtx := ctx.Context
+	if ctx.Bool("explain") {
+		searchCtx = search.WithExplain(searchCtx)
+	}
+	searchResults, err := expand.Search(searchCtx, variants, queryVectors, size, func(searchCtx context.Context, text string, vector []float32) ([]search.SearchHit, error) {
+		switch {
+		case ctx.Bool("hierarchical"):
+			return store.QueryHierarchical(searchCtx, vector, ctx.Int("episodes"), size, filter)
+		case ctx.Bool("hybrid"):
+			return store.QueryHybrid(searchCtx, text, vector, size, HybridConfig(ctx), filter)
+		default:
+			return store.QueryEmbedding(searchCtx, vector, size, size*2, filter)
+		}
+	})
 	if err != nil {
 		return fmt.Errorf("failed to query nearby vectors: %w", err)
 	}
-	additionalContext, err := search.AddNearbySegments(ctx.Context, client, searchResults, 20)
+	if ctx.Bool("diversify") {
+		searchResults = search.Diversify(queryVector, searchResults, candidates, diversity)
+	}
+	if !noRerank {
+		reranked, err := rerank.Rerank(ctx.Context, reranker, userQuery, searchResults, candidates, 10)
+		if err != nil {
+			return err
+		}
+		for _, r := range reranked {
+			fmt.Printf("Reranked: %.3f %s\n", r.RerankScore, r.String())
+		}
+		searchResults = rerank.Hits(reranked)
+	}
+	for _, hit := range searchResults {
+		printHit(hit)
+	}
+	for _, reference := range search.References(searchResults, search.DefaultReferences) {
+		fmt.Println("Reference: " + reference.String())
+	}
+	passages, err := store.ExpandContext(ctx.Context, search.Documents(searchResults), ctx.Int("context-radius"))
 	if err != nil {
-		return fmt.Errorf("failed to add additional nearby segments: %w", err)
+		return fmt.Errorf("failed to expand context around search results: %w", err)
+	}
+	for _, passage := range passages {
+		fmt.Println("Context: " + passage.String())
 	}
 
-	contextVectors := append(searchResults, additionalContext...)
-
-	contextMessages := meta.CreateConversation(meta.GetPrompt(), contextVectors, userQuery)
+	contextMessages := meta.CreateConversation(meta.GetPrompt(), passages, userQuery)
 
 	queryStart := time.Now()
 	chatResponse, err := openaiClient.CreateChatCompletion(ctx.Context, openai.ChatCompletionRequest{
@@ -63,8 +368,22 @@ func Query(ctx *cli.Context) error {
 		return fmt.Errorf("failed to generate chat completion: %w", err)
 	}
 
-	fmt.Printf("Included Embeddings: %d, took %s seconds to generate a response \n", len(contextVectors), time.Since(queryStart))
+	fmt.Printf("Included Passages: %d, took %s seconds to generate a response \n", len(passages), time.Since(queryStart))
 	fmt.Println("ChatResponse: " + chatResponse.Choices[0].Message.Content)
 
 	return nil
 }
+
+// printHit shows why a search result was found
+func printHit(hit search.SearchHit) {
+	fmt.Println("Result: " + hit.String())
+	if hit.MatchedQuestion != "" {
+		fmt.Printf("  Matched question: %s\n", hit.MatchedQuestion)
+	}
+	for _, highlight := range hit.Highlights {
+		fmt.Printf("  Highlight: %s\n", highlight)
+	}
+	if len(hit.Explanation) > 0 {
+		fmt.Printf("  Explanation: %s\n", hit.Explanation)
+	}
+}
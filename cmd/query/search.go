@@ -0,0 +1,235 @@
+package query
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/urfave/cli/v2"
+
+	"oxide-search/cmd/index"
+	"oxide-search/embedding"
+	"oxide-search/search"
+)
+
+var SearchFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model to search with, defaults to the active model",
+	},
+	index.StoreFlag,
+	&cli.IntFlag{
+		Name:  "page-size",
+		Usage: "number of results per page",
+		Value: 10,
+	},
+	&cli.StringFlag{
+		Name:  "cursor",
+		Usage: "cursor printed after the previous page, to fetch the page following it",
+	},
+	&cli.BoolFlag{
+		Name:  "explain",
+		Usage: "print how opensearch scored each result",
+	},
+}, FilterFlags...)
+
+var SimilarFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model whose vectors to compare, defaults to the active model",
+	},
+	index.StoreFlag,
+	&cli.IntFlag{
+		Name:  "size",
+		Usage: "number of similar chunks to find",
+		Value: 10,
+	},
+}, FilterFlags...)
+
+var QuoteFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model whose index to search, defaults to the active model",
+	},
+	index.StoreFlag,
+	&cli.IntFlag{
+		Name:  "slop",
+		Usage: "how many other words can be said in between the words of the phrase",
+		Value: search.DefaultQuoteSlop,
+	},
+}, FilterFlags...)
+
+var LinksFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model whose index to search, defaults to the active model",
+	},
+	index.StoreFlag,
+}, FilterFlags...)
+
+var RelatedFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model whose vectors to compare, defaults to the active model",
+	},
+	index.StoreFlag,
+	&cli.IntFlag{
+		Name:  "size",
+		Usage: "number of related episodes to find",
+		Value: 5,
+	},
+}, FilterFlags...)
+
+// Search pages through the transcript chunks nearest to a query without asking a chat model about them
+func Search(ctx *cli.Context) error {
+	userQuery := ctx.Args().First()
+	if userQuery == "" {
+		return fmt.Errorf("a query to search for is required")
+	}
+	if ctx.Int("page-size") <= 0 {
+		return fmt.Errorf("page size must be positive")
+	}
+
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	openaiClient := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	queryEmbeddingResponse, err := openaiClient.CreateEmbeddings(ctx.Context, openai.EmbeddingRequestStrings{
+		Input: []string{userQuery},
+		Model: openai.EmbeddingModel(model.Name),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate vectors for query: %w", err)
+	}
+
+	store, err := search.OpenStore(ctx.String("store"), dataDirectory, model.Name)
+	if err != nil {
+		return err
+	}
+	searchCtx := ctx.Context
+	if ctx.Bool("explain") {
+		searchCtx = search.WithExplain(searchCtx)
+	}
+	page, err := store.QueryPage(searchCtx, queryEmbeddingResponse.Data[0].Embedding, ctx.Int("page-size"), ctx.String("cursor"), Filter(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to search for %q: %w", userQuery, err)
+	}
+
+	for _, hit := range page.Hits {
+		printHit(hit)
+	}
+	for _, reference := range search.References(page.Hits, search.DefaultReferences) {
+		fmt.Println("Reference: " + reference.String())
+	}
+	if page.Next != "" {
+		fmt.Println("Next page: --cursor " + page.Next)
+	}
+	return nil
+}
+
+// Similar finds the transcript chunks from other episodes most like a chunk, by its stored vector
+func Similar(ctx *cli.Context) error {
+	id := ctx.Args().First()
+	if id == "" {
+		return fmt.Errorf("the id of a chunk is required")
+	}
+
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	store, err := search.OpenStore(ctx.String("store"), dataDirectory, model.Name)
+	if err != nil {
+		return err
+	}
+	hits, err := store.MoreLikeThis(ctx.Context, id, ctx.Int("size"), Filter(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to find chunks similar to %s: %w", id, err)
+	}
+
+	for _, hit := range hits {
+		printHit(hit)
+	}
+	return nil
+}
+
+// Quote finds every place a phrase was said in the transcripts
+func Quote(ctx *cli.Context) error {
+	phrase := ctx.Args().First()
+	if phrase == "" {
+		return fmt.Errorf("a phrase to search for is required")
+	}
+
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	store, err := search.OpenStore(ctx.String("store"), dataDirectory, model.Name)
+	if err != nil {
+		return err
+	}
+	quotes, err := store.QueryQuotes(ctx.Context, phrase, ctx.Int("slop"), Filter(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to find %q: %w", phrase, err)
+	}
+
+	for _, quote := range quotes {
+		fmt.Println("Quote: " + quote.String())
+	}
+	fmt.Printf("Found %d occurrences of %q\n", len(quotes), phrase)
+	return nil
+}
+
+// Related finds the episodes most like an episode
+func Related(ctx *cli.Context) error {
+	GUID := ctx.Args().First()
+	if GUID == "" {
+		return fmt.Errorf("the guid of an episode is required")
+	}
+
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	store, err := search.OpenStore(ctx.String("store"), dataDirectory, model.Name)
+	if err != nil {
+		return err
+	}
+	episodes, err := store.RelatedEpisodes(ctx.Context, GUID, ctx.Int("size"), Filter(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to find episodes related to %s: %w", GUID, err)
+	}
+
+	for _, episode := range episodes {
+		fmt.Printf("Related: #%d %.3f %s (%s) %s\n", episode.Rank, episode.Score, episode.Title, episode.GUID, episode.Link)
+	}
+	return nil
+}
+
+// Links finds the links in the show notes matching some text, or every link to the --domain when there's no text
+func Links(ctx *cli.Context) error {
+	text := ctx.Args().First()
+	if text == "" && ctx.String("domain") == "" {
+		return fmt.Errorf("text to search the links for, or a --domain, is required")
+	}
+
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	store, err := search.OpenStore(ctx.String("store"), dataDirectory, model.Name)
+	if err != nil {
+		return err
+	}
+	references, err := store.QueryLinks(ctx.Context, text, Filter(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to search the show notes links: %w", err)
+	}
+
+	for _, reference := range references {
+		fmt.Println("Link: " + reference.String())
+	}
+	fmt.Printf("Found %d links\n", len(references))
+	return nil
+}
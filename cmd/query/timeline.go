@@ -0,0 +1,131 @@
+package query
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/urfave/cli/v2"
+
+	"oxide-search/cmd/index"
+	"oxide-search/embedding"
+	"oxide-search/search"
+)
+
+var TimelineFlags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model to search with, defaults to the active model",
+	},
+	index.StoreFlag,
+	&cli.Float64Flag{
+		Name:  "threshold",
+		Usage: "cosine similarity a chunk needs to count as discussing the query, text-embedding-3 models need a lower one",
+		Value: search.DefaultTimelineThreshold,
+	},
+	&cli.StringFlag{
+		Name:  "interval",
+		Usage: "how long each row of the timeline covers, one of month, quarter or year",
+		Value: search.IntervalMonth,
+	},
+	&cli.IntFlag{
+		Name:  "snippets",
+		Usage: "number of example snippets to show for each row",
+		Value: 1,
+	},
+	&cli.BoolFlag{
+		Name:  "csv",
+		Usage: "write the timeline as CSV rather than a table",
+	},
+}, FilterFlags...)
+
+// Timeline shows when and how often a query was discussed, by counting the chunks similar to it published in
+// each interval
+func Timeline(ctx *cli.Context) error {
+	userQuery := ctx.Args().First()
+	if userQuery == "" {
+		return fmt.Errorf("a query to chart is required")
+	}
+	if err := search.ValidInterval(ctx.String("interval")); err != nil {
+		return err
+	}
+
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	openaiClient := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	queryEmbeddingResponse, err := openaiClient.CreateEmbeddings(ctx.Context, openai.EmbeddingRequestStrings{
+		Input: []string{userQuery},
+		Model: openai.EmbeddingModel(model.Name),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to generate vectors for query: %w", err)
+	}
+
+	store, err := search.OpenStore(ctx.String("store"), dataDirectory, model.Name)
+	if err != nil {
+		return err
+	}
+	hits, err := store.QueryThreshold(ctx.Context, queryEmbeddingResponse.Data[0].Embedding, ctx.Float64("threshold"), Filter(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to search for %q: %w", userQuery, err)
+	}
+	buckets, err := search.Timeline(hits, ctx.String("interval"), ctx.Int("snippets"))
+	if err != nil {
+		return err
+	}
+
+	if ctx.Bool("csv") {
+		return writeTimelineCSV(buckets, ctx.String("interval"))
+	}
+	fmt.Printf("%-10s %6s %8s  %s\n", "Period", "Chunks", "Episodes", "Snippets")
+	for _, bucket := range buckets {
+		fmt.Printf("%-10s %6d %8d", period(bucket, ctx.String("interval")), bucket.Chunks, bucket.Episodes)
+		for i, snippet := range bucket.Snippets {
+			if i > 0 {
+				fmt.Printf("%-27s", "")
+			}
+			fmt.Printf("  %s: %s\n", snippet.Title, snippet.Text)
+		}
+		if len(bucket.Snippets) == 0 {
+			fmt.Println()
+		}
+	}
+	fmt.Printf("%d chunks at or above a similarity of %.2f\n", len(hits), ctx.Float64("threshold"))
+	return nil
+}
+
+// writeTimelineCSV writes a row for each bucket, with its snippets in pairs of title and text columns
+func writeTimelineCSV(buckets []search.Bucket, interval string) error {
+	writer := csv.NewWriter(os.Stdout)
+	err := writer.Write([]string{"period", "start", "chunks", "episodes", "snippets"})
+	if err != nil {
+		return fmt.Errorf("failed to write timeline: %w", err)
+	}
+	for _, bucket := range buckets {
+		row := []string{period(bucket, interval), bucket.Start.Format("2006-01-02"), strconv.Itoa(bucket.Chunks), strconv.Itoa(bucket.Episodes)}
+		for _, snippet := range bucket.Snippets {
+			row = append(row, snippet.Title, snippet.Text)
+		}
+		err = writer.Write(row)
+		if err != nil {
+			return fmt.Errorf("failed to write timeline: %w", err)
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// period names the interval a bucket covers, like 2023-04, 2023-Q2 or 2023
+func period(bucket search.Bucket, interval string) string {
+	switch interval {
+	case search.IntervalQuarter:
+		return fmt.Sprintf("%d-Q%d", bucket.Start.Year(), (bucket.Start.Month()-1)/3+1)
+	case search.IntervalYear:
+		return strconv.Itoa(bucket.Start.Year())
+	}
+	return bucket.Start.Format("2006-01")
+}
@@ -0,0 +1,153 @@
+package questions
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/urfave/cli/v2"
+
+	"oxide-search/cmd/embeddings"
+	"oxide-search/embedding"
+	"oxide-search/manifest"
+	"oxide-search/questions"
+)
+
+const (
+	dataDirectory = "data"
+)
+
+var Flags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model whose chunks should be enriched, defaults to the active model",
+	},
+	&cli.StringFlag{
+		Name:  "chat-model",
+		Usage: "chat model used to write the questions",
+		Value: openai.GPT3Dot5Turbo,
+	},
+	&cli.IntFlag{
+		Name:  "count",
+		Usage: "number of questions to generate for each chunk",
+		Value: 3,
+	},
+}, embeddings.EmbedderFlags...)
+
+// GenerateQuestions enriches each transcript chunk with a few synthetic questions that the chunk answers, and
+// embeds them. Questions tend to embed much closer to a users query than conversational transcript text does
+func GenerateQuestions(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+
+	manifestData, err := manifest.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load data manifest: %w", err)
+	}
+
+	openaiClient := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	embedder := embedding.NewEmbedder(openaiClient, embeddings.EmbedderConfig(ctx, model.Name))
+
+	for _, episode := range manifestData.Episodes {
+		chunks, err := embedding.Load(dataDirectory, model.Name, episode.GUID)
+		if err != nil {
+			fmt.Printf("skipping episode %s (%s): %s\n", episode.GUID, episode.Title, err)
+			continue
+		}
+
+		existing, err := questions.Load(dataDirectory, episode.GUID)
+		if err != nil {
+			return err
+		}
+		previous := make(map[string]questions.Chunk)
+		if existing != nil {
+			for _, c := range existing.Chunks {
+				previous[c.Content] = c
+			}
+		}
+
+		// Reuse questions for any chunks we've seen before, and generate them for the rest
+		episodeQuestions := &questions.Episode{GUID: episode.GUID, Chunks: make([]questions.Chunk, len(chunks))}
+		var missing []int
+		for i, chunk := range chunks {
+			if c, ok := previous[chunk.Content]; ok {
+				c.VectorId = chunk.Chunk
+				episodeQuestions.Chunks[i] = c
+				continue
+			}
+			episodeQuestions.Chunks[i] = questions.Chunk{VectorId: chunk.Chunk, Content: chunk.Content}
+			missing = append(missing, i)
+		}
+
+		if len(missing) > 0 {
+			fmt.Printf("generating questions for %d chunks of episode %s (%s)\n", len(missing), episode.GUID, episode.Title)
+		}
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var firstErr error
+		work := make(chan int)
+		for w := 0; w < max(ctx.Int("workers"), 1); w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range work {
+					generated, err := questions.Generate(ctx.Context, openaiClient, ctx.String("chat-model"), chunks[i].Content, ctx.Int("count"))
+					mu.Lock()
+					if err != nil && firstErr == nil {
+						firstErr = fmt.Errorf("failed to generate questions for chunk %d of episode %s: %w", i, episode.GUID, err)
+					}
+					episodeQuestions.Chunks[i].Questions = generated
+					mu.Unlock()
+				}
+			}()
+		}
+		for _, i := range missing {
+			work <- i
+		}
+		close(work)
+		wg.Wait()
+		if firstErr != nil {
+			return firstErr
+		}
+
+		// Embed every question that doesn't have a vector from this model yet
+		var inputs []string
+		var targets []int
+		for i, c := range episodeQuestions.Chunks {
+			if len(c.Vectors[model.Name]) == len(c.Questions) {
+				continue
+			}
+			inputs = append(inputs, c.Questions...)
+			targets = append(targets, i)
+		}
+		if len(inputs) > 0 {
+			vectors, gaps, err := embedder.Embed(ctx.Context, inputs)
+			if err != nil {
+				return fmt.Errorf("failed to embed questions for episode %s: %w", episode.GUID, err)
+			}
+			if len(gaps) > 0 {
+				return fmt.Errorf("failed to embed %d questions for episode %s", len(gaps), episode.GUID)
+			}
+			offset := 0
+			for _, i := range targets {
+				c := &episodeQuestions.Chunks[i]
+				if c.Vectors == nil {
+					c.Vectors = make(map[string][][]float32)
+				}
+				c.Vectors[model.Name] = vectors[offset : offset+len(c.Questions)]
+				offset += len(c.Questions)
+			}
+		}
+
+		err = questions.Save(dataDirectory, episodeQuestions)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("embedded %d questions for episode %s (%s)\n", len(inputs), episode.GUID, episode.Title)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+package summarize
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/urfave/cli/v2"
+
+	"oxide-search/cmd/embeddings"
+	"oxide-search/embedding"
+	"oxide-search/manifest"
+	"oxide-search/summary"
+)
+
+const (
+	dataDirectory = "data"
+)
+
+var Flags = append([]cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model to embed the summaries with, defaults to the active model",
+	},
+	&cli.BoolFlag{
+		Name:  "force",
+		Usage: "regenerate summaries for episodes that have already been summarized",
+	},
+}, embeddings.EmbedderFlags...)
+
+// Summarize generates a summary of each episode, and of each of its chapters when they're listed in the show
+// notes, then embeds those summaries so whole episodes can be searched for before searching within them
+func Summarize(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+
+	manifestData, err := manifest.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load data manifest: %w", err)
+	}
+
+	openaiClient := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	embedder := embedding.NewEmbedder(openaiClient, embeddings.EmbedderConfig(ctx, model.Name))
+
+	for _, episode := range manifestData.Episodes {
+		if episode.Transcript == "" {
+			fmt.Printf("episode %s (%s) has not been transcribed, skipping summary\n", episode.GUID, episode.Title)
+			continue
+		}
+
+		episodeSummary, err := summary.Load(dataDirectory, episode.GUID)
+		if err != nil {
+			return err
+		}
+		if episodeSummary == nil || ctx.Bool("force") {
+			fmt.Printf("summarizing episode %s (%s)\n", episode.GUID, episode.Title)
+			episodeSummary, err = summary.Generate(ctx.Context, openaiClient, episode)
+			if err != nil {
+				return err
+			}
+			err = summary.Save(dataDirectory, episodeSummary)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Embed the episode summary and any chapter summaries that don't have vectors from this model yet
+		var inputs []string
+		var targets []*map[string][]float32
+		if _, ok := episodeSummary.Vectors[model.Name]; !ok {
+			inputs = append(inputs, episodeSummary.Summary)
+			targets = append(targets, &episodeSummary.Vectors)
+		}
+		for i := range episodeSummary.Chapters {
+			chapter := &episodeSummary.Chapters[i]
+			if _, ok := chapter.Vectors[model.Name]; !ok && chapter.Summary != "" {
+				inputs = append(inputs, chapter.Summary)
+				targets = append(targets, &chapter.Vectors)
+			}
+		}
+		if len(inputs) == 0 {
+			continue
+		}
+
+		vectors, _, err := embedder.Embed(ctx.Context, inputs)
+		if err != nil {
+			return fmt.Errorf("failed to embed summaries for episode %s: %w", episode.GUID, err)
+		}
+		for i, target := range targets {
+			if vectors[i] == nil {
+				continue
+			}
+			if *target == nil {
+				*target = make(map[string][]float32)
+			}
+			(*target)[model.Name] = vectors[i]
+		}
+		err = summary.Save(dataDirectory, episodeSummary)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("embedded %d summaries for episode %s (%s)\n", len(inputs), episode.GUID, episode.Title)
+	}
+
+	return nil
+}
@@ -0,0 +1,162 @@
+package topics
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+	"github.com/urfave/cli/v2"
+
+	"oxide-search/embedding"
+	"oxide-search/manifest"
+	"oxide-search/topics"
+)
+
+const (
+	dataDirectory = "data"
+
+	// excerptWords is how much of each representative chunk the chat model is shown when labelling a topic
+	excerptWords = 150
+)
+
+var Flags = []cli.Flag{
+	&cli.StringFlag{
+		Name:  "model",
+		Usage: "embedding model whose chunks to cluster, defaults to the active model",
+	},
+	&cli.IntFlag{
+		Name:  "k",
+		Usage: "number of topics to cluster the chunks into",
+		Value: 20,
+	},
+	&cli.IntFlag{
+		Name:  "iterations",
+		Usage: "most rounds of k-means to run before giving up on it converging",
+		Value: 50,
+	},
+	&cli.Int64Flag{
+		Name:  "seed",
+		Usage: "seed for picking the initial clusters, the same seed and chunks always give the same topics",
+		Value: 1,
+	},
+	&cli.StringFlag{
+		Name:  "chat-model",
+		Usage: "chat model used to label the topics",
+		Value: openai.GPT3Dot5Turbo,
+	},
+	&cli.IntFlag{
+		Name:  "samples",
+		Usage: "number of the chunks closest to the center of each topic to label it from",
+		Value: 5,
+	},
+}
+
+// Topics clusters every transcript chunk embedded by a model with k-means, and has a chat model label each
+// cluster. The topics are saved for index to store on the chunks and episodes
+func Topics(ctx *cli.Context) error {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.String("model"))
+	if err != nil {
+		return err
+	}
+	if ctx.Int("k") <= 0 {
+		return fmt.Errorf("k must be positive")
+	}
+	if ctx.Int("iterations") <= 0 || ctx.Int("samples") <= 0 {
+		return fmt.Errorf("iterations and samples must be positive")
+	}
+
+	manifestData, err := manifest.Load()
+	if err != nil {
+		return fmt.Errorf("failed to load data manifest: %w", err)
+	}
+	// Episodes are in a map, sort them so the same seed clusters the same chunks the same way
+	guids := make([]string, 0, len(manifestData.Episodes))
+	for guid := range manifestData.Episodes {
+		guids = append(guids, guid)
+	}
+	sort.Strings(guids)
+
+	type chunk struct {
+		GUID     string
+		VectorId int
+		Content  string
+	}
+	var chunks []chunk
+	var vectors [][]float32
+	for _, guid := range guids {
+		embeddings, err := embedding.Load(dataDirectory, model.Name, guid)
+		if err != nil {
+			fmt.Printf("skipping episode %s (%s): %s\n", guid, manifestData.Episodes[guid].Title, err)
+			continue
+		}
+		for _, e := range embeddings {
+			chunks = append(chunks, chunk{GUID: guid, VectorId: e.Chunk, Content: e.Content})
+			vectors = append(vectors, e.Vector)
+		}
+	}
+	if len(chunks) == 0 {
+		return fmt.Errorf("there are no %s embeddings to cluster, run embed first", model.Name)
+	}
+
+	fmt.Printf("clustering %d chunks into %d topics\n", len(chunks), ctx.Int("k"))
+	assignments, centroids := topics.KMeans(vectors, ctx.Int("k"), ctx.Int("iterations"), ctx.Int64("seed"))
+
+	clustering := &topics.Clustering{
+		Model:       model.Name,
+		Assignments: make(map[string][]int),
+	}
+
+	// Clusters k-means left empty have nothing to label them from, so they're dropped and the rest numbered in order
+	openaiClient := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	labels := make(map[string]int)
+	ids := make([]int, len(centroids))
+	for cluster, representatives := range topics.Representatives(vectors, assignments, centroids, ctx.Int("samples")) {
+		ids[cluster] = -1
+		if len(representatives) == 0 {
+			continue
+		}
+		id := len(clustering.Topics)
+		ids[cluster] = id
+
+		excerpts := make([]string, len(representatives))
+		for i, representative := range representatives {
+			words := strings.Fields(chunks[representative].Content)
+			excerpts[i] = strings.Join(words[:min(excerptWords, len(words))], " ")
+		}
+		label, err := topics.Label(ctx.Context, openaiClient, ctx.String("chat-model"), excerpts)
+		if err != nil {
+			return fmt.Errorf("failed to label topic %d: %w", id, err)
+		}
+		// Topics are filtered by label, so they have to be unique
+		labels[label]++
+		if labels[label] > 1 {
+			label = fmt.Sprintf("%s (%d)", label, labels[label])
+		}
+		clustering.Topics = append(clustering.Topics, topics.Topic{Id: id, Label: label})
+	}
+	for i, c := range chunks {
+		episodeAssignments := clustering.Assignments[c.GUID]
+		for len(episodeAssignments) <= c.VectorId {
+			episodeAssignments = append(episodeAssignments, -1)
+		}
+		episodeAssignments[c.VectorId] = ids[assignments[i]]
+		clustering.Assignments[c.GUID] = episodeAssignments
+	}
+	clustering.Count()
+
+	err = topics.Save(dataDirectory, clustering)
+	if err != nil {
+		return err
+	}
+
+	byChunks := make([]topics.Topic, len(clustering.Topics))
+	copy(byChunks, clustering.Topics)
+	sort.Slice(byChunks, func(i, j int) bool { return byChunks[i].Chunks > byChunks[j].Chunks })
+	for _, topic := range byChunks {
+		fmt.Printf("%-40s %5d chunks %4d episodes\n", topic.Label, topic.Chunks, topic.Episodes)
+	}
+	fmt.Println("run index to store the topics on the chunks and episodes")
+	return nil
+}
@@ -93,6 +93,9 @@ func Transcribe(ctx *cli.Context) error {
 		// Submit each individual file to OpenAI for transcription, then combine the results into a single string
 		openaiClient := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
 		var transcript strings.Builder
+		var timestamps []manifest.Timestamp
+		var words int
+		var elapsed float64
 		for _, file := range transcriptionFiles {
 			response, err := openaiClient.CreateTranscription(ctx.Context, openai.AudioRequest{
 				Model:    openai.Whisper1,
@@ -100,14 +103,33 @@ func Transcribe(ctx *cli.Context) error {
 				// Might be able to improve the transcriptions with either a static prompt, or maybe one based on the description or show notes
 				Prompt:   "",
 				Language: "en",
+				// The verbose response times each segment, so search results can point to where they were said
+				Format: openai.AudioResponseFormatVerboseJSON,
 			})
 			if err != nil {
 				return fmt.Errorf("unexpected error from whisper: %w", err)
 			}
-			transcript.WriteString(response.Text)
-			transcript.WriteString(" ")
+			if len(response.Segments) == 0 {
+				transcript.WriteString(response.Text)
+				transcript.WriteString(" ")
+				words += len(strings.Fields(response.Text))
+			}
+			// The transcript is rebuilt from the segments, so the words are counted the same way they're timed
+			for _, segment := range response.Segments {
+				segmentWords := strings.Fields(segment.Text)
+				if len(segmentWords) == 0 {
+					continue
+				}
+				timestamps = append(timestamps, manifest.Timestamp{Word: words, Seconds: elapsed + segment.Start})
+				transcript.WriteString(strings.Join(segmentWords, " "))
+				transcript.WriteString(" ")
+				words += len(segmentWords)
+			}
+			// Split files are transcribed separately, so their segments are timed from the start of each file
+			elapsed += response.Duration
 		}
 		episode.Transcript = transcript.String()
+		episode.Timestamps = timestamps
 		manifestData.Episodes[episode.GUID] = episode
 
 		// Write the transcriptions out to the manifest after each episode is transcribed
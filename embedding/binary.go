@@ -0,0 +1,243 @@
+package embedding
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// The binary format is a small header followed by Count vectors of Dimension little-endian values each, all
+// values in the file use the same Encoding. Everything else about a chunk (its text, episode, etc) is kept in a
+// sidecar of JSON lines, one per vector and in the same order, so the vectors can be read without parsing text
+//
+//	magic     [4]byte "OXEM"
+//	version   uint16
+//	encoding  uint8
+//	reserved  uint8
+//	dimension uint32
+//	count     uint32
+//	modelLen  uint16
+//	model     [modelLen]byte
+const (
+	binaryMagic   = "OXEM"
+	binaryVersion = 1
+)
+
+type Encoding uint8
+
+const (
+	Float32 Encoding = 1
+	Float16 Encoding = 2
+)
+
+func (e Encoding) size() int {
+	if e == Float16 {
+		return 2
+	}
+	return 4
+}
+
+func (e Encoding) String() string {
+	switch e {
+	case Float32:
+		return "float32"
+	case Float16:
+		return "float16"
+	}
+	return fmt.Sprintf("unknown encoding %d", uint8(e))
+}
+
+// Header describes the vectors in a binary embeddings file
+type Header struct {
+	Model     string
+	Dimension int
+	Count     int
+	Encoding  Encoding
+}
+
+// chunkMetadata is the sidecar record stored for each vector
+type chunkMetadata struct {
+	GUID       string
+	VectorSize int
+	Offset     int `json:",omitempty"`
+	Chunk      int `json:",omitempty"`
+	Content    string
+}
+
+// Writer streams embeddings out to a binary vector file and its metadata sidecar
+type Writer struct {
+	header  Header
+	vectors *bufio.Writer
+	sidecar *bufio.Writer
+	meta    *json.Encoder
+	buf     []byte
+	written int
+}
+
+// NewWriter writes the header and returns a Writer expecting exactly header.Count embeddings
+func NewWriter(vectors io.Writer, sidecar io.Writer, header Header) (*Writer, error) {
+	if header.Encoding != Float32 && header.Encoding != Float16 {
+		return nil, fmt.Errorf("unsupported embedding encoding: %s", header.Encoding)
+	}
+	if header.Dimension <= 0 || header.Count < 0 {
+		return nil, fmt.Errorf("invalid embedding header dimension %d, count %d", header.Dimension, header.Count)
+	}
+	if len(header.Model) > math.MaxUint16 {
+		return nil, fmt.Errorf("embedding model name is too long")
+	}
+
+	w := &Writer{
+		header:  header,
+		vectors: bufio.NewWriter(vectors),
+		sidecar: bufio.NewWriter(sidecar),
+		buf:     make([]byte, header.Dimension*header.Encoding.size()),
+	}
+	w.meta = json.NewEncoder(w.sidecar)
+
+	fixed := make([]byte, 18)
+	copy(fixed, binaryMagic)
+	binary.LittleEndian.PutUint16(fixed[4:], binaryVersion)
+	fixed[6] = byte(header.Encoding)
+	binary.LittleEndian.PutUint32(fixed[8:], uint32(header.Dimension))
+	binary.LittleEndian.PutUint32(fixed[12:], uint32(header.Count))
+	binary.LittleEndian.PutUint16(fixed[16:], uint16(len(header.Model)))
+	if _, err := w.vectors.Write(fixed); err != nil {
+		return nil, fmt.Errorf("failed to write embeddings header: %w", err)
+	}
+	if _, err := w.vectors.WriteString(header.Model); err != nil {
+		return nil, fmt.Errorf("failed to write embeddings header: %w", err)
+	}
+
+	return w, nil
+}
+
+func (w *Writer) Write(s Storage) error {
+	if w.written >= w.header.Count {
+		return fmt.Errorf("header declared %d embeddings, cannot write more", w.header.Count)
+	}
+	if len(s.Vector) != w.header.Dimension {
+		return fmt.Errorf("embedding has %d dimensions, expected %d", len(s.Vector), w.header.Dimension)
+	}
+
+	for i, v := range s.Vector {
+		if w.header.Encoding == Float16 {
+			binary.LittleEndian.PutUint16(w.buf[i*2:], float32ToFloat16(v))
+		} else {
+			binary.LittleEndian.PutUint32(w.buf[i*4:], math.Float32bits(v))
+		}
+	}
+	if _, err := w.vectors.Write(w.buf); err != nil {
+		return fmt.Errorf("failed to write embedding vector: %w", err)
+	}
+	err := w.meta.Encode(chunkMetadata{GUID: s.GUID, VectorSize: s.VectorSize, Offset: s.Offset, Chunk: s.Chunk, Content: s.Content})
+	if err != nil {
+		return fmt.Errorf("failed to write embedding metadata: %w", err)
+	}
+
+	w.written++
+	return nil
+}
+
+// Close flushes any buffered output, it does not close the underlying writers
+func (w *Writer) Close() error {
+	if w.written != w.header.Count {
+		return fmt.Errorf("header declared %d embeddings but %d were written", w.header.Count, w.written)
+	}
+	if err := w.vectors.Flush(); err != nil {
+		return fmt.Errorf("failed to flush embedding vectors: %w", err)
+	}
+	if err := w.sidecar.Flush(); err != nil {
+		return fmt.Errorf("failed to flush embedding metadata: %w", err)
+	}
+	return nil
+}
+
+// Reader streams embeddings from a binary vector file and its metadata sidecar
+type Reader struct {
+	header  Header
+	vectors *bufio.Reader
+	meta    *json.Decoder
+	buf     []byte
+	read    int
+}
+
+// NewReader reads and validates the header, the sidecar may be nil if only the vectors are needed
+func NewReader(vectors io.Reader, sidecar io.Reader) (*Reader, error) {
+	r := &Reader{vectors: bufio.NewReader(vectors)}
+	if sidecar != nil {
+		r.meta = json.NewDecoder(sidecar)
+	}
+
+	fixed := make([]byte, 18)
+	if _, err := io.ReadFull(r.vectors, fixed); err != nil {
+		return nil, fmt.Errorf("failed to read embeddings header: %w", err)
+	}
+	if string(fixed[:4]) != binaryMagic {
+		return nil, fmt.Errorf("not an embeddings file")
+	}
+	if version := binary.LittleEndian.Uint16(fixed[4:]); version != binaryVersion {
+		return nil, fmt.Errorf("unsupported embeddings file version %d", version)
+	}
+	r.header.Encoding = Encoding(fixed[6])
+	if r.header.Encoding != Float32 && r.header.Encoding != Float16 {
+		return nil, fmt.Errorf("unsupported embedding encoding: %s", r.header.Encoding)
+	}
+	r.header.Dimension = int(binary.LittleEndian.Uint32(fixed[8:]))
+	r.header.Count = int(binary.LittleEndian.Uint32(fixed[12:]))
+	model := make([]byte, binary.LittleEndian.Uint16(fixed[16:]))
+	if _, err := io.ReadFull(r.vectors, model); err != nil {
+		return nil, fmt.Errorf("failed to read embeddings header: %w", err)
+	}
+	r.header.Model = string(model)
+	r.buf = make([]byte, r.header.Dimension*r.header.Encoding.size())
+
+	return r, nil
+}
+
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Next returns the next embedding, or io.EOF once all of them have been read
+func (r *Reader) Next() (Storage, error) {
+	if r.read >= r.header.Count {
+		return Storage{}, io.EOF
+	}
+	if _, err := io.ReadFull(r.vectors, r.buf); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return Storage{}, fmt.Errorf("failed to read embedding vector %d: %w", r.read, err)
+	}
+
+	s := Storage{
+		Model:  r.header.Model,
+		Vector: make([]float32, r.header.Dimension),
+	}
+	for i := range s.Vector {
+		if r.header.Encoding == Float16 {
+			s.Vector[i] = float16ToFloat32(binary.LittleEndian.Uint16(r.buf[i*2:]))
+		} else {
+			s.Vector[i] = math.Float32frombits(binary.LittleEndian.Uint32(r.buf[i*4:]))
+		}
+	}
+
+	if r.meta != nil {
+		var meta chunkMetadata
+		if err := r.meta.Decode(&meta); err != nil {
+			return Storage{}, fmt.Errorf("failed to read embedding metadata %d: %w", r.read, err)
+		}
+		s.GUID = meta.GUID
+		s.VectorSize = meta.VectorSize
+		s.Offset = meta.Offset
+		s.Chunk = meta.Chunk
+		s.Content = meta.Content
+	}
+
+	r.read++
+	return s, nil
+}
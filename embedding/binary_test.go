@@ -0,0 +1,164 @@
+package embedding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSaveLoad(t *testing.T) {
+	embeddings := []Storage{
+		{GUID: "episode", VectorSize: 500, Offset: 0, Chunk: 0, Model: DefaultModel, Vector: []float32{0.5, -0.25, 0, 1}, Content: "first"},
+		{GUID: "episode", VectorSize: 500, Offset: 250, Chunk: 2, Model: DefaultModel, Vector: []float32{-1, 0.125, 0.75, -0.5}, Content: "second"},
+	}
+
+	for _, format := range []Format{FormatJSON, FormatBinary, FormatBinary16} {
+		t.Run(string(format), func(t *testing.T) {
+			dataDirectory := t.TempDir()
+			err := Save(dataDirectory, DefaultModel, "episode", embeddings, format)
+			if err != nil {
+				t.Fatalf("Save: %v", err)
+			}
+			loaded, err := Load(dataDirectory, DefaultModel, "episode")
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			// Every value is exact in a float16, so nothing is lost to rounding
+			if !reflect.DeepEqual(loaded, embeddings) {
+				t.Errorf("loaded %+v, saved %+v", loaded, embeddings)
+			}
+		})
+	}
+}
+
+func TestSaveLoadEmpty(t *testing.T) {
+	dataDirectory := t.TempDir()
+	err := Save(dataDirectory, DefaultModel, "episode", nil, FormatBinary)
+	if err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(dataDirectory, DefaultModel, "episode")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(loaded) != 0 {
+		t.Errorf("loaded %d embeddings, expected none", len(loaded))
+	}
+}
+
+func TestReaderRejectsTruncated(t *testing.T) {
+	var vectors, sidecar bytes.Buffer
+	w, err := NewWriter(&vectors, &sidecar, Header{Model: DefaultModel, Dimension: 2, Count: 1, Encoding: Float32})
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	if err := w.Write(Storage{Model: DefaultModel, Vector: []float32{1, 2}}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := NewReader(bytes.NewReader(vectors.Bytes()[:vectors.Len()-1]), &sidecar)
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	if _, err := r.Next(); err == nil {
+		t.Error("read a truncated vector without an error")
+	}
+}
+
+func TestFloat16(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float32
+		half  uint16
+	}{
+		{"zero", 0, 0x0000},
+		{"negative zero", float32(math.Copysign(0, -1)), 0x8000},
+		{"one", 1, 0x3c00},
+		{"negative two", -2, 0xc000},
+		{"max half", 65504, 0x7bff},
+		{"smallest normal", float32(math.Ldexp(1, -14)), 0x0400},
+		{"largest subnormal", float32(math.Ldexp(1023, -24)), 0x03ff},
+		{"smallest subnormal", float32(math.Ldexp(1, -24)), 0x0001},
+		{"infinity", float32(math.Inf(1)), 0x7c00},
+		{"negative infinity", float32(math.Inf(-1)), 0xfc00},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if half := float32ToFloat16(test.value); half != test.half {
+				t.Errorf("float32ToFloat16(%g) = %#04x, expected %#04x", test.value, half, test.half)
+			}
+			value := float16ToFloat32(test.half)
+			if value != test.value || math.Signbit(float64(value)) != math.Signbit(float64(test.value)) {
+				t.Errorf("float16ToFloat32(%#04x) = %g, expected %g", test.half, value, test.value)
+			}
+		})
+	}
+}
+
+func TestFloat16Rounding(t *testing.T) {
+	tests := []struct {
+		name  string
+		value float32
+		half  uint16
+	}{
+		{"too large saturates", 65520, 0x7c00},
+		{"too small flushes to zero", float32(math.Ldexp(1, -26)), 0x0000},
+		{"halfway rounds to even", float32(1 + math.Ldexp(1, -11)), 0x3c00},
+		{"above halfway rounds up", float32(1 + math.Ldexp(3, -12)), 0x3c01},
+		{"subnormal halfway rounds to even", float32(math.Ldexp(3, -25)), 0x0002},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if half := float32ToFloat16(test.value); half != test.half {
+				t.Errorf("float32ToFloat16(%g) = %#04x, expected %#04x", test.value, half, test.half)
+			}
+		})
+	}
+}
+
+func TestFloat16NaN(t *testing.T) {
+	half := float32ToFloat16(float32(math.NaN()))
+	if half&0x7c00 != 0x7c00 || half&0x3ff == 0 {
+		t.Errorf("float32ToFloat16(NaN) = %#04x, expected a NaN", half)
+	}
+	if value := float16ToFloat32(half); !math.IsNaN(float64(value)) {
+		t.Errorf("float16ToFloat32(%#04x) = %g, expected NaN", half, value)
+	}
+}
+
+func TestWriteNpy(t *testing.T) {
+	var out bytes.Buffer
+	err := WriteNpy(&out, [][]float32{{1, 2, 3}, {4, 5, 6}})
+	if err != nil {
+		t.Fatalf("WriteNpy: %v", err)
+	}
+	data := out.Bytes()
+
+	if !bytes.HasPrefix(data, []byte("\x93NUMPY\x01\x00")) {
+		t.Fatalf("missing npy magic and version: %q", data[:8])
+	}
+	headerLength := int(binary.LittleEndian.Uint16(data[8:]))
+	if (10+headerLength)%64 != 0 {
+		t.Errorf("data starts at %d, expected a multiple of 64", 10+headerLength)
+	}
+	header := string(data[10 : 10+headerLength])
+	if !strings.HasPrefix(header, "{'descr': '<f4', 'fortran_order': False, 'shape': (2, 3), }") || !strings.HasSuffix(header, "\n") {
+		t.Errorf("unexpected npy header %q", header)
+	}
+
+	values := data[10+headerLength:]
+	if len(values) != 6*4 {
+		t.Fatalf("got %d bytes of data, expected %d", len(values), 6*4)
+	}
+	for i, expected := range []float32{1, 2, 3, 4, 5, 6} {
+		if value := math.Float32frombits(binary.LittleEndian.Uint32(values[i*4:])); value != expected {
+			t.Errorf("value %d = %g, expected %g", i, value, expected)
+		}
+	}
+}
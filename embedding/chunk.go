@@ -0,0 +1,77 @@
+package embedding
+
+import (
+	"strings"
+)
+
+// Window is a range of words from a transcript, End is exclusive
+type Window struct {
+	Start int
+	End   int
+}
+
+// Windows lists the overlapping windows of size words that a transcript of wordCount words is split into for
+// embedding, in the order they're embedded
+func Windows(wordCount int, size int) []Window {
+	index := 0
+	var windows []Window
+	for index < wordCount-size {
+		// Take overlapping sets of windows to use as embeddings, for example of our index is 1000 and our window size is 500
+		// Take the window on the current index, 1000-1500
+		if index+size <= wordCount {
+			windows = append(windows, Window{Start: index, End: index + size})
+		}
+		// Slide the window forwards and take the terms 1250-1750
+		if index+size+(size/2) <= wordCount {
+			windows = append(windows, Window{Start: index + (size / 2), End: index + size + (size / 2)})
+		}
+		// Slide the window backwards and take the terms 750-1250
+		if index > size {
+			windows = append(windows, Window{Start: index - (size / 2), End: index + (size / 2)})
+		}
+
+		index += size
+	}
+	return windows
+}
+
+// RecoverOffsets fills in the Offset of embeddings stored before offsets were recorded, by matching their content
+// against the windows of the transcript they were embedded from. Embeddings that don't match any window, because the
+// transcript has changed since, are left at 0
+func RecoverOffsets(transcript string, embeddings []Storage) {
+	if len(embeddings) < 2 {
+		return
+	}
+	for _, e := range embeddings {
+		if e.Offset != 0 {
+			return
+		}
+	}
+
+	words := strings.Fields(transcript)
+	windows := Windows(len(words), embeddings[0].VectorSize)
+	next := 0
+	for i := range embeddings {
+		content := strings.Fields(embeddings[i].Content)
+		// Embeddings are stored in window order, but may skip windows that failed to embed
+		for j := next; j < len(windows); j++ {
+			if equalWords(words[windows[j].Start:windows[j].End], content) {
+				embeddings[i].Offset = windows[j].Start
+				next = j + 1
+				break
+			}
+		}
+	}
+}
+
+func equalWords(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,239 @@
+package embedding
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	// OpenAI will accept at most 2048 inputs in a single embeddings request, each of which can be up to 8191 tokens
+	maxBatchInputs = 2048
+	maxInputTokens = 8191
+)
+
+// EmbedderConfig controls how inputs are batched up and submitted for embedding
+type EmbedderConfig struct {
+	Model openai.EmbeddingModel
+	// Workers is the number of requests which may be in flight at once
+	Workers int
+	// RequestsPerMinute and TokensPerMinute should match the rate limits on the OpenAI account
+	RequestsPerMinute int
+	TokensPerMinute   int
+	// MaxRequestTokens caps the estimated number of tokens packed into a single request
+	MaxRequestTokens int
+	// MaxRetries is how many times a retryable failure is retried, with exponential backoff starting at RetryBackoff
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// AllowGaps reports inputs which could not be embedded as Gaps instead of failing the whole run
+	AllowGaps bool
+	// OnBatch and OnRetry are called, when set, as each batch is submitted and before each retry, to report progress
+	OnBatch func(batch int, batches int, inputs int, tokens int)
+	OnRetry func(inputs int, delay time.Duration, err error)
+}
+
+// Gap records an input which could not be embedded
+type Gap struct {
+	Input int
+	Error string
+}
+
+// Embedder generates embeddings for many inputs at once, packing them into as few requests as the API limits
+// allow and spreading those requests across a rate limited pool of workers
+type Embedder struct {
+	client  *openai.Client
+	config  EmbedderConfig
+	limiter *Limiter
+}
+
+type batch struct {
+	inputs []int
+	tokens int
+}
+
+func NewEmbedder(client *openai.Client, config EmbedderConfig) *Embedder {
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	if config.MaxRequestTokens < maxInputTokens {
+		config.MaxRequestTokens = maxInputTokens
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = time.Second
+	}
+	return &Embedder{
+		client:  client,
+		config:  config,
+		limiter: NewLimiter(config.RequestsPerMinute, config.TokensPerMinute),
+	}
+}
+
+// EstimateTokens gives a conservative estimate of how many tokens the text will be encoded to, without pulling
+// in a full tokenizer. English text averages about 4 characters per token, so assume 3 to leave some headroom
+func EstimateTokens(text string) int {
+	return len(text)/3 + 1
+}
+
+// pack greedily groups inputs, in order, into batches that fit under the input count and token limits
+func (e *Embedder) pack(inputs []string) ([]batch, error) {
+	var batches []batch
+	var current batch
+	for i, input := range inputs {
+		tokens := EstimateTokens(input)
+		if tokens > maxInputTokens {
+			return nil, fmt.Errorf("input %d is an estimated %d tokens, which is over the %d token limit for a single input", i, tokens, maxInputTokens)
+		}
+		if len(current.inputs) > 0 && (len(current.inputs) >= maxBatchInputs || current.tokens+tokens > e.config.MaxRequestTokens) {
+			batches = append(batches, current)
+			current = batch{}
+		}
+		current.inputs = append(current.inputs, i)
+		current.tokens += tokens
+	}
+	if len(current.inputs) > 0 {
+		batches = append(batches, current)
+	}
+	return batches, nil
+}
+
+// retryable reports whether a failed request is worth trying again, which is the case for rate limiting, server
+// errors, and anything that didn't get an http response at all
+func retryable(err error) bool {
+	var status int
+	var apiErr *openai.APIError
+	var requestErr *openai.RequestError
+	switch {
+	case errors.As(err, &apiErr):
+		status = apiErr.HTTPStatusCode
+	case errors.As(err, &requestErr):
+		status = requestErr.HTTPStatusCode
+	default:
+		return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded)
+	}
+	return status == http.StatusTooManyRequests || status >= http.StatusInternalServerError || status == 0
+}
+
+func (e *Embedder) embedBatch(ctx context.Context, inputs []string, b batch) ([]openai.Embedding, error) {
+	request := openai.EmbeddingRequestStrings{
+		Input: make([]string, len(b.inputs)),
+		Model: e.config.Model,
+	}
+	for i, input := range b.inputs {
+		request.Input[i] = inputs[input]
+	}
+
+	backoff := e.config.RetryBackoff
+	for attempt := 0; ; attempt++ {
+		err := e.limiter.Wait(ctx, b.tokens)
+		if err != nil {
+			return nil, err
+		}
+
+		response, err := e.client.CreateEmbeddings(ctx, request)
+		if err == nil {
+			if len(response.Data) != len(request.Input) {
+				return nil, fmt.Errorf("expected %d embeddings in response but got %d", len(request.Input), len(response.Data))
+			}
+			return response.Data, nil
+		}
+		if attempt >= e.config.MaxRetries || !retryable(err) {
+			return nil, err
+		}
+
+		// Back off exponentially with some jitter so a pool of workers that all got rate limited together don't
+		// all retry in lockstep
+		delay := backoff + time.Duration(rand.Int63n(int64(backoff)/2+1))
+		if e.config.OnRetry != nil {
+			e.config.OnRetry(len(request.Input), delay, err)
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
+// Embed generates an embedding vector for each input, returned in the same order as the inputs. When AllowGaps is
+// set, inputs which could not be embedded are left nil and reported in the returned gaps, otherwise the first
+// failure cancels any outstanding work and is returned, along with the vectors of the inputs embedded before it
+// and nil for the rest
+func (e *Embedder) Embed(ctx context.Context, inputs []string) ([][]float32, []Gap, error) {
+	batches, err := e.pack(inputs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	vectors := make([][]float32, len(inputs))
+	var gaps []Gap
+	var firstErr error
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+
+	work := make(chan batch)
+	for w := 0; w < e.config.Workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for b := range work {
+				data, err := e.embedBatch(ctx, inputs, b)
+
+				mu.Lock()
+				if err != nil {
+					if !e.config.AllowGaps {
+						if firstErr == nil {
+							firstErr = fmt.Errorf("failed to embed inputs %d-%d: %w", b.inputs[0], b.inputs[len(b.inputs)-1], err)
+						}
+						cancel()
+					}
+					for _, input := range b.inputs {
+						gaps = append(gaps, Gap{Input: input, Error: err.Error()})
+					}
+				} else {
+					for i, input := range b.inputs {
+						vectors[input] = data[i].Embedding
+					}
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+	for i, b := range batches {
+		select {
+		case work <- b:
+			if e.config.OnBatch != nil {
+				e.config.OnBatch(i+1, len(batches), len(b.inputs), b.tokens)
+			}
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return vectors, nil, firstErr
+	}
+	if err := ctx.Err(); err != nil {
+		return vectors, nil, err
+	}
+
+	sort.Slice(gaps, func(i, j int) bool { return gaps[i].Input < gaps[j].Input })
+	return vectors, gaps, nil
+}
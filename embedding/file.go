@@ -0,0 +1,233 @@
+package embedding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Format selects how embeddings are written to the data directory
+type Format string
+
+const (
+	FormatJSON     Format = "json"
+	FormatBinary   Format = "binary"
+	FormatBinary16 Format = "binary16"
+)
+
+func ParseFormat(format string) (Format, error) {
+	switch f := Format(format); f {
+	case FormatJSON, FormatBinary, FormatBinary16:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown embeddings format %q, expected one of %s, %s or %s", format, FormatJSON, FormatBinary, FormatBinary16)
+}
+
+// Embeddings are stored per model, so an episode can be embedded by several models side by side
+func episodePath(dataDirectory string, model string, GUID string, suffix string) string {
+	return filepath.Join(dataDirectory, fmt.Sprintf("%s.%s.embeddings.%s", GUID, model, suffix))
+}
+
+// legacyPath is where embeddings were stored before they were kept per model, these are all DefaultModel
+func legacyPath(dataDirectory string, GUID string, suffix string) string {
+	return filepath.Join(dataDirectory, fmt.Sprintf("%s.embeddings.%s", GUID, suffix))
+}
+
+func JSONPath(dataDirectory string, model string, GUID string) string {
+	return episodePath(dataDirectory, model, GUID, "json")
+}
+
+func BinaryPath(dataDirectory string, model string, GUID string) string {
+	return episodePath(dataDirectory, model, GUID, "bin")
+}
+
+func SidecarPath(dataDirectory string, model string, GUID string) string {
+	return episodePath(dataDirectory, model, GUID, "jsonl")
+}
+
+func NpyPath(dataDirectory string, model string, GUID string) string {
+	return episodePath(dataDirectory, model, GUID, "npy")
+}
+
+func GapsPath(dataDirectory string, model string, GUID string) string {
+	return episodePath(dataDirectory, model, GUID, "gaps.json")
+}
+
+// Save writes an episodes embeddings from a model to the data directory in the given format
+func Save(dataDirectory string, model string, GUID string, embeddings []Storage, format Format) error {
+	if format == FormatJSON {
+		embeddingBytes, err := json.MarshalIndent(embeddings, "", " ")
+		if err != nil {
+			return fmt.Errorf("failed to serialize embeddings data for episode %s: %w", GUID, err)
+		}
+		err = os.WriteFile(JSONPath(dataDirectory, model, GUID), embeddingBytes, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write embeddings data for episode %s: %w", GUID, err)
+		}
+		// Load prefers the binary format, so clear out any older binary files that would shadow this one
+		for _, path := range []string{BinaryPath(dataDirectory, model, GUID), SidecarPath(dataDirectory, model, GUID)} {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to remove stale embeddings file %s: %w", path, err)
+			}
+		}
+		return nil
+	}
+
+	if _, err := ParseFormat(string(format)); err != nil {
+		return err
+	}
+	header := Header{Model: model, Count: len(embeddings), Encoding: Float32}
+	if format == FormatBinary16 {
+		header.Encoding = Float16
+	}
+	if len(embeddings) > 0 {
+		header.Dimension = len(embeddings[0].Vector)
+	} else {
+		// Nothing to write, but the header still needs to be valid
+		header.Dimension = 1
+	}
+
+	vectorFile, err := os.Create(BinaryPath(dataDirectory, model, GUID))
+	if err != nil {
+		return fmt.Errorf("failed to create embeddings file for episode %s: %w", GUID, err)
+	}
+	defer vectorFile.Close()
+	sidecarFile, err := os.Create(SidecarPath(dataDirectory, model, GUID))
+	if err != nil {
+		return fmt.Errorf("failed to create embeddings sidecar for episode %s: %w", GUID, err)
+	}
+	defer sidecarFile.Close()
+
+	w, err := NewWriter(vectorFile, sidecarFile, header)
+	if err != nil {
+		return fmt.Errorf("failed to write embeddings for episode %s: %w", GUID, err)
+	}
+	for i, e := range embeddings {
+		if e.Model != header.Model {
+			return fmt.Errorf("embedding %d of episode %s is from model %s, expected %s", i, GUID, e.Model, header.Model)
+		}
+		if err := w.Write(e); err != nil {
+			return fmt.Errorf("failed to write embedding %d of episode %s: %w", i, GUID, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to write embeddings for episode %s: %w", GUID, err)
+	}
+
+	if err := vectorFile.Close(); err != nil {
+		return fmt.Errorf("failed to write embeddings file for episode %s: %w", GUID, err)
+	}
+	if err := sidecarFile.Close(); err != nil {
+		return fmt.Errorf("failed to write embeddings sidecar for episode %s: %w", GUID, err)
+	}
+	return nil
+}
+
+// Load reads an episodes embeddings from a model out of the data directory, preferring the binary format and
+// falling back to JSON, and to the older files from before embeddings were stored per model
+func Load(dataDirectory string, model string, GUID string) ([]Storage, error) {
+	embeddings, err := load(BinaryPath(dataDirectory, model, GUID), SidecarPath(dataDirectory, model, GUID), JSONPath(dataDirectory, model, GUID))
+	if errors.Is(err, os.ErrNotExist) && model == DefaultModel {
+		embeddings, err = load(legacyPath(dataDirectory, GUID, "bin"), legacyPath(dataDirectory, GUID, "jsonl"), legacyPath(dataDirectory, GUID, "json"))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s embeddings for episode %s: %w", model, GUID, err)
+	}
+	err = recoverChunks(embeddings, GapsPath(dataDirectory, model, GUID))
+	if err != nil {
+		return nil, fmt.Errorf("could not load %s embeddings for episode %s: %w", model, GUID, err)
+	}
+	return embeddings, nil
+}
+
+// recoverChunks fills in the Chunk of embeddings saved before chunks recorded their position, which is their
+// position in the saved embeddings once any chunks listed in the gaps file are skipped over
+func recoverChunks(embeddings []Storage, gapsPath string) error {
+	for _, e := range embeddings {
+		if e.Chunk != 0 {
+			return nil
+		}
+	}
+
+	var gaps []Gap
+	gapBytes, err := os.ReadFile(gapsPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to read embedding gaps: %w", err)
+	}
+	if err == nil {
+		err = json.Unmarshal(gapBytes, &gaps)
+		if err != nil {
+			return fmt.Errorf("failed to parse embedding gaps: %w", err)
+		}
+	}
+	missing := make(map[int]bool, len(gaps))
+	for _, gap := range gaps {
+		missing[gap.Input] = true
+	}
+
+	chunk := 0
+	for i := range embeddings {
+		for missing[chunk] {
+			chunk++
+		}
+		embeddings[i].Chunk = chunk
+		chunk++
+	}
+	return nil
+}
+
+// RemoveLegacyJSON removes JSON embeddings from before they were stored per model
+func RemoveLegacyJSON(dataDirectory string, GUID string) error {
+	err := os.Remove(legacyPath(dataDirectory, GUID, "json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove legacy embeddings for episode %s: %w", GUID, err)
+	}
+	return nil
+}
+
+func load(binaryPath string, sidecarPath string, jsonPath string) ([]Storage, error) {
+	vectorFile, err := os.Open(binaryPath)
+	if errors.Is(err, os.ErrNotExist) {
+		embeddingBytes, err := os.ReadFile(jsonPath)
+		if err != nil {
+			return nil, err
+		}
+		var embeddings []Storage
+		err = json.Unmarshal(embeddingBytes, &embeddings)
+		if err != nil {
+			return nil, err
+		}
+		return embeddings, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer vectorFile.Close()
+
+	sidecarFile, err := os.Open(sidecarPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not load metadata: %w", err)
+	}
+	defer sidecarFile.Close()
+
+	r, err := NewReader(vectorFile, sidecarFile)
+	if err != nil {
+		return nil, err
+	}
+	embeddings := make([]Storage, 0, r.Header().Count)
+	for {
+		e, err := r.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		embeddings = append(embeddings, e)
+	}
+
+	return embeddings, nil
+}
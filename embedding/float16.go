@@ -0,0 +1,72 @@
+package embedding
+
+import "math"
+
+// float32ToFloat16 converts to IEEE 754 half precision, rounding to nearest even. Embedding values are all well
+// inside the range of a float16 so precision is the only thing lost
+func float32ToFloat16(f float32) uint16 {
+	bits := math.Float32bits(f)
+	sign := uint16(bits>>16) & 0x8000
+	exponent := int32(bits>>23&0xff) - 127 + 15
+	mantissa := bits & 0x7fffff
+
+	switch {
+	case bits&0x7fffffff == 0:
+		return sign
+	case bits>>23&0xff == 0xff:
+		// Infinity or NaN
+		if mantissa != 0 {
+			return sign | 0x7e00
+		}
+		return sign | 0x7c00
+	case exponent >= 0x1f:
+		// Too large, saturate to infinity
+		return sign | 0x7c00
+	case exponent <= 0:
+		// Subnormal half, or too small and flushed to zero
+		if exponent < -10 {
+			return sign
+		}
+		mantissa |= 0x800000
+		shift := uint32(14 - exponent)
+		half := uint16(mantissa >> shift)
+		remainder := mantissa & (1<<shift - 1)
+		halfway := uint32(1) << (shift - 1)
+		if remainder > halfway || (remainder == halfway && half&1 == 1) {
+			half++
+		}
+		return sign | half
+	}
+
+	half := sign | uint16(exponent)<<10 | uint16(mantissa>>13)
+	remainder := mantissa & 0x1fff
+	if remainder > 0x1000 || (remainder == 0x1000 && half&1 == 1) {
+		// Rounding may carry into the exponent, which is still the correct result
+		half++
+	}
+	return half
+}
+
+// float16ToFloat32 converts from IEEE 754 half precision
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h&0x8000) << 16
+	exponent := int32(h>>10) & 0x1f
+	mantissa := uint32(h & 0x3ff)
+
+	switch {
+	case exponent == 0 && mantissa == 0:
+		return math.Float32frombits(sign)
+	case exponent == 0:
+		// Subnormal, normalise it for float32
+		for mantissa&0x400 == 0 {
+			mantissa <<= 1
+			exponent--
+		}
+		exponent++
+		mantissa &= 0x3ff
+	case exponent == 0x1f:
+		return math.Float32frombits(sign | 0x7f800000 | mantissa<<13)
+	}
+
+	return math.Float32frombits(sign | uint32(exponent+127-15)<<23 | mantissa<<13)
+}
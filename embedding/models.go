@@ -0,0 +1,101 @@
+package embedding
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// DefaultModel is the model everything was originally embedded with, before we kept track of models
+	DefaultModel = "text-embedding-ada-002"
+
+	activeModelFile = "embedding-model.json"
+)
+
+// Model describes an embedding model we know how to index
+type Model struct {
+	Name      string
+	Dimension int
+}
+
+var models = map[string]Model{
+	"text-embedding-ada-002": {Name: "text-embedding-ada-002", Dimension: 1536},
+	"text-embedding-3-small": {Name: "text-embedding-3-small", Dimension: 1536},
+	"text-embedding-3-large": {Name: "text-embedding-3-large", Dimension: 3072},
+}
+
+func LookupModel(name string) (Model, error) {
+	model, ok := models[name]
+	if !ok {
+		known := make([]string, 0, len(models))
+		for k := range models {
+			known = append(known, k)
+		}
+		sort.Strings(known)
+		return Model{}, fmt.Errorf("unknown embedding model %q, expected one of %s", name, strings.Join(known, ", "))
+	}
+	return model, nil
+}
+
+// ResolveModel looks up a model by name, falling back to the active model when no name is given
+func ResolveModel(dataDirectory string, name string) (Model, error) {
+	if name == "" {
+		var err error
+		name, err = ActiveModel(dataDirectory)
+		if err != nil {
+			return Model{}, err
+		}
+	}
+	return LookupModel(name)
+}
+
+type activeModel struct {
+	Model string
+}
+
+// ActiveModel returns the model queries should be embedded with by default, which is the last model that was
+// fully embedded and indexed
+func ActiveModel(dataDirectory string) (string, error) {
+	modelBytes, err := os.ReadFile(filepath.Join(dataDirectory, activeModelFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return DefaultModel, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read active embedding model: %w", err)
+	}
+
+	var active activeModel
+	err = json.Unmarshal(modelBytes, &active)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse active embedding model: %w", err)
+	}
+	return active.Model, nil
+}
+
+// SetActiveModel switches the default model for queries, the file is replaced with a rename so readers never
+// see a partially written model
+func SetActiveModel(dataDirectory string, name string) error {
+	if _, err := LookupModel(name); err != nil {
+		return err
+	}
+
+	modelBytes, err := json.MarshalIndent(activeModel{Model: name}, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal active embedding model: %w", err)
+	}
+	tmp := filepath.Join(dataDirectory, activeModelFile+".tmp")
+	err = os.WriteFile(tmp, modelBytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write active embedding model: %w", err)
+	}
+	err = os.Rename(tmp, filepath.Join(dataDirectory, activeModelFile))
+	if err != nil {
+		return fmt.Errorf("failed to write active embedding model: %w", err)
+	}
+	return nil
+}
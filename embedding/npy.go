@@ -0,0 +1,57 @@
+package embedding
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// WriteNpy writes the vectors as a 2d float32 numpy array (version 1.0 of the .npy format), so they can be loaded
+// straight into a notebook with numpy.load
+func WriteNpy(w io.Writer, vectors [][]float32) error {
+	dimension := 0
+	if len(vectors) > 0 {
+		dimension = len(vectors[0])
+	}
+
+	header := fmt.Sprintf("{'descr': '<f4', 'fortran_order': False, 'shape': (%d, %d), }", len(vectors), dimension)
+	// The magic string, version and header length take 10 bytes, and the header is padded out with spaces and
+	// terminated with a newline so the data starts on a 64 byte boundary
+	padding := 64 - (10+len(header)+1)%64
+	if padding == 64 {
+		padding = 0
+	}
+	header += strings.Repeat(" ", padding) + "\n"
+
+	out := bufio.NewWriter(w)
+	_, err := out.WriteString("\x93NUMPY\x01\x00")
+	if err != nil {
+		return fmt.Errorf("failed to write npy header: %w", err)
+	}
+	err = binary.Write(out, binary.LittleEndian, uint16(len(header)))
+	if err != nil {
+		return fmt.Errorf("failed to write npy header: %w", err)
+	}
+	_, err = out.WriteString(header)
+	if err != nil {
+		return fmt.Errorf("failed to write npy header: %w", err)
+	}
+
+	buf := make([]byte, 4*dimension)
+	for i, vector := range vectors {
+		if len(vector) != dimension {
+			return fmt.Errorf("vector %d has %d dimensions, expected %d", i, len(vector), dimension)
+		}
+		for j, v := range vector {
+			binary.LittleEndian.PutUint32(buf[j*4:], math.Float32bits(v))
+		}
+		if _, err := out.Write(buf); err != nil {
+			return fmt.Errorf("failed to write npy data: %w", err)
+		}
+	}
+
+	return out.Flush()
+}
@@ -0,0 +1,97 @@
+package embedding
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// bucket is a token bucket which refills continuously up to its capacity over the course of a minute
+type bucket struct {
+	capacity float64
+	tokens   float64
+}
+
+func (b *bucket) refill(elapsed time.Duration) {
+	b.tokens += elapsed.Minutes() * b.capacity
+	if b.tokens > b.capacity {
+		b.tokens = b.capacity
+	}
+}
+
+// wait returns how long until n tokens will be available in the bucket
+func (b *bucket) wait(n float64) time.Duration {
+	if n > b.capacity {
+		// Requests bigger than the whole bucket would never be satisfied, so let them through once it's full
+		n = b.capacity
+	}
+	if b.tokens >= n {
+		return 0
+	}
+	return time.Duration((n - b.tokens) / b.capacity * float64(time.Minute))
+}
+
+// Limiter rate limits requests against both a requests per minute and a tokens per minute budget, which is
+// how OpenAI applies its limits to the embeddings API
+type Limiter struct {
+	mu       sync.Mutex
+	last     time.Time
+	requests *bucket
+	tokens   *bucket
+}
+
+// NewLimiter creates a Limiter which starts with full buckets, a zero or negative limit disables that bucket
+func NewLimiter(requestsPerMinute int, tokensPerMinute int) *Limiter {
+	l := &Limiter{last: time.Now()}
+	if requestsPerMinute > 0 {
+		l.requests = &bucket{capacity: float64(requestsPerMinute), tokens: float64(requestsPerMinute)}
+	}
+	if tokensPerMinute > 0 {
+		l.tokens = &bucket{capacity: float64(tokensPerMinute), tokens: float64(tokensPerMinute)}
+	}
+	return l
+}
+
+// Wait blocks until a single request of the given number of tokens is allowed, or the context is cancelled
+func (l *Limiter) Wait(ctx context.Context, tokens int) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		elapsed := now.Sub(l.last)
+		l.last = now
+
+		var delay time.Duration
+		for _, b := range []struct {
+			bucket *bucket
+			n      float64
+		}{{l.requests, 1}, {l.tokens, float64(tokens)}} {
+			if b.bucket == nil {
+				continue
+			}
+			b.bucket.refill(elapsed)
+			if d := b.bucket.wait(b.n); d > delay {
+				delay = d
+			}
+		}
+
+		if delay == 0 {
+			if l.requests != nil {
+				l.requests.tokens--
+			}
+			if l.tokens != nil {
+				l.tokens.tokens -= min(float64(tokens), l.tokens.capacity)
+			}
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
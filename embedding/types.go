@@ -3,7 +3,12 @@ package embedding
 type Storage struct {
 	GUID       string
 	VectorSize int
-	Model      string
-	Vector     []float32
-	Content    string
+	// Offset is the position in the transcript of the first word of Content
+	Offset int
+	// Chunk is the position of Content among the chunks of the transcript, which identifies it in the index.
+	// Chunks that couldn't be embedded are left out, so it can be ahead of the position in the saved embeddings
+	Chunk   int `json:",omitempty"`
+	Model   string
+	Vector  []float32
+	Content string
 }
@@ -0,0 +1,183 @@
+package entities
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+// Kinds of entity that are extracted
+const (
+	KindCompany = "company"
+	KindPerson  = "person"
+	KindProduct = "product"
+	KindProject = "project"
+	KindPaper   = "paper"
+)
+
+var kinds = []string{KindCompany, KindPerson, KindProduct, KindProject, KindPaper}
+
+const (
+	extractPrompt = "You are helping build a knowledge base from transcripts of Oxide and Friends, a podcast about computer hardware, systems software and the computer industry. " +
+		"Given a passage from a transcript, list the named entities mentioned in it: companies, people, products, projects (including open source software and programming languages) and papers (including books and RFDs). " +
+		"Use the most complete, canonical form of each name, and leave out anything that isn't named, like \"the company\" or \"my colleague\". " +
+		`Respond with a JSON object of the form {"entities": [{"name": "...", "kind": "company|person|product|project|paper"}]}.`
+)
+
+// Entity is something named in a transcript
+type Entity struct {
+	Name string
+	Kind string
+}
+
+// Chunk holds the entities named in one chunk of a transcript
+type Chunk struct {
+	VectorId int
+	// Content is the chunk text the entities were extracted from, so they can be extracted again if the chunking
+	// changes
+	Content  string
+	Entities []Entity
+}
+
+// Episode holds the entities for every chunk of an episode
+type Episode struct {
+	GUID string
+	// Extractor is the extractor the entities came from, either ExtractorChat or ExtractorRules
+	Extractor string
+	Chunks    []Chunk
+}
+
+// Names are the distinct names of the entities in the episode, in the order they're first mentioned
+func (e *Episode) Names() []string {
+	if e == nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, chunk := range e.Chunks {
+		for _, entity := range chunk.Entities {
+			if !seen[entity.Name] {
+				seen[entity.Name] = true
+				names = append(names, entity.Name)
+			}
+		}
+	}
+	return names
+}
+
+// ChunkNames are the names of the entities in one of the episodes chunks
+func (e *Episode) ChunkNames(vectorId int) []string {
+	if e == nil {
+		return nil
+	}
+	for _, chunk := range e.Chunks {
+		if chunk.VectorId != vectorId {
+			continue
+		}
+		names := make([]string, len(chunk.Entities))
+		for i, entity := range chunk.Entities {
+			names[i] = entity.Name
+		}
+		return names
+	}
+	return nil
+}
+
+func path(dataDirectory string, GUID string) string {
+	return filepath.Join(dataDirectory, fmt.Sprintf("%s.entities.json", GUID))
+}
+
+// Load reads the entities for an episode, returning nil if none have been extracted
+func Load(dataDirectory string, GUID string) (*Episode, error) {
+	entityBytes, err := os.ReadFile(path(dataDirectory, GUID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entities for episode %s: %w", GUID, err)
+	}
+
+	var episode Episode
+	err = json.Unmarshal(entityBytes, &episode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse entities for episode %s: %w", GUID, err)
+	}
+	return &episode, nil
+}
+
+func Save(dataDirectory string, episode *Episode) error {
+	entityBytes, err := json.MarshalIndent(episode, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize entities for episode %s: %w", episode.GUID, err)
+	}
+	err = os.WriteFile(path(dataDirectory, episode.GUID), entityBytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write entities for episode %s: %w", episode.GUID, err)
+	}
+	return nil
+}
+
+// Extractors find the entities in a chunk of transcript
+const (
+	ExtractorChat  = "chat"
+	ExtractorRules = "rules"
+)
+
+type Extractor interface {
+	Extract(ctx context.Context, content string) ([]Entity, error)
+}
+
+// Chat asks a chat model for the entities in a chunk
+type Chat struct {
+	Client *openai.Client
+	Model  string
+}
+
+func (c Chat) Extract(ctx context.Context, content string) ([]Entity, error) {
+	response, err := c.Client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: c.Model,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: extractPrompt,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: content,
+			},
+		},
+		ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
+		Temperature:    0,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to extract entities: %w", err)
+	}
+
+	var extracted struct {
+		Entities []Entity `json:"entities"`
+	}
+	err = json.Unmarshal([]byte(response.Choices[0].Message.Content), &extracted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse extracted entities: %w", err)
+	}
+
+	var found []Entity
+	seen := make(map[string]bool)
+	for _, entity := range extracted.Entities {
+		entity.Name = strings.TrimSpace(entity.Name)
+		entity.Kind = strings.ToLower(strings.TrimSpace(entity.Kind))
+		if entity.Name == "" || seen[strings.ToLower(entity.Name)] || !slices.Contains(kinds, entity.Kind) {
+			continue
+		}
+		seen[strings.ToLower(entity.Name)] = true
+		found = append(found, entity)
+	}
+	return found, nil
+}
@@ -0,0 +1,210 @@
+package entities
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"oxide-search/manifest"
+)
+
+const (
+	pagesDirectory = "entities"
+	pagesName      = "pages.json"
+
+	// mentionContextWords is how many words either side of a mention are quoted with it
+	mentionContextWords = 15
+)
+
+// Mention is a chunk of a transcript an entity is named in
+type Mention struct {
+	GUID      string
+	Title     string
+	Link      string
+	Published string
+	VectorId  int
+	// Context is the words around the first place the entity is named in the chunk
+	Context string
+}
+
+// Page is everything the archive says about an entity, a page of the glossary
+type Page struct {
+	Name     string
+	Slug     string
+	Kind     string
+	Episodes int
+	Mentions []Mention
+}
+
+// Pages builds a glossary page for every entity named in the episodes, most mentioned first. Names differing only
+// in case are the same entity, going by the spelling and kind they're most often given
+func Pages(episodes []*Episode, data map[string]manifest.EpisodeData) []Page {
+	type entry struct {
+		page      *Page
+		spellings map[string]int
+		kinds     map[string]int
+		episodes  map[string]bool
+	}
+	entries := make(map[string]*entry)
+	var order []string
+	for _, episode := range episodes {
+		details := data[episode.GUID]
+		for _, chunk := range episode.Chunks {
+			for _, entity := range chunk.Entities {
+				key := strings.ToLower(entity.Name)
+				e, ok := entries[key]
+				if !ok {
+					e = &entry{page: &Page{}, spellings: make(map[string]int), kinds: make(map[string]int), episodes: make(map[string]bool)}
+					entries[key] = e
+					order = append(order, key)
+				}
+				e.spellings[entity.Name]++
+				e.kinds[entity.Kind]++
+				e.episodes[episode.GUID] = true
+				e.page.Mentions = append(e.page.Mentions, Mention{
+					GUID:      episode.GUID,
+					Title:     details.Title,
+					Link:      details.Link,
+					Published: details.Published,
+					VectorId:  chunk.VectorId,
+					Context:   mentionContext(chunk.Content, entity.Name),
+				})
+			}
+		}
+	}
+
+	pages := make([]Page, 0, len(order))
+	for _, key := range order {
+		e := entries[key]
+		e.page.Name = mostCommon(e.spellings)
+		e.page.Slug = Slug(e.page.Name)
+		e.page.Kind = mostCommon(e.kinds)
+		e.page.Episodes = len(e.episodes)
+		pages = append(pages, *e.page)
+	}
+	sort.SliceStable(pages, func(i, j int) bool { return len(pages[i].Mentions) > len(pages[j].Mentions) })
+	return pages
+}
+
+// Canonicalize renames the entities of the episodes to the name and kind of their page, so every mention of an
+// entity is indexed under the same name as its page
+func Canonicalize(episodes []*Episode, pages []Page) {
+	canonical := make(map[string]Entity, len(pages))
+	for _, page := range pages {
+		canonical[strings.ToLower(page.Name)] = Entity{Name: page.Name, Kind: page.Kind}
+	}
+	for _, episode := range episodes {
+		for i := range episode.Chunks {
+			for j, entity := range episode.Chunks[i].Entities {
+				if c, ok := canonical[strings.ToLower(entity.Name)]; ok {
+					episode.Chunks[i].Entities[j] = c
+				}
+			}
+		}
+	}
+}
+
+// mostCommon is the most counted value, the alphabetically first of any ties
+func mostCommon(counts map[string]int) string {
+	var best string
+	for value, count := range counts {
+		if count > counts[best] || (count == counts[best] && value < best) {
+			best = value
+		}
+	}
+	return best
+}
+
+// mentionContext is the words around the first place the name appears in the content, or the start of the content
+// if the name was written differently in it
+func mentionContext(content string, name string) string {
+	words := strings.Fields(content)
+	nameWords := len(strings.Fields(name))
+	at := 0
+	for i := range words {
+		if strings.Contains(strings.ToLower(strings.Join(words[i:min(len(words), i+nameWords)], " ")), strings.ToLower(name)) {
+			at = i
+			break
+		}
+	}
+	from, until := max(0, at-mentionContextWords), min(len(words), at+nameWords+mentionContextWords)
+	return strings.Join(words[from:until], " ")
+}
+
+var notSlug = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Slug is the name of an entities page, its name in lower case with anything but letters and numbers as dashes
+func Slug(name string) string {
+	return strings.Trim(notSlug.ReplaceAllString(strings.ToLower(name), "-"), "-")
+}
+
+// Markdown renders the page, listing every mention grouped by episode
+func (p Page) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", p.Name)
+	fmt.Fprintf(&b, "%s, mentioned %d times in %d episodes\n", p.Kind, len(p.Mentions), p.Episodes)
+	previous := ""
+	for _, mention := range p.Mentions {
+		if mention.GUID != previous {
+			fmt.Fprintf(&b, "\n## [%s](%s)\n\n", mention.Title, mention.Link)
+			if mention.Published != "" {
+				fmt.Fprintf(&b, "%s\n\n", mention.Published)
+			}
+			previous = mention.GUID
+		}
+		fmt.Fprintf(&b, "- chunk %d: \"%s\"\n", mention.VectorId, mention.Context)
+	}
+	return b.String()
+}
+
+// WritePages writes a markdown file for each page, and all of them as JSON for the service, to the entities
+// directory under the data directory. Pages of entities that are no longer mentioned are left behind
+func WritePages(dataDirectory string, pages []Page) error {
+	directory := filepath.Join(dataDirectory, pagesDirectory)
+	err := os.MkdirAll(directory, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create glossary directory: %w", err)
+	}
+	for _, page := range pages {
+		if page.Slug == "" {
+			continue
+		}
+		err = os.WriteFile(filepath.Join(directory, page.Slug+".md"), []byte(page.Markdown()), 0644)
+		if err != nil {
+			return fmt.Errorf("failed to write glossary page for %s: %w", page.Name, err)
+		}
+	}
+
+	pageBytes, err := json.MarshalIndent(pages, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize glossary: %w", err)
+	}
+	err = os.WriteFile(filepath.Join(directory, pagesName), pageBytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write glossary: %w", err)
+	}
+	return nil
+}
+
+// LoadPages reads the pages written by WritePages, returning nil if they haven't been written
+func LoadPages(dataDirectory string) ([]Page, error) {
+	pageBytes, err := os.ReadFile(filepath.Join(dataDirectory, pagesDirectory, pagesName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read glossary: %w", err)
+	}
+
+	var pages []Page
+	err = json.Unmarshal(pageBytes, &pages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse glossary: %w", err)
+	}
+	return pages, nil
+}
@@ -0,0 +1,121 @@
+package entities
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+const knownName = "entities.json"
+
+// Known are entities to look for by name, keyed by the canonical name. Aliases are other names the entity goes by
+// in the transcripts
+type Known map[string]KnownEntity
+
+type KnownEntity struct {
+	Kind    string
+	Aliases []string `json:",omitempty"`
+}
+
+// DefaultKnown is the entities that come up most on the podcast
+func DefaultKnown() Known {
+	return Known{
+		"Oxide Computer Company": {Kind: KindCompany, Aliases: []string{"Oxide"}},
+		"Sun Microsystems":       {Kind: KindCompany, Aliases: []string{"Sun"}},
+		"Joyent":                 {Kind: KindCompany},
+		"Intel":                  {Kind: KindCompany},
+		"AMD":                    {Kind: KindCompany},
+		"Google":                 {Kind: KindCompany},
+		"Amazon Web Services":    {Kind: KindCompany, Aliases: []string{"AWS"}},
+		"Microsoft":              {Kind: KindCompany},
+		"Apple":                  {Kind: KindCompany},
+		"Bryan Cantrill":         {Kind: KindPerson, Aliases: []string{"Cantrill"}},
+		"Adam Leventhal":         {Kind: KindPerson, Aliases: []string{"Leventhal"}},
+		"Steve Tuck":             {Kind: KindPerson},
+		"Oxide rack":             {Kind: KindProduct, Aliases: []string{"Oxide Cloud Computer"}},
+		"Gimlet":                 {Kind: KindProduct},
+		"Sidecar":                {Kind: KindProduct},
+		"illumos":                {Kind: KindProject},
+		"Helios":                 {Kind: KindProject},
+		"Hubris":                 {Kind: KindProject},
+		"Humility":               {Kind: KindProject},
+		"Propolis":               {Kind: KindProject},
+		"Omicron":                {Kind: KindProject},
+		"Rust":                   {Kind: KindProject},
+		"DTrace":                 {Kind: KindProject},
+		"ZFS":                    {Kind: KindProject},
+		"Solaris":                {Kind: KindProject},
+		"Linux":                  {Kind: KindProject},
+		"OpenBMC":                {Kind: KindProject},
+	}
+}
+
+// LoadKnown reads additional known entities from entities.json in the data directory over the defaults, the file
+// is optional
+func LoadKnown(dataDirectory string) (Known, error) {
+	known := DefaultKnown()
+	knownBytes, err := os.ReadFile(filepath.Join(dataDirectory, knownName))
+	if errors.Is(err, os.ErrNotExist) {
+		return known, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known entities: %w", err)
+	}
+
+	var entries Known
+	err = json.Unmarshal(knownBytes, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse known entities: %w", err)
+	}
+	for name, entity := range entries {
+		if !slices.Contains(kinds, entity.Kind) {
+			return nil, fmt.Errorf("known entity %s has unknown kind %q, expected one of %s", name, entity.Kind, strings.Join(kinds, ", "))
+		}
+		known[name] = entity
+	}
+	return known, nil
+}
+
+// Rules finds known entities by matching their names and aliases as whole words. Names are matched case
+// sensitively, so "Sun" the company isn't confused with the sun
+type Rules struct {
+	patterns []namePattern
+}
+
+type namePattern struct {
+	entity  Entity
+	pattern *regexp.Regexp
+}
+
+func NewRules(known Known) *Rules {
+	rules := &Rules{}
+	for name, entity := range known {
+		names := append([]string{name}, entity.Aliases...)
+		for i := range names {
+			names[i] = regexp.QuoteMeta(names[i])
+		}
+		rules.patterns = append(rules.patterns, namePattern{
+			entity:  Entity{Name: name, Kind: entity.Kind},
+			pattern: regexp.MustCompile(`(^|[^\p{L}\p{N}])(` + strings.Join(names, "|") + `)($|[^\p{L}\p{N}])`),
+		})
+	}
+	// Known is a map, so sort the patterns to extract entities in the same order every time
+	slices.SortFunc(rules.patterns, func(a, b namePattern) int { return strings.Compare(a.entity.Name, b.entity.Name) })
+	return rules
+}
+
+func (r *Rules) Extract(_ context.Context, content string) ([]Entity, error) {
+	var found []Entity
+	for _, p := range r.patterns {
+		if p.pattern.MatchString(content) {
+			found = append(found, p.entity)
+		}
+	}
+	return found, nil
+}
@@ -0,0 +1,166 @@
+package expand
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/sashabaranov/go-openai"
+
+	"oxide-search/search"
+)
+
+const (
+	subQueryPrompt = "You help search transcripts of Oxide and Friends, a podcast about computer hardware, systems software and the computer industry. " +
+		"Given a users question, rewrite it into %d to %d short, distinct search queries that each cover part of what they're asking, spelling out anything vague or abbreviated. " +
+		`Respond with a JSON object of the form {"queries": ["...", "..."]}.`
+	hydePrompt = "You help search transcripts of Oxide and Friends, a podcast about computer hardware, systems software and the computer industry. " +
+		"Given a users question, write a short passage of a few sentences in which the hosts answer it, in the conversational style of a podcast transcript. " +
+		"It doesn't matter if the details are wrong, only that it sounds like the discussion that would answer the question. Respond with just the passage."
+)
+
+// Kinds of Variant
+const (
+	KindOriginal = "original"
+	KindSubQuery = "subquery"
+	KindHyDE     = "hyde"
+	KindGlossary = "glossary"
+)
+
+// Config turns on each way of transforming a query, searching with several variants of a short query catches
+// results that its own embedding alone misses
+type Config struct {
+	// SubQueries has a chat model rewrite the query into several more specific search queries
+	SubQueries bool
+	// HyDE has a chat model write a hypothetical answer to the query, and searches with that instead of the
+	// question, as answers embed closer to the transcripts that contain them than questions do
+	HyDE bool
+	// Glossary spells out any acronyms or jargon in the query from the glossary
+	Glossary bool
+}
+
+// Enabled is true if any of the transformations are turned on
+func (c Config) Enabled() bool {
+	return c.SubQueries || c.HyDE || c.Glossary
+}
+
+// Variant is one version of a query to search with
+type Variant struct {
+	Kind string
+	Text string
+}
+
+// Expander transforms queries into variants
+type Expander struct {
+	Client    *openai.Client
+	ChatModel string
+	Glossary  Glossary
+}
+
+// NewExpander creates an expander with the glossary from the data directory
+func NewExpander(client *openai.Client, dataDirectory string) (*Expander, error) {
+	glossary, err := LoadGlossary(dataDirectory)
+	if err != nil {
+		return nil, err
+	}
+	return &Expander{Client: client, ChatModel: openai.GPT3Dot5Turbo, Glossary: glossary}, nil
+}
+
+// Expand returns the original query followed by a variant from each transformation turned on in the config
+func (e *Expander) Expand(ctx context.Context, query string, config Config) ([]Variant, error) {
+	variants := []Variant{{Kind: KindOriginal, Text: query}}
+	if config.Glossary {
+		if expanded, ok := e.Glossary.Expand(query); ok {
+			variants = append(variants, Variant{Kind: KindGlossary, Text: expanded})
+		}
+	}
+	if config.SubQueries {
+		subQueries, err := e.subQueries(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for _, subQuery := range subQueries {
+			variants = append(variants, Variant{Kind: KindSubQuery, Text: subQuery})
+		}
+	}
+	if config.HyDE {
+		answer, err := e.hypotheticalAnswer(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		variants = append(variants, Variant{Kind: KindHyDE, Text: answer})
+	}
+	return variants, nil
+}
+
+func (e *Expander) subQueries(ctx context.Context, query string) ([]string, error) {
+	response, err := e.Client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: e.ChatModel,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: fmt.Sprintf(subQueryPrompt, 2, 4),
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: query,
+			},
+		},
+		ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
+		Temperature:    0.4,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate sub-queries: %w", err)
+	}
+
+	var generated struct {
+		Queries []string `json:"queries"`
+	}
+	err = json.Unmarshal([]byte(response.Choices[0].Message.Content), &generated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated sub-queries: %w", err)
+	}
+	return generated.Queries, nil
+}
+
+func (e *Expander) hypotheticalAnswer(ctx context.Context, query string) (string, error) {
+	response, err := e.Client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: e.ChatModel,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: hydePrompt,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: query,
+			},
+		},
+		MaxTokens:   200,
+		Temperature: 0.7,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to generate hypothetical answer: %w", err)
+	}
+	return response.Choices[0].Message.Content, nil
+}
+
+// Search runs a search for each variant with the vector embedded from its text, and fuses the results by
+// reciprocal rank. A single variant is searched as is
+func Search(ctx context.Context, variants []Variant, vectors [][]float32, size int, run func(ctx context.Context, text string, vector []float32) ([]search.SearchHit, error)) ([]search.SearchHit, error) {
+	if len(variants) != len(vectors) {
+		return nil, fmt.Errorf("%d query variants were embedded as %d vectors", len(variants), len(vectors))
+	}
+	rankings := make([][]search.SearchHit, len(variants))
+	for i := range variants {
+		hits, err := run(ctx, variants[i].Text, vectors[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to search for %s query %q: %w", variants[i].Kind, variants[i].Text, err)
+		}
+		if len(variants) == 1 {
+			return hits, nil
+		}
+		rankings[i] = hits
+	}
+	return search.FuseRankings(rankings, size, search.DefaultHybridConfig().RankConstant), nil
+}
@@ -0,0 +1,84 @@
+package expand
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const glossaryName = "glossary.json"
+
+// Glossary maps acronyms and jargon to what they stand for, keyed by the term in lower case
+type Glossary map[string]string
+
+// DefaultGlossary is the jargon that comes up most on the podcast, which the embeddings of a short query often
+// don't connect to the spelled out version used elsewhere in the transcripts
+func DefaultGlossary() Glossary {
+	return Glossary{
+		"bmc":    "baseboard management controller",
+		"sp":     "service processor",
+		"psc":    "power shelf controller",
+		"cpld":   "complex programmable logic device",
+		"fpga":   "field programmable gate array",
+		"asic":   "application specific integrated circuit",
+		"nic":    "network interface card",
+		"pcie":   "PCI express",
+		"nvme":   "non-volatile memory express",
+		"ssd":    "solid state drive",
+		"dram":   "dynamic random access memory",
+		"ecc":    "error correcting code memory",
+		"uefi":   "unified extensible firmware interface",
+		"acpi":   "advanced configuration and power interface",
+		"smm":    "system management mode",
+		"tpm":    "trusted platform module",
+		"ocp":    "open compute project",
+		"rfd":    "request for discussion",
+		"vm":     "virtual machine",
+		"zfs":    "ZFS file system",
+		"dtrace": "DTrace dynamic tracing",
+	}
+}
+
+// LoadGlossary reads additional glossary entries from glossary.json in the data directory over the defaults, the
+// file is optional
+func LoadGlossary(dataDirectory string) (Glossary, error) {
+	glossary := DefaultGlossary()
+	glossaryBytes, err := os.ReadFile(filepath.Join(dataDirectory, glossaryName))
+	if errors.Is(err, os.ErrNotExist) {
+		return glossary, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read glossary: %w", err)
+	}
+
+	var entries map[string]string
+	err = json.Unmarshal(glossaryBytes, &entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse glossary: %w", err)
+	}
+	for term, expansion := range entries {
+		glossary[strings.ToLower(term)] = expansion
+	}
+	return glossary, nil
+}
+
+var glossaryWord = regexp.MustCompile(`[\p{L}\p{N}]+`)
+
+// Expand spells out any glossary terms in the text after the term, like "BMC (baseboard management controller)",
+// and reports whether there were any
+func (g Glossary) Expand(text string) (string, bool) {
+	expanded := false
+	text = glossaryWord.ReplaceAllStringFunc(text, func(word string) string {
+		expansion, ok := g[strings.ToLower(word)]
+		if !ok {
+			return word
+		}
+		expanded = true
+		return fmt.Sprintf("%s (%s)", word, expansion)
+	})
+	return text, expanded
+}
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
+	"time"
 )
 
 const (
@@ -21,6 +23,55 @@ type EpisodeData struct {
 	GUID        string
 	Published   string
 	Transcript  string
+	// Feed is the title of the podcast feed the episode came from, and Speakers the people it lists for the episode
+	Feed     string
+	Speakers []string `json:",omitempty"`
+	// Timestamps are when words of the transcript were said, only episodes transcribed since they were recorded have
+	// them
+	Timestamps []Timestamp `json:",omitempty"`
+	// Links are the links in the show notes of the episode
+	Links []Link `json:",omitempty"`
+}
+
+// Link is a link from the show notes, Text is its anchor text and Domain the host it points to without any www
+type Link struct {
+	URL    string
+	Text   string
+	Domain string
+}
+
+// Timestamp is how far into the recording, in seconds, a word of the transcript was said. Whisper times segments
+// of a transcription rather than words, so there's one for the first word of each segment
+type Timestamp struct {
+	Word    int
+	Seconds float64
+}
+
+// Playback is how far into the recording a word of the transcript was said, as of the start of its segment
+func (e EpisodeData) Playback(word int) (float64, bool) {
+	i := sort.Search(len(e.Timestamps), func(i int) bool { return e.Timestamps[i].Word > word })
+	if i == 0 {
+		return 0, false
+	}
+	return e.Timestamps[i-1].Seconds, true
+}
+
+// TimestampsBetween are the timestamps for the words from start up to end, including the one in effect at start
+func (e EpisodeData) TimestampsBetween(start int, end int) []Timestamp {
+	from := sort.Search(len(e.Timestamps), func(i int) bool { return e.Timestamps[i].Word > start })
+	until := sort.Search(len(e.Timestamps), func(i int) bool { return e.Timestamps[i].Word >= end })
+	return e.Timestamps[max(0, from-1):until]
+}
+
+// PublishedTime parses the publication date from the feed, which should be an RFC 1123 date
+func (e EpisodeData) PublishedTime() (time.Time, error) {
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123} {
+		published, err := time.Parse(layout, e.Published)
+		if err == nil {
+			return published, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("unrecognized publication date %q for episode %s", e.Published, e.GUID)
 }
 
 type Downloads struct {
@@ -1,6 +1,8 @@
 package meta
 
 import (
+	"fmt"
+
 	"github.com/sashabaranov/go-openai"
 
 	"oxide-search/search"
@@ -14,9 +16,9 @@ func GetPrompt() string {
 	return defaultBasePrompt
 }
 
-// CreateConversation combines the base prompt, embedding context, and the users query, to create a
+// CreateConversation combines the base prompt, passages of transcript for context, and the users query, to create a
 // chat completion request, which can then be used to generate a longer form response
-func CreateConversation(basePrompt string, embeddingContext []search.Document, userQuery string) []openai.ChatCompletionMessage {
+func CreateConversation(basePrompt string, passages []search.Passage, userQuery string) []openai.ChatCompletionMessage {
 	contextMessages := []openai.ChatCompletionMessage{
 		{
 			Role:    openai.ChatMessageRoleSystem,
@@ -24,10 +26,10 @@ func CreateConversation(basePrompt string, embeddingContext []search.Document, u
 		},
 	}
 
-	for _, snippet := range embeddingContext {
+	for _, passage := range passages {
 		contextMessages = append(contextMessages, openai.ChatCompletionMessage{
 			Role:    openai.ChatMessageRoleSystem,
-			Content: snippet.EpisodeData.Transcript,
+			Content: fmt.Sprintf("From the episode %q:\n%s", passage.Title, passage.Text),
 		})
 	}
 
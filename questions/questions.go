@@ -0,0 +1,101 @@
+package questions
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	questionPrompt = "You are helping build a search index over transcripts of Oxide and Friends, a podcast about computer hardware, systems software and the computer industry. " +
+		"Given a passage from a transcript, write %d distinct questions that a listener might ask which the passage answers. " +
+		"Write them the way someone would type them into a search box, and make them specific to what's discussed in the passage. " +
+		`Respond with a JSON object of the form {"questions": ["...", "..."]}.`
+)
+
+// Chunk holds the synthetic questions generated for one chunk of a transcript
+type Chunk struct {
+	VectorId int
+	// Content is the chunk text the questions were generated from, so they can be regenerated if the chunking changes
+	Content   string
+	Questions []string
+	// Vectors are the embeddings of each question, keyed by embedding model
+	Vectors map[string][][]float32 `json:",omitempty"`
+}
+
+// Episode holds the questions for every chunk of an episode
+type Episode struct {
+	GUID   string
+	Chunks []Chunk
+}
+
+func path(dataDirectory string, GUID string) string {
+	return filepath.Join(dataDirectory, fmt.Sprintf("%s.questions.json", GUID))
+}
+
+// Load reads the questions for an episode, returning nil if none have been generated
+func Load(dataDirectory string, GUID string) (*Episode, error) {
+	questionBytes, err := os.ReadFile(path(dataDirectory, GUID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read questions for episode %s: %w", GUID, err)
+	}
+
+	var episode Episode
+	err = json.Unmarshal(questionBytes, &episode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse questions for episode %s: %w", GUID, err)
+	}
+	return &episode, nil
+}
+
+func Save(dataDirectory string, episode *Episode) error {
+	questionBytes, err := json.MarshalIndent(episode, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize questions for episode %s: %w", episode.GUID, err)
+	}
+	err = os.WriteFile(path(dataDirectory, episode.GUID), questionBytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write questions for episode %s: %w", episode.GUID, err)
+	}
+	return nil
+}
+
+// Generate asks a chat model for questions that a chunk of transcript answers
+func Generate(ctx context.Context, client *openai.Client, chatModel string, content string, count int) ([]string, error) {
+	response, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: chatModel,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: fmt.Sprintf(questionPrompt, count),
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: content,
+			},
+		},
+		ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
+		Temperature:    0.4,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate questions: %w", err)
+	}
+
+	var generated struct {
+		Questions []string `json:"questions"`
+	}
+	err = json.Unmarshal([]byte(response.Choices[0].Message.Content), &generated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated questions: %w", err)
+	}
+
+	return generated.Questions, nil
+}
@@ -0,0 +1,64 @@
+package rerank
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+
+	"oxide-search/search"
+)
+
+const (
+	rerankPrompt = "You judge search results over transcripts of Oxide and Friends, a podcast about computer hardware, systems software and the computer industry. " +
+		"Given a query and a numbered list of passages, score how well each passage answers the query from 0, unrelated, to 10, answers it directly. " +
+		`Respond with a JSON object of the form {"scores": [7, 0, ...]}, with one score for each passage in the order given.`
+)
+
+// Chat scores documents by asking a chat model to grade each one against the query, which needs no extra service
+// but is slower and costs more than a cross encoder
+type Chat struct {
+	Client *openai.Client
+	Model  string
+}
+
+func (c *Chat) Score(ctx context.Context, query string, documents []search.Document) ([]float64, error) {
+	var request strings.Builder
+	fmt.Fprintf(&request, "Query: %s\n\n", query)
+	for i := range documents {
+		fmt.Fprintf(&request, "Passage %d:\n%s\n\n", i+1, text(documents[i]))
+	}
+
+	response, err := c.Client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: c.Model,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: rerankPrompt,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: request.String(),
+			},
+		},
+		ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
+		Temperature:    0,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate rerank scores: %w", err)
+	}
+
+	var generated struct {
+		Scores []float64 `json:"scores"`
+	}
+	err = json.Unmarshal([]byte(response.Choices[0].Message.Content), &generated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated rerank scores: %w", err)
+	}
+	if len(generated.Scores) != len(documents) {
+		return nil, fmt.Errorf("chat model scored %d of %d passages", len(generated.Scores), len(documents))
+	}
+	return generated.Scores, nil
+}
@@ -0,0 +1,83 @@
+package rerank
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"oxide-search/search"
+)
+
+// CrossEncoder scores documents with a cross encoder model behind the /rerank API of Hugging Face's text embeddings
+// inference, which reads the query and document together rather than comparing separate embeddings of them
+type CrossEncoder struct {
+	// URL is the base url of the service, like http://localhost:8081
+	URL    string
+	Client *http.Client
+}
+
+type crossEncoderRequest struct {
+	Query    string   `json:"query"`
+	Texts    []string `json:"texts"`
+	Truncate bool     `json:"truncate"`
+}
+
+type crossEncoderScore struct {
+	Index int     `json:"index"`
+	Score float64 `json:"score"`
+}
+
+func (c *CrossEncoder) Score(ctx context.Context, query string, documents []search.Document) ([]float64, error) {
+	texts := make([]string, len(documents))
+	for i := range documents {
+		texts[i] = text(documents[i])
+	}
+	requestBytes, err := json.Marshal(crossEncoderRequest{Query: query, Texts: texts, Truncate: true})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal rerank request: %w", err)
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(c.URL, "/")+"/rerank", bytes.NewReader(requestBytes))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create rerank request: %w", err)
+	}
+	request.Header.Set("Content-Type", "application/json")
+	response, err := c.Client.Do(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send rerank request: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(response.Body)
+		return nil, fmt.Errorf("unexpected response to rerank request: %s %s", response.Status, body)
+	}
+
+	var ranked []crossEncoderScore
+	err = json.NewDecoder(response.Body).Decode(&ranked)
+	if err != nil {
+		return nil, fmt.Errorf("failed to deserialize rerank response: %w", err)
+	}
+
+	// The service returns scores sorted by relevance, put them back in the order of the documents. Every document
+	// has to be scored exactly once, one left out would otherwise silently rank last
+	if len(ranked) != len(documents) {
+		return nil, fmt.Errorf("rerank response has %d scores for %d documents", len(ranked), len(documents))
+	}
+	scores := make([]float64, len(documents))
+	scored := make([]bool, len(documents))
+	for _, r := range ranked {
+		if r.Index < 0 || r.Index >= len(scores) {
+			return nil, fmt.Errorf("rerank response has a score for document %d of %d", r.Index, len(scores))
+		}
+		if scored[r.Index] {
+			return nil, fmt.Errorf("rerank response has more than one score for document %d", r.Index)
+		}
+		scores[r.Index] = r.Score
+		scored[r.Index] = true
+	}
+	return scores, nil
+}
@@ -0,0 +1,115 @@
+package rerank
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+
+	"oxide-search/search"
+)
+
+// Kinds of Reranker that Open can create
+const (
+	KindNone         = "none"
+	KindCrossEncoder = "cross-encoder"
+	KindChat         = "chat"
+)
+
+// DefaultCandidates is how many of the top search results are reranked
+const DefaultCandidates = 20
+
+// crossEncoderTimeout bounds a request to the cross encoder, so a hung service fails the query rather than
+// blocking it
+const crossEncoderTimeout = 30 * time.Second
+
+// Reranker scores search results by how relevant they are to the query, with a more expensive model than the
+// embeddings they were retrieved by. Scores are only comparable within one call
+type Reranker interface {
+	// Score returns a score for each document, in the same order, higher is more relevant
+	Score(ctx context.Context, query string, documents []search.Document) ([]float64, error)
+}
+
+// Result is a reranked search hit, the hits Rank is still the position it was retrieved at before reranking
+type Result struct {
+	search.SearchHit
+	RerankScore float64
+}
+
+// Open creates the kind of reranker, url is only used by the cross encoder
+func Open(kind string, url string, client *openai.Client) (Reranker, error) {
+	switch kind {
+	case KindNone, "":
+		return None{}, nil
+	case KindCrossEncoder:
+		if url == "" {
+			return nil, fmt.Errorf("the cross encoder reranker needs the url of a rerank service")
+		}
+		return &CrossEncoder{URL: url, Client: &http.Client{Timeout: crossEncoderTimeout}}, nil
+	case KindChat:
+		return &Chat{Client: client, Model: openai.GPT4TurboPreview}, nil
+	default:
+		return nil, fmt.Errorf("unknown reranker %q, expected %s, %s or %s", kind, KindNone, KindCrossEncoder, KindChat)
+	}
+}
+
+// Rerank scores the first candidates hits and returns the best size of them by their rerank score. Hits past the
+// candidates are dropped, so candidates should be at least size
+func Rerank(ctx context.Context, reranker Reranker, query string, hits []search.SearchHit, candidates int, size int) ([]Result, error) {
+	hits = hits[:min(len(hits), candidates)]
+	if len(hits) == 0 {
+		return nil, nil
+	}
+	documents := search.Documents(hits)
+	scores, err := reranker.Score(ctx, query, documents)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rerank search results: %w", err)
+	}
+	if len(scores) != len(documents) {
+		return nil, fmt.Errorf("reranker returned %d scores for %d search results", len(scores), len(documents))
+	}
+
+	results := make([]Result, len(documents))
+	for i := range documents {
+		results[i] = Result{SearchHit: hits[i], RerankScore: scores[i]}
+	}
+	// Stable so ties, and every result from the no-op reranker, keep their retrieval order
+	slices.SortStableFunc(results, func(a, b Result) int {
+		switch {
+		case a.RerankScore > b.RerankScore:
+			return -1
+		case a.RerankScore < b.RerankScore:
+			return 1
+		}
+		return 0
+	})
+	return results[:min(len(results), size)], nil
+}
+
+// Hits strips the rerank scores off of results
+func Hits(results []Result) []search.SearchHit {
+	hits := make([]search.SearchHit, len(results))
+	for i := range results {
+		hits[i] = results[i].SearchHit
+	}
+	return hits
+}
+
+// None keeps the retrieval order, scoring every document the same
+type None struct{}
+
+func (None) Score(_ context.Context, _ string, documents []search.Document) ([]float64, error) {
+	return make([]float64, len(documents)), nil
+}
+
+// text is the part of a document a reranker compares to the query
+func text(document search.Document) string {
+	switch document.DocType {
+	case search.DocTypeSummary, search.DocTypeChapter:
+		return document.Summary
+	}
+	return document.Transcript
+}
@@ -0,0 +1,120 @@
+package search
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// bm25Index is a minimal in memory inverted index over transcript text, scored with BM25 the same way opensearch
+// scores a match query. It lets the local store run hybrid searches without opensearch
+type bm25Index struct {
+	// postings maps each term to the documents it appears in and how many times
+	postings  map[string]map[int]int
+	lengths   map[int]int
+	avgLength float64
+}
+
+// BM25 parameters, these are the opensearch defaults
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// tokenize lower cases text and splits it into words, without the stemming or stop words of the english analyzer
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func newBM25Index(documents []Document) *bm25Index {
+	index := &bm25Index{postings: make(map[string]map[int]int), lengths: make(map[int]int)}
+	var total int
+	for i, doc := range documents {
+		if doc.Transcript == "" {
+			continue
+		}
+		terms := tokenize(doc.Transcript)
+		index.lengths[i] = len(terms)
+		total += len(terms)
+		for _, term := range terms {
+			if index.postings[term] == nil {
+				index.postings[term] = make(map[int]int)
+			}
+			index.postings[term][i]++
+		}
+	}
+	if len(index.lengths) > 0 {
+		index.avgLength = float64(total) / float64(len(index.lengths))
+	}
+	return index
+}
+
+// search scores every document containing any of the query terms
+func (b *bm25Index) search(text string) []scored {
+	scores := make(map[int]float64)
+	n := float64(len(b.lengths))
+	for _, term := range tokenize(text) {
+		postings := b.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+		idf := math.Log(1 + (n-float64(len(postings))+0.5)/(float64(len(postings))+0.5))
+		for doc, frequency := range postings {
+			tf := float64(frequency)
+			scores[doc] += idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*float64(b.lengths[doc])/b.avgLength))
+		}
+	}
+
+	candidates := make([]scored, 0, len(scores))
+	for doc, score := range scores {
+		candidates = append(candidates, scored{index: doc, score: score})
+	}
+	return candidates
+}
+
+// Highlighted fragments are around this many words, like the 100 characters of an opensearch highlight fragment
+const (
+	highlightWords     = 20
+	highlightFragments = 5
+)
+
+// highlights picks out the fragments of text containing any of the query terms, wrapping the matching words in
+// <em> tags the way opensearch highlights them
+func highlights(text string, queryText string) []string {
+	terms := make(map[string]bool)
+	for _, term := range tokenize(queryText) {
+		terms[term] = true
+	}
+
+	words := strings.Fields(text)
+	var fragments []string
+	for i := 0; i < len(words) && len(fragments) < highlightFragments; i++ {
+		if !matchesAny(words[i], terms) {
+			continue
+		}
+		start := max(0, i-highlightWords/2)
+		end := min(len(words), start+highlightWords)
+		fragment := make([]string, 0, end-start)
+		for _, word := range words[start:end] {
+			if matchesAny(word, terms) {
+				word = "<em>" + word + "</em>"
+			}
+			fragment = append(fragment, word)
+		}
+		fragments = append(fragments, strings.Join(fragment, " "))
+		// Carry on after the fragment, so fragments don't overlap
+		i = end - 1
+	}
+	return fragments
+}
+
+func matchesAny(word string, terms map[string]bool) bool {
+	for _, token := range tokenize(word) {
+		if terms[token] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,245 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// BulkConfig controls how documents are batched up and written by a BulkIndexer
+type BulkConfig struct {
+	// MaxBytes caps the size of a single bulk request body
+	MaxBytes int
+	// MaxRetries is how many times an item that failed with a retryable status is retried, with exponential backoff
+	// starting at RetryBackoff
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// WaitForRefresh makes each bulk request wait until its documents are visible to searches
+	WaitForRefresh bool
+	// OnRetry is called, when set, before retrying the items of a bulk request to an index that failed, to report
+	// progress
+	OnRetry func(index string, retrying int, items int)
+}
+
+func DefaultBulkConfig() BulkConfig {
+	return BulkConfig{
+		MaxBytes:     5 * 1024 * 1024,
+		MaxRetries:   3,
+		RetryBackoff: time.Second,
+	}
+}
+
+// BulkFailure is a document that could not be written
+type BulkFailure struct {
+	Id     string
+	Status int
+	Type   string
+	Reason string
+}
+
+// BulkError is returned once all documents have been written, if any of them permanently failed
+type BulkError struct {
+	Failures []BulkFailure
+}
+
+// Is matches ErrMappingMismatch if any of the documents didn't fit the mapping of the index
+func (e *BulkError) Is(target error) bool {
+	if target != ErrMappingMismatch {
+		return false
+	}
+	for _, f := range e.Failures {
+		if isMappingError(f.Type, f.Reason) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *BulkError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d documents failed to index:", len(e.Failures))
+	for _, f := range e.Failures {
+		fmt.Fprintf(&b, "\n  %s: %d %s: %s", f.Id, f.Status, f.Type, f.Reason)
+	}
+	return b.String()
+}
+
+// bulkAction is the action line preceding each document in a bulk request body
+type bulkAction struct {
+	Index  *bulkTarget `json:"index,omitempty"`
+	Delete *bulkTarget `json:"delete,omitempty"`
+}
+
+type bulkTarget struct {
+	Index string `json:"_index"`
+	Id    string `json:"_id"`
+}
+
+type bulkItem struct {
+	id    string
+	lines []byte
+}
+
+type bulkResponse struct {
+	Errors bool `json:"errors"`
+	Items  []map[string]struct {
+		Id     string `json:"_id"`
+		Status int    `json:"status"`
+		Error  *struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	} `json:"items"`
+}
+
+// BulkIndexer writes documents to an index in size capped bulk requests. The bulk API reports failures per item
+// while still returning a 200, so every item is checked, retryable failures are retried, and permanent failures are
+// collected and returned from Close
+type BulkIndexer struct {
+	client   *opensearch.Client
+	index    string
+	config   BulkConfig
+	pending  []bulkItem
+	size     int
+	written  int
+	failures []BulkFailure
+}
+
+func NewBulkIndexer(client *opensearch.Client, index string, config BulkConfig) *BulkIndexer {
+	if config.MaxBytes <= 0 {
+		config.MaxBytes = DefaultBulkConfig().MaxBytes
+	}
+	if config.RetryBackoff <= 0 {
+		config.RetryBackoff = time.Second
+	}
+	return &BulkIndexer{client: client, index: index, config: config}
+}
+
+// Add queues a document to be indexed, flushing the queue first if the document would push it over MaxBytes
+func (b *BulkIndexer) Add(ctx context.Context, doc Document) error {
+	source, err := json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("failed to build search document %s: %w", doc.Id, err)
+	}
+	return b.add(ctx, doc.Id, bulkAction{Index: &bulkTarget{Index: b.index, Id: doc.Id}}, source)
+}
+
+// Delete queues a document to be deleted, documents that are already missing aren't treated as a failure
+func (b *BulkIndexer) Delete(ctx context.Context, id string) error {
+	return b.add(ctx, id, bulkAction{Delete: &bulkTarget{Index: b.index, Id: id}}, nil)
+}
+
+func (b *BulkIndexer) add(ctx context.Context, id string, action bulkAction, source []byte) error {
+	actionLine, err := json.Marshal(action)
+	if err != nil {
+		return fmt.Errorf("failed to build bulk action for document %s: %w", id, err)
+	}
+	lines := append(actionLine, '\n')
+	if source != nil {
+		lines = append(append(lines, source...), '\n')
+	}
+
+	if len(b.pending) > 0 && b.size+len(lines) > b.config.MaxBytes {
+		if err := b.Flush(ctx); err != nil {
+			return err
+		}
+	}
+	b.pending = append(b.pending, bulkItem{id: id, lines: lines})
+	b.size += len(lines)
+	return nil
+}
+
+// Written is the number of documents successfully written so far
+func (b *BulkIndexer) Written() int {
+	return b.written
+}
+
+func retryableStatus(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusBadGateway || status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout
+}
+
+// Flush writes everything queued so far, retrying items which failed with a retryable status
+func (b *BulkIndexer) Flush(ctx context.Context) error {
+	items := b.pending
+	b.pending = nil
+	b.size = 0
+
+	backoff := b.config.RetryBackoff
+	for attempt := 0; len(items) > 0; attempt++ {
+		if attempt > 0 {
+			timer := time.NewTimer(backoff)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return ctx.Err()
+			case <-timer.C:
+			}
+			backoff *= 2
+		}
+
+		var body bytes.Buffer
+		for _, item := range items {
+			body.Write(item.lines)
+		}
+		request := opensearchapi.BulkRequest{
+			Index: b.index,
+			Body:  bytes.NewReader(body.Bytes()),
+		}
+		if b.config.WaitForRefresh {
+			request.Refresh = "wait_for"
+		}
+
+		// The whole request is retried when the cluster is overloaded, otherwise individual items are retried below
+		var response bulkResponse
+		err := do(ctx, b.client, request, &response)
+		var responseErr *ResponseError
+		if errors.As(err, &responseErr) && retryableStatus(responseErr.Status) && attempt < b.config.MaxRetries {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to send bulk request to %s: %w", b.index, err)
+		}
+		if len(response.Items) != len(items) {
+			return fmt.Errorf("bulk request to %s had %d items but the response had %d", b.index, len(items), len(response.Items))
+		}
+
+		var retry []bulkItem
+		for i, result := range response.Items {
+			for action, r := range result {
+				switch {
+				case r.Error == nil || (action == "delete" && r.Status == http.StatusNotFound):
+					b.written++
+				case retryableStatus(r.Status) && attempt < b.config.MaxRetries:
+					retry = append(retry, items[i])
+				default:
+					b.failures = append(b.failures, BulkFailure{Id: items[i].id, Status: r.Status, Type: r.Error.Type, Reason: r.Error.Reason})
+				}
+			}
+		}
+		if len(retry) > 0 && b.config.OnRetry != nil {
+			b.config.OnRetry(b.index, len(retry), len(items))
+		}
+		items = retry
+	}
+
+	return nil
+}
+
+// Close flushes anything still queued, and returns a *BulkError if any documents permanently failed
+func (b *BulkIndexer) Close(ctx context.Context) error {
+	if err := b.Flush(ctx); err != nil {
+		return err
+	}
+	if len(b.failures) > 0 {
+		return &BulkError{Failures: b.failures}
+	}
+	return nil
+}
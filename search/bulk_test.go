@@ -0,0 +1,243 @@
+package search
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+// bulkItemResult is how the fake cluster answers for an item, on a given attempt at writing it
+type bulkItemResult func(id string, attempt int) (status int, errorType string)
+
+// fakeBulkCluster answers bulk requests item by item, and records the ids in each request it was sent
+type fakeBulkCluster struct {
+	t        *testing.T
+	result   bulkItemResult
+	lock     sync.Mutex
+	attempts map[string]int
+	requests [][]string
+	// dropItem leaves the last item out of every response, to break the response
+	dropItem bool
+}
+
+func (c *fakeBulkCluster) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if !strings.HasSuffix(r.URL.Path, "/_bulk") {
+		// The client checks what it's connected to before its first request
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"version": {"number": "2.11.0", "distribution": "opensearch"}}`))
+		return
+	}
+
+	var ids []string
+	var items []map[string]any
+	lines := bufio.NewScanner(r.Body)
+	for lines.Scan() {
+		var action map[string]bulkTarget
+		if err := json.Unmarshal(lines.Bytes(), &action); err != nil {
+			c.t.Errorf("bad bulk action line %q: %v", lines.Text(), err)
+			return
+		}
+		for kind, target := range action {
+			if kind == "index" {
+				// Skip over the document
+				lines.Scan()
+			}
+			status, errorType := c.result(target.Id, c.attempts[target.Id])
+			c.attempts[target.Id]++
+			ids = append(ids, target.Id)
+			item := map[string]any{"_id": target.Id, "status": status}
+			if errorType != "" {
+				item["error"] = map[string]string{"type": errorType, "reason": "failed"}
+			}
+			items = append(items, map[string]any{kind: item})
+		}
+	}
+	c.requests = append(c.requests, ids)
+	if c.dropItem {
+		items = items[:len(items)-1]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{"errors": true, "items": items})
+}
+
+func newFakeBulkCluster(t *testing.T, result bulkItemResult) (*fakeBulkCluster, *opensearch.Client) {
+	cluster := &fakeBulkCluster{t: t, result: result, attempts: make(map[string]int)}
+	server := httptest.NewServer(cluster)
+	t.Cleanup(server.Close)
+	client, err := opensearch.NewClient(opensearch.Config{Addresses: []string{server.URL}})
+	if err != nil {
+		t.Fatalf("failed to create client: %v", err)
+	}
+	return cluster, client
+}
+
+func succeed(string, int) (int, string) {
+	return http.StatusCreated, ""
+}
+
+func TestBulkIndexer(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   bulkItemResult
+		deletes  []string
+		written  int
+		failures []BulkFailure
+		// requests are the ids sent in each bulk request
+		requests [][]string
+	}{
+		{
+			name:     "every item written",
+			result:   succeed,
+			written:  3,
+			requests: [][]string{{"a", "b", "c"}},
+		},
+		{
+			name: "retryable failures are retried alone",
+			result: func(id string, attempt int) (int, string) {
+				if id == "b" && attempt == 0 {
+					return http.StatusTooManyRequests, "es_rejected_execution_exception"
+				}
+				return http.StatusCreated, ""
+			},
+			written:  3,
+			requests: [][]string{{"a", "b", "c"}, {"b"}},
+		},
+		{
+			name: "permanent failures are collected",
+			result: func(id string, attempt int) (int, string) {
+				if id == "c" {
+					return http.StatusBadRequest, "mapper_parsing_exception"
+				}
+				return http.StatusCreated, ""
+			},
+			written:  2,
+			failures: []BulkFailure{{Id: "c", Status: http.StatusBadRequest, Type: "mapper_parsing_exception", Reason: "failed"}},
+			requests: [][]string{{"a", "b", "c"}},
+		},
+		{
+			name: "retries run out",
+			result: func(id string, attempt int) (int, string) {
+				if id == "a" {
+					return http.StatusServiceUnavailable, "unavailable_shards_exception"
+				}
+				return http.StatusCreated, ""
+			},
+			written:  2,
+			failures: []BulkFailure{{Id: "a", Status: http.StatusServiceUnavailable, Type: "unavailable_shards_exception", Reason: "failed"}},
+			requests: [][]string{{"a", "b", "c"}, {"a"}, {"a"}},
+		},
+		{
+			name: "deleting a missing document is not a failure",
+			result: func(id string, attempt int) (int, string) {
+				if id == "gone" {
+					return http.StatusNotFound, "not_found"
+				}
+				return http.StatusCreated, ""
+			},
+			deletes:  []string{"gone"},
+			written:  4,
+			requests: [][]string{{"a", "b", "c", "gone"}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster, client := newFakeBulkCluster(t, test.result)
+			indexer := NewBulkIndexer(client, "oxide", BulkConfig{MaxRetries: 2, RetryBackoff: time.Millisecond})
+			for _, id := range []string{"a", "b", "c"} {
+				if err := indexer.Add(context.Background(), Document{Id: id}); err != nil {
+					t.Fatalf("Add: %v", err)
+				}
+			}
+			for _, id := range test.deletes {
+				if err := indexer.Delete(context.Background(), id); err != nil {
+					t.Fatalf("Delete: %v", err)
+				}
+			}
+
+			err := indexer.Close(context.Background())
+			var bulkErr *BulkError
+			switch {
+			case test.failures == nil && err != nil:
+				t.Errorf("Close: %v", err)
+			case test.failures != nil && !errors.As(err, &bulkErr):
+				t.Errorf("Close returned %v, expected a BulkError", err)
+			case test.failures != nil && !reflect.DeepEqual(bulkErr.Failures, test.failures):
+				t.Errorf("failures %+v, expected %+v", bulkErr.Failures, test.failures)
+			}
+			if indexer.Written() != test.written {
+				t.Errorf("wrote %d documents, expected %d", indexer.Written(), test.written)
+			}
+			if !reflect.DeepEqual(cluster.requests, test.requests) {
+				t.Errorf("sent requests %v, expected %v", cluster.requests, test.requests)
+			}
+		})
+	}
+}
+
+func TestBulkIndexerMappingMismatch(t *testing.T) {
+	_, client := newFakeBulkCluster(t, func(string, int) (int, string) {
+		return http.StatusBadRequest, "mapper_parsing_exception"
+	})
+	indexer := NewBulkIndexer(client, "oxide", BulkConfig{})
+	if err := indexer.Add(context.Background(), Document{Id: "a"}); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if err := indexer.Close(context.Background()); !errors.Is(err, ErrMappingMismatch) {
+		t.Errorf("Close returned %v, expected ErrMappingMismatch", err)
+	}
+}
+
+func TestBulkIndexerMaxBytes(t *testing.T) {
+	cluster, client := newFakeBulkCluster(t, succeed)
+	// Small enough that every document needs a request of its own
+	indexer := NewBulkIndexer(client, "oxide", BulkConfig{MaxBytes: 1})
+	ids := []string{"a", "b", "c"}
+	for _, id := range ids {
+		if err := indexer.Add(context.Background(), Document{Id: id}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if err := indexer.Close(context.Background()); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var sent []string
+	for _, request := range cluster.requests {
+		if len(request) != 1 {
+			t.Errorf("request of %d items, expected 1", len(request))
+		}
+		sent = append(sent, request...)
+	}
+	sort.Strings(sent)
+	if !reflect.DeepEqual(sent, ids) {
+		t.Errorf("sent %v, expected %v", sent, ids)
+	}
+}
+
+func TestBulkIndexerResponseMismatch(t *testing.T) {
+	cluster, client := newFakeBulkCluster(t, succeed)
+	cluster.dropItem = true
+	indexer := NewBulkIndexer(client, "oxide", BulkConfig{})
+	for _, id := range []string{"a", "b"} {
+		if err := indexer.Add(context.Background(), Document{Id: id}); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if err := indexer.Close(context.Background()); err == nil {
+		t.Error("Close succeeded with an item missing from the response")
+	}
+}
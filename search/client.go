@@ -0,0 +1,25 @@
+package search
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+// NewClient connects to the local development cluster from compose.yml
+func NewClient() (*opensearch.Client, error) {
+	client, err := opensearch.NewClient(opensearch.Config{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		},
+		Addresses: []string{"https://localhost:9200"},
+		Username:  "admin", // For testing only. Don't store credentials in code.
+		Password:  "admin",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create opensearch client: %w", err)
+	}
+	return client, nil
+}
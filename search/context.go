@@ -0,0 +1,138 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+// DefaultContextRadius is how far either side of a search result, in words, neighboring chunks are pulled in from.
+// Chunks are windows of 500 words every 250 words, so this takes the windows either side of each result
+const DefaultContextRadius = 250
+
+// MaxContextRadius is the furthest context is pulled in from, as the chunks fetched grow with the radius
+const MaxContextRadius = 10 * DefaultContextRadius
+
+// Passage is a contiguous stretch of a transcript, merged from the overlapping chunks around one or more search
+// results
+type Passage struct {
+	GUID  string
+	Title string
+	Link  string
+	// Start and End are the positions of the first and one past the last word of the passage in the transcript
+	Start int
+	End   int
+	// ChunkIds are the chunks the passage was merged from, in transcript order
+	ChunkIds []string
+	// Sources are the ids of the search results the passage was expanded around
+	Sources []string
+	Text    string
+}
+
+func (p Passage) String() string {
+	return fmt.Sprintf("%s (words %d-%d of %s, chunks %s)", p.Title, p.Start, p.End, p.GUID, strings.Join(p.ChunkIds, ", "))
+}
+
+type offsetBounds struct {
+	Gte int `json:"gte"`
+	Lte int `json:"lte"`
+}
+
+// neighborhood is the range of chunk offsets pulled in around a search result
+func neighborhood(source Document, radius int) offsetBounds {
+	return offsetBounds{Gte: max(0, source.Offset-radius), Lte: source.Offset + radius}
+}
+
+// ExpandContext pulls in the chunks starting within radius words of each source chunk, and merges them into
+// passages. Overlapping or touching chunks from the same episode become a single passage, so the same words are
+// never repeated. Passages are grouped by episode, in order of each episodes best source, and in transcript order
+// within each episode
+func ExpandContext(ctx context.Context, client *opensearch.Client, index string, sources []Document, radius int) ([]Passage, error) {
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	var should []query
+	for _, source := range sources {
+		bounds := neighborhood(source, radius)
+		should = append(should, query{Bool: &boolSearch{Filter: []query{
+			{Terms: &termsSearch{GUID: []string{source.GUID}}},
+			{Range: &rangeSearch{Offset: &bounds}},
+		}}})
+	}
+	// Chunks start every 250 words, and a few windows were embedded twice, so allow for two chunks at each start
+	size := len(sources) * 2 * (2*radius/DefaultContextRadius + 1)
+	chunks, err := searchDocuments(ctx, client, index, size, query{Bool: &boolSearch{
+		Filter:  []query{{Bool: &boolSearch{Should: should}}},
+		MustNot: []query{notChunks},
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load chunks around search results: %w", err)
+	}
+
+	return mergePassages(sources, chunks), nil
+}
+
+// mergePassages merges the sources and the chunks around them into passages
+func mergePassages(sources []Document, chunks []Document) []Passage {
+	var episodes []string
+	byEpisode := make(map[string][]Document)
+	seen := make(map[string]bool)
+	add := func(doc Document) {
+		if seen[doc.Id] {
+			return
+		}
+		seen[doc.Id] = true
+		if _, ok := byEpisode[doc.GUID]; !ok {
+			episodes = append(episodes, doc.GUID)
+		}
+		byEpisode[doc.GUID] = append(byEpisode[doc.GUID], doc)
+	}
+	for _, source := range sources {
+		add(source)
+	}
+	for _, chunk := range chunks {
+		add(chunk)
+	}
+	isSource := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		isSource[source.Id] = true
+	}
+
+	var passages []Passage
+	for _, guid := range episodes {
+		episodeChunks := byEpisode[guid]
+		sort.SliceStable(episodeChunks, func(i, j int) bool { return episodeChunks[i].Offset < episodeChunks[j].Offset })
+
+		var current *Passage
+		var words []string
+		for _, chunk := range episodeChunks {
+			chunkWords := strings.Fields(chunk.Transcript)
+			chunkEnd := chunk.Offset + len(chunkWords)
+			if current == nil || chunk.Offset > current.End {
+				if current != nil {
+					current.Text = strings.Join(words, " ")
+					passages = append(passages, *current)
+				}
+				current = &Passage{GUID: guid, Title: chunk.Title, Link: chunk.Link, Start: chunk.Offset, End: chunk.Offset}
+				words = nil
+			}
+			if chunkEnd > current.End {
+				words = append(words, chunkWords[current.End-chunk.Offset:]...)
+				current.End = chunkEnd
+			}
+			current.ChunkIds = append(current.ChunkIds, chunk.Id)
+			if isSource[chunk.Id] {
+				current.Sources = append(current.Sources, chunk.Id)
+			}
+		}
+		if current != nil {
+			current.Text = strings.Join(words, " ")
+			passages = append(passages, *current)
+		}
+	}
+	return passages
+}
@@ -0,0 +1,103 @@
+package search
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"oxide-search/manifest"
+)
+
+// chunk is a document of words words starting at offset, each word naming its position in the transcript
+func chunk(guid string, offset int, words int) Document {
+	transcript := make([]string, words)
+	for i := range transcript {
+		transcript[i] = fmt.Sprintf("w%d", offset+i)
+	}
+	return Document{
+		Id:          fmt.Sprintf("%s-%d", guid, offset),
+		EpisodeData: manifest.EpisodeData{GUID: guid, Title: guid, Transcript: strings.Join(transcript, " ")},
+		Offset:      offset,
+	}
+}
+
+// span is the words of a transcript from start up to but not including end
+func span(start int, end int) string {
+	words := make([]string, end-start)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", start+i)
+	}
+	return strings.Join(words, " ")
+}
+
+func TestMergePassages(t *testing.T) {
+	tests := []struct {
+		name     string
+		sources  []Document
+		chunks   []Document
+		passages []Passage
+	}{
+		{
+			name: "no sources",
+		},
+		{
+			name:    "overlapping windows",
+			sources: []Document{chunk("a", 5, 10)},
+			chunks:  []Document{chunk("a", 0, 10), chunk("a", 10, 10)},
+			passages: []Passage{
+				{GUID: "a", Title: "a", Start: 0, End: 20, ChunkIds: []string{"a-0", "a-5", "a-10"}, Sources: []string{"a-5"}, Text: span(0, 20)},
+			},
+		},
+		{
+			name:    "adjacent windows",
+			sources: []Document{chunk("a", 0, 5)},
+			chunks:  []Document{chunk("a", 5, 5)},
+			passages: []Passage{
+				{GUID: "a", Title: "a", Start: 0, End: 10, ChunkIds: []string{"a-0", "a-5"}, Sources: []string{"a-0"}, Text: span(0, 10)},
+			},
+		},
+		{
+			name:    "separate windows",
+			sources: []Document{chunk("a", 0, 5), chunk("a", 20, 5)},
+			passages: []Passage{
+				{GUID: "a", Title: "a", Start: 0, End: 5, ChunkIds: []string{"a-0"}, Sources: []string{"a-0"}, Text: span(0, 5)},
+				{GUID: "a", Title: "a", Start: 20, End: 25, ChunkIds: []string{"a-20"}, Sources: []string{"a-20"}, Text: span(20, 25)},
+			},
+		},
+		{
+			name:    "window inside another",
+			sources: []Document{chunk("a", 2, 3)},
+			chunks:  []Document{chunk("a", 0, 10)},
+			passages: []Passage{
+				{GUID: "a", Title: "a", Start: 0, End: 10, ChunkIds: []string{"a-0", "a-2"}, Sources: []string{"a-2"}, Text: span(0, 10)},
+			},
+		},
+		{
+			name:    "duplicate hits",
+			sources: []Document{chunk("a", 0, 10), chunk("a", 0, 10)},
+			chunks:  []Document{chunk("a", 0, 10), chunk("a", 5, 10)},
+			passages: []Passage{
+				{GUID: "a", Title: "a", Start: 0, End: 15, ChunkIds: []string{"a-0", "a-5"}, Sources: []string{"a-0"}, Text: span(0, 15)},
+			},
+		},
+		{
+			name:    "episodes in order of their best source",
+			sources: []Document{chunk("b", 10, 5), chunk("a", 0, 5), chunk("b", 0, 5)},
+			chunks:  []Document{chunk("a", 5, 5)},
+			passages: []Passage{
+				{GUID: "b", Title: "b", Start: 0, End: 5, ChunkIds: []string{"b-0"}, Sources: []string{"b-0"}, Text: span(0, 5)},
+				{GUID: "b", Title: "b", Start: 10, End: 15, ChunkIds: []string{"b-10"}, Sources: []string{"b-10"}, Text: span(10, 15)},
+				{GUID: "a", Title: "a", Start: 0, End: 10, ChunkIds: []string{"a-0", "a-5"}, Sources: []string{"a-0"}, Text: span(0, 10)},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			passages := mergePassages(test.sources, test.chunks)
+			if !reflect.DeepEqual(passages, test.passages) {
+				t.Errorf("got passages\n%+v\nexpected\n%+v", passages, test.passages)
+			}
+		})
+	}
+}
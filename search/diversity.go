@@ -0,0 +1,113 @@
+package search
+
+import (
+	"fmt"
+	"math"
+)
+
+// DiversityConfig controls how search results are diversified. The chunks are overlapping windows, so a plain
+// search often returns several near identical chunks from the same stretch of one episode
+type DiversityConfig struct {
+	// Lambda trades off relevance to the query against difference from the results already picked, by maximal
+	// marginal relevance. 1 ignores diversity entirely, and 0 ignores relevance
+	Lambda float64
+	// MaxPerEpisode caps the results from any one episode, 0 doesn't cap them
+	MaxPerEpisode int
+	// Candidates is how many results to fetch and pick the diverse ones from
+	Candidates int
+}
+
+func DefaultDiversityConfig() DiversityConfig {
+	return DiversityConfig{
+		Lambda:        0.7,
+		MaxPerEpisode: 2,
+		Candidates:    30,
+	}
+}
+
+// WithDefaults fills in anything left unset from DefaultDiversityConfig, so a partially filled in config can be
+// taken from a request. A Lambda of 0 counts as unset, ignoring relevance entirely is never what's wanted, but a
+// MaxPerEpisode of 0 still means no cap
+func (c DiversityConfig) WithDefaults() DiversityConfig {
+	defaults := DefaultDiversityConfig()
+	if c.Lambda == 0 {
+		c.Lambda = defaults.Lambda
+	}
+	if c.Candidates <= 0 {
+		c.Candidates = defaults.Candidates
+	}
+	return c
+}
+
+// Validate checks lambda is in range
+func (c DiversityConfig) Validate() error {
+	if c.Lambda < 0 || c.Lambda > 1 {
+		return fmt.Errorf("diversity lambda must be between 0 and 1, got %f", c.Lambda)
+	}
+	if c.MaxPerEpisode < 0 {
+		return fmt.Errorf("max results per episode can't be negative")
+	}
+	return nil
+}
+
+// CandidateCount is how many results to fetch for a search of size results that will be diversified
+func (c DiversityConfig) CandidateCount(size int) int {
+	return max(size, c.Candidates)
+}
+
+// Diversify picks size results from the candidates by maximal marginal relevance, each pick being the candidate
+// most similar to the query vector once penalized by its similarity to the closest result already picked. It uses
+// the vectors of the candidates, so it works the same on results from any search or store
+func Diversify(queryVector []float32, candidates []SearchHit, size int, config DiversityConfig) []SearchHit {
+	relevance := make([]float64, len(candidates))
+	for i := range candidates {
+		relevance[i] = cosine(queryVector, candidates[i].Vectors)
+	}
+	// redundancy is the similarity of each candidate to the closest picked result so far
+	redundancy := make([]float64, len(candidates))
+	picked := make([]bool, len(candidates))
+	perEpisode := make(map[string]int)
+
+	var response []SearchHit
+	for len(response) < size {
+		best := -1
+		bestScore := math.Inf(-1)
+		for i := range candidates {
+			if picked[i] || (config.MaxPerEpisode > 0 && perEpisode[candidates[i].GUID] >= config.MaxPerEpisode) {
+				continue
+			}
+			score := config.Lambda*relevance[i] - (1-config.Lambda)*redundancy[i]
+			if score > bestScore {
+				best, bestScore = i, score
+			}
+		}
+		if best < 0 {
+			break
+		}
+
+		picked[best] = true
+		perEpisode[candidates[best].GUID]++
+		response = append(response, candidates[best])
+		for i := range candidates {
+			if !picked[i] {
+				redundancy[i] = max(redundancy[i], cosine(candidates[i].Vectors, candidates[best].Vectors))
+			}
+		}
+	}
+	return response
+}
+
+func cosine(a []float32, b []float32) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+	}
+	normA, normB := norm(a), norm(b)
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (normA * normB)
+}
@@ -0,0 +1,109 @@
+package search
+
+import (
+	"reflect"
+	"testing"
+
+	"oxide-search/manifest"
+)
+
+func diversityHit(id string, guid string, vector ...float32) SearchHit {
+	return SearchHit{Document: Document{Id: id, EpisodeData: manifest.EpisodeData{GUID: guid}, Vectors: vector}}
+}
+
+func TestDiversify(t *testing.T) {
+	query := []float32{1, 0}
+	// a2 is a near duplicate of a1, and b1 is less relevant but different from both
+	candidates := []SearchHit{
+		diversityHit("a1", "a", 1, 0.1),
+		diversityHit("a2", "a", 1, 0.12),
+		diversityHit("b1", "b", 1, -0.5),
+	}
+
+	tests := []struct {
+		name       string
+		candidates []SearchHit
+		size       int
+		config     DiversityConfig
+		ids        []string
+	}{
+		{
+			name:       "lambda of 1 ranks by relevance",
+			candidates: candidates,
+			size:       3,
+			config:     DiversityConfig{Lambda: 1},
+			ids:        []string{"a1", "a2", "b1"},
+		},
+		{
+			name:       "near duplicates are pushed down",
+			candidates: candidates,
+			size:       2,
+			config:     DiversityConfig{Lambda: 0.5},
+			ids:        []string{"a1", "b1"},
+		},
+		{
+			name:       "max per episode caps the results",
+			candidates: candidates,
+			size:       2,
+			config:     DiversityConfig{Lambda: 1, MaxPerEpisode: 1},
+			ids:        []string{"a1", "b1"},
+		},
+		{
+			name:       "max per episode runs out of candidates",
+			candidates: candidates,
+			size:       3,
+			config:     DiversityConfig{Lambda: 1, MaxPerEpisode: 1},
+			ids:        []string{"a1", "b1"},
+		},
+		{
+			name:       "fewer candidates than the size",
+			candidates: candidates,
+			size:       5,
+			config:     DiversityConfig{Lambda: 0.5},
+			ids:        []string{"a1", "b1", "a2"},
+		},
+		{
+			name:       "duplicate hits",
+			candidates: []SearchHit{diversityHit("a1", "a", 1, 0.1), diversityHit("a1", "a", 1, 0.1), diversityHit("b1", "b", 1, -0.5)},
+			size:       2,
+			config:     DiversityConfig{Lambda: 0.5},
+			ids:        []string{"a1", "b1"},
+		},
+		{
+			name:   "no candidates",
+			size:   3,
+			config: DiversityConfig{Lambda: 0.5},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ids []string
+			for _, hit := range Diversify(query, test.candidates, test.size, test.config) {
+				ids = append(ids, hit.Id)
+			}
+			if !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("got %v, expected %v", ids, test.ids)
+			}
+		})
+	}
+}
+
+func TestDiversityConfigWithDefaults(t *testing.T) {
+	defaults := DefaultDiversityConfig()
+	tests := []struct {
+		name     string
+		config   DiversityConfig
+		expected DiversityConfig
+	}{
+		{"empty", DiversityConfig{}, DiversityConfig{Lambda: defaults.Lambda, Candidates: defaults.Candidates}},
+		{"only max per episode", DiversityConfig{MaxPerEpisode: 3}, DiversityConfig{Lambda: defaults.Lambda, MaxPerEpisode: 3, Candidates: defaults.Candidates}},
+		{"everything set", DiversityConfig{Lambda: 0.2, MaxPerEpisode: 1, Candidates: 50}, DiversityConfig{Lambda: 0.2, MaxPerEpisode: 1, Candidates: 50}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if config := test.config.WithDefaults(); config != test.expected {
+				t.Errorf("got %+v, expected %+v", config, test.expected)
+			}
+		})
+	}
+}
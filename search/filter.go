@@ -0,0 +1,153 @@
+package search
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"oxide-search/manifest"
+)
+
+// Filter restricts a search to documents from matching episodes, fields left empty don't restrict anything
+type Filter struct {
+	// PublishedFrom and PublishedUntil bound the publication date, from is inclusive and until is exclusive
+	PublishedFrom  *time.Time `json:",omitempty"`
+	PublishedUntil *time.Time `json:",omitempty"`
+	// GUIDs only allows the listed episodes, and ExcludeGUIDs leaves the listed episodes out
+	GUIDs        []string `json:",omitempty"`
+	ExcludeGUIDs []string `json:",omitempty"`
+	// Feed is the title of the podcast feed an episode came from
+	Feed string `json:",omitempty"`
+	// Speaker is one of the people listed for an episode in its feed
+	Speaker string `json:",omitempty"`
+	// TitleContains matches part of the episode title, ignoring case
+	TitleContains string `json:",omitempty"`
+	// Topic is the label of a topic found by the topics command, chunks match their own topic and summaries the
+	// main topics of their episode
+	Topic string `json:",omitempty"`
+	// Entity is the name of a company, person, product, project or paper found by the entities command, chunks
+	// match the entities they name and summaries the entities named anywhere in their episode
+	Entity string `json:",omitempty"`
+	// Domain is the domain of a link in the show notes of an episode, without any www
+	Domain string `json:",omitempty"`
+}
+
+type rangeSearch struct {
+	PublishedAt *rangeBounds  `json:"PublishedAt,omitempty"`
+	Offset      *offsetBounds `json:"Offset,omitempty"`
+}
+
+type rangeBounds struct {
+	Gte *time.Time `json:"gte,omitempty"`
+	Lt  *time.Time `json:"lt,omitempty"`
+}
+
+type wildcardSearch struct {
+	TitleKeyword *wildcardQuery `json:"Title.keyword,omitempty"`
+	LinkURL      *wildcardQuery `json:"Links.URL,omitempty"`
+}
+
+type wildcardQuery struct {
+	Value           string `json:"value"`
+	CaseInsensitive bool   `json:"case_insensitive"`
+}
+
+// IsEmpty is true if the filter doesn't restrict anything
+func (f Filter) IsEmpty() bool {
+	return f.PublishedFrom == nil && f.PublishedUntil == nil && len(f.GUIDs) == 0 && len(f.ExcludeGUIDs) == 0 &&
+		f.Feed == "" && f.Speaker == "" && f.TitleContains == "" && f.Topic == "" && f.Entity == "" &&
+		f.Domain == ""
+}
+
+// query builds the opensearch filter, or returns nil if the filter is empty
+func (f Filter) query() *query {
+	if f.IsEmpty() {
+		return nil
+	}
+
+	var b boolSearch
+	if f.PublishedFrom != nil || f.PublishedUntil != nil {
+		b.Filter = append(b.Filter, query{Range: &rangeSearch{PublishedAt: &rangeBounds{Gte: f.PublishedFrom, Lt: f.PublishedUntil}}})
+	}
+	if len(f.GUIDs) > 0 {
+		b.Filter = append(b.Filter, query{Terms: &termsSearch{GUID: f.GUIDs}})
+	}
+	if len(f.ExcludeGUIDs) > 0 {
+		b.MustNot = append(b.MustNot, query{Terms: &termsSearch{GUID: f.ExcludeGUIDs}})
+	}
+	if f.Feed != "" {
+		b.Filter = append(b.Filter, query{Terms: &termsSearch{Feed: []string{f.Feed}}})
+	}
+	if f.Speaker != "" {
+		b.Filter = append(b.Filter, query{Terms: &termsSearch{Speakers: []string{f.Speaker}}})
+	}
+	if f.Topic != "" {
+		b.Filter = append(b.Filter, query{Terms: &termsSearch{Topics: []string{f.Topic}}})
+	}
+	if f.Entity != "" {
+		b.Filter = append(b.Filter, query{Terms: &termsSearch{Entities: []string{f.Entity}}})
+	}
+	if f.Domain != "" {
+		b.Filter = append(b.Filter, query{Terms: &termsSearch{LinkDomains: []string{f.Domain}}})
+	}
+	if f.TitleContains != "" {
+		b.Filter = append(b.Filter, query{Wildcard: &wildcardSearch{TitleKeyword: &wildcardQuery{
+			Value:           "*" + escapeWildcard(f.TitleContains) + "*",
+			CaseInsensitive: true,
+		}}})
+	}
+	return &query{Bool: &b}
+}
+
+// withFilter adds the filter to a query, to restrict it without affecting its scoring
+func (f Filter) withFilter(q query) query {
+	filter := f.query()
+	if filter == nil {
+		return q
+	}
+	return query{Bool: &boolSearch{Must: []query{q}, Filter: []query{*filter}}}
+}
+
+func escapeWildcard(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`).Replace(value)
+}
+
+// Matches applies the filter to a document, for stores that filter documents themselves
+func (f Filter) Matches(doc *Document) bool {
+	if f.PublishedFrom != nil || f.PublishedUntil != nil {
+		if doc.PublishedAt == nil {
+			return false
+		}
+		if f.PublishedFrom != nil && doc.PublishedAt.Before(*f.PublishedFrom) {
+			return false
+		}
+		if f.PublishedUntil != nil && !doc.PublishedAt.Before(*f.PublishedUntil) {
+			return false
+		}
+	}
+	if len(f.GUIDs) > 0 && !slices.Contains(f.GUIDs, doc.GUID) {
+		return false
+	}
+	if slices.Contains(f.ExcludeGUIDs, doc.GUID) {
+		return false
+	}
+	if f.Feed != "" && doc.Feed != f.Feed {
+		return false
+	}
+	if f.Speaker != "" && !slices.Contains(doc.Speakers, f.Speaker) {
+		return false
+	}
+	if f.Topic != "" && !slices.Contains(doc.Topics, f.Topic) {
+		return false
+	}
+	if f.Entity != "" && !slices.Contains(doc.Entities, f.Entity) {
+		return false
+	}
+	if f.Domain != "" && !slices.ContainsFunc(doc.Links, func(link manifest.Link) bool { return link.Domain == f.Domain }) {
+		return false
+	}
+	if f.TitleContains != "" && !strings.Contains(strings.ToLower(doc.Title), strings.ToLower(f.TitleContains)) {
+		return false
+	}
+	return true
+}
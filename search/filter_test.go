@@ -0,0 +1,169 @@
+package search
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"oxide-search/manifest"
+)
+
+func date(year int, month time.Month, day int) *time.Time {
+	d := time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+	return &d
+}
+
+func TestFilterQuery(t *testing.T) {
+	from, until := date(2023, time.January, 1), date(2024, time.January, 1)
+	tests := []struct {
+		name   string
+		filter Filter
+		query  *query
+	}{
+		{
+			name:   "empty filter",
+			filter: Filter{},
+			query:  nil,
+		},
+		{
+			name:   "publication dates",
+			filter: Filter{PublishedFrom: from, PublishedUntil: until},
+			query:  &query{Bool: &boolSearch{Filter: []query{{Range: &rangeSearch{PublishedAt: &rangeBounds{Gte: from, Lt: until}}}}}},
+		},
+		{
+			name:   "only a start date",
+			filter: Filter{PublishedFrom: from},
+			query:  &query{Bool: &boolSearch{Filter: []query{{Range: &rangeSearch{PublishedAt: &rangeBounds{Gte: from}}}}}},
+		},
+		{
+			name:   "episodes",
+			filter: Filter{GUIDs: []string{"a", "b"}},
+			query:  &query{Bool: &boolSearch{Filter: []query{{Terms: &termsSearch{GUID: []string{"a", "b"}}}}}},
+		},
+		{
+			name:   "excluded episodes",
+			filter: Filter{ExcludeGUIDs: []string{"a"}},
+			query:  &query{Bool: &boolSearch{MustNot: []query{{Terms: &termsSearch{GUID: []string{"a"}}}}}},
+		},
+		{
+			name:   "feed",
+			filter: Filter{Feed: "Oxide and Friends"},
+			query:  &query{Bool: &boolSearch{Filter: []query{{Terms: &termsSearch{Feed: []string{"Oxide and Friends"}}}}}},
+		},
+		{
+			name:   "speaker",
+			filter: Filter{Speaker: "Bryan Cantrill"},
+			query:  &query{Bool: &boolSearch{Filter: []query{{Terms: &termsSearch{Speakers: []string{"Bryan Cantrill"}}}}}},
+		},
+		{
+			name:   "topic",
+			filter: Filter{Topic: "hardware"},
+			query:  &query{Bool: &boolSearch{Filter: []query{{Terms: &termsSearch{Topics: []string{"hardware"}}}}}},
+		},
+		{
+			name:   "entity",
+			filter: Filter{Entity: "illumos"},
+			query:  &query{Bool: &boolSearch{Filter: []query{{Terms: &termsSearch{Entities: []string{"illumos"}}}}}},
+		},
+		{
+			name:   "domain",
+			filter: Filter{Domain: "github.com"},
+			query:  &query{Bool: &boolSearch{Filter: []query{{Terms: &termsSearch{LinkDomains: []string{"github.com"}}}}}},
+		},
+		{
+			name:   "title with wildcard characters",
+			filter: Filter{TitleContains: `Why* Rust?\`},
+			query: &query{Bool: &boolSearch{Filter: []query{{Wildcard: &wildcardSearch{TitleKeyword: &wildcardQuery{
+				Value:           `*Why\* Rust\?\\*`,
+				CaseInsensitive: true,
+			}}}}}},
+		},
+		{
+			name:   "every restriction applies",
+			filter: Filter{GUIDs: []string{"a"}, ExcludeGUIDs: []string{"b"}, Feed: "Oxide and Friends"},
+			query: &query{Bool: &boolSearch{
+				Filter:  []query{{Terms: &termsSearch{GUID: []string{"a"}}}, {Terms: &termsSearch{Feed: []string{"Oxide and Friends"}}}},
+				MustNot: []query{{Terms: &termsSearch{GUID: []string{"b"}}}},
+			}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if q := test.filter.query(); !reflect.DeepEqual(q, test.query) {
+				got, _ := json.Marshal(q)
+				expected, _ := json.Marshal(test.query)
+				t.Errorf("got %s, expected %s", got, expected)
+			}
+		})
+	}
+}
+
+func TestWithFilter(t *testing.T) {
+	match := query{Match: &matchSearch{}}
+	if q := (Filter{}).withFilter(match); !reflect.DeepEqual(q, match) {
+		t.Errorf("an empty filter changed the query to %+v", q)
+	}
+
+	filter := Filter{Feed: "Oxide and Friends"}
+	expected := query{Bool: &boolSearch{Must: []query{match}, Filter: []query{*filter.query()}}}
+	if q := filter.withFilter(match); !reflect.DeepEqual(q, expected) {
+		t.Errorf("got %+v, expected %+v", q, expected)
+	}
+}
+
+func TestFilterMatches(t *testing.T) {
+	doc := Document{
+		EpisodeData: manifest.EpisodeData{
+			Title:    "The Oxide Rack, One Year In",
+			GUID:     "a",
+			Feed:     "Oxide and Friends",
+			Speakers: []string{"Bryan Cantrill", "Adam Leventhal"},
+			Links:    []manifest.Link{{URL: "https://github.com/oxidecomputer", Domain: "github.com"}},
+		},
+		PublishedAt: date(2023, time.June, 1),
+		Topics:      []string{"hardware"},
+		Entities:    []string{"illumos"},
+	}
+	unpublished := doc
+	unpublished.PublishedAt = nil
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		doc     Document
+		matches bool
+	}{
+		{name: "empty filter", filter: Filter{}, doc: doc, matches: true},
+		{name: "between dates", filter: Filter{PublishedFrom: date(2023, time.January, 1), PublishedUntil: date(2024, time.January, 1)}, doc: doc, matches: true},
+		{name: "from is inclusive", filter: Filter{PublishedFrom: date(2023, time.June, 1)}, doc: doc, matches: true},
+		{name: "until is exclusive", filter: Filter{PublishedUntil: date(2023, time.June, 1)}, doc: doc, matches: false},
+		{name: "before from", filter: Filter{PublishedFrom: date(2023, time.July, 1)}, doc: doc, matches: false},
+		{name: "no publication date", filter: Filter{PublishedFrom: date(2020, time.January, 1)}, doc: unpublished, matches: false},
+		{name: "no publication date without dates", filter: Filter{Feed: "Oxide and Friends"}, doc: unpublished, matches: true},
+		{name: "listed episode", filter: Filter{GUIDs: []string{"b", "a"}}, doc: doc, matches: true},
+		{name: "unlisted episode", filter: Filter{GUIDs: []string{"b"}}, doc: doc, matches: false},
+		{name: "excluded episode", filter: Filter{ExcludeGUIDs: []string{"a"}}, doc: doc, matches: false},
+		{name: "other excluded episode", filter: Filter{ExcludeGUIDs: []string{"b"}}, doc: doc, matches: true},
+		{name: "feed", filter: Filter{Feed: "Oxide and Friends"}, doc: doc, matches: true},
+		{name: "other feed", filter: Filter{Feed: "On the Metal"}, doc: doc, matches: false},
+		{name: "speaker", filter: Filter{Speaker: "Adam Leventhal"}, doc: doc, matches: true},
+		{name: "other speaker", filter: Filter{Speaker: "Steve Tuck"}, doc: doc, matches: false},
+		{name: "topic", filter: Filter{Topic: "hardware"}, doc: doc, matches: true},
+		{name: "other topic", filter: Filter{Topic: "software"}, doc: doc, matches: false},
+		{name: "entity", filter: Filter{Entity: "illumos"}, doc: doc, matches: true},
+		{name: "other entity", filter: Filter{Entity: "Linux"}, doc: doc, matches: false},
+		{name: "domain", filter: Filter{Domain: "github.com"}, doc: doc, matches: true},
+		{name: "other domain", filter: Filter{Domain: "oxide.computer"}, doc: doc, matches: false},
+		{name: "title ignoring case", filter: Filter{TitleContains: "oxide rack"}, doc: doc, matches: true},
+		{name: "other title", filter: Filter{TitleContains: "sled"}, doc: doc, matches: false},
+		{name: "one of several restrictions fails", filter: Filter{Feed: "Oxide and Friends", Speaker: "Steve Tuck"}, doc: doc, matches: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if matches := test.filter.Matches(&test.doc); matches != test.matches {
+				t.Errorf("got %t, expected %t", matches, test.matches)
+			}
+		})
+	}
+}
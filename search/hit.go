@@ -0,0 +1,69 @@
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SearchHit is a document found by a search, along with why it was found
+type SearchHit struct {
+	Document
+	// Score is from whichever search found the document, it's only comparable to the scores of the same search
+	Score float64
+	// Rank is the position the search returned the hit at, starting from 1
+	Rank int
+	// Start and End are the words of the transcript a chunk covers, End is exclusive. They're left at 0 for
+	// summaries
+	Start int
+	End   int
+	// MatchedQuestion is the synthetic question that matched, when a chunk was found through one of its questions
+	MatchedQuestion string `json:",omitempty"`
+	// Highlights are fragments of the transcript matching the query text, with the matching words in <em> tags. Only
+	// the text half of a hybrid search has them
+	Highlights []string `json:",omitempty"`
+	// Explanation is how opensearch arrived at the score, only set for searches made with a context from WithExplain
+	Explanation json.RawMessage `json:",omitempty"`
+}
+
+func (h SearchHit) String() string {
+	if h.End > h.Start {
+		return fmt.Sprintf("#%d %.3f %s (words %d-%d of %s, %s)", h.Rank, h.Score, h.Title, h.Start, h.End, h.GUID, h.Id)
+	}
+	return fmt.Sprintf("#%d %.3f %s (%s of %s, %s)", h.Rank, h.Score, h.Title, h.DocType, h.GUID, h.Id)
+}
+
+// Documents strips the search details off of hits
+func Documents(hits []SearchHit) []Document {
+	documents := make([]Document, len(hits))
+	for i := range hits {
+		documents[i] = hits[i].Document
+	}
+	return documents
+}
+
+// ranked fills in the rank and the covered words of each of the ordered hits, before they're returned from a search
+func ranked(hits []SearchHit) []SearchHit {
+	for i := range hits {
+		hits[i].Rank = i + 1
+		if isChunk(&hits[i].Document) {
+			hits[i].Start = hits[i].Offset
+			hits[i].End = hits[i].Offset + len(strings.Fields(hits[i].Transcript))
+		}
+	}
+	return hits
+}
+
+type explainKey struct{}
+
+// WithExplain asks opensearch to explain how it scored each hit of searches made with the context. Explanations
+// are verbose and slow down searches, so they're only meant for debugging
+func WithExplain(ctx context.Context) context.Context {
+	return context.WithValue(ctx, explainKey{}, true)
+}
+
+func explaining(ctx context.Context) bool {
+	explain, _ := ctx.Value(explainKey{}).(bool)
+	return explain
+}
@@ -0,0 +1,171 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+// Ways of fusing the text and vector results of a hybrid search
+const (
+	// FusionRRF is reciprocal rank fusion, which only looks at where each document ranks in each list
+	FusionRRF = "rrf"
+	// FusionWeighted min-max normalizes the scores of each list and adds them up
+	FusionWeighted = "weighted"
+)
+
+// HybridConfig controls how a hybrid search weighs BM25 matches on the transcript text against kNN matches on
+// the vectors. Exact terms like product names or CVE numbers get blurred by the embeddings, but the text search
+// picks them up
+type HybridConfig struct {
+	Fusion       string
+	TextWeight   float64
+	VectorWeight float64
+	// RankConstant is k in the RRF score 1/(k+rank), larger values flatten out the difference between ranks
+	RankConstant int
+	// Candidates is how many results are fetched from each of the text and vector searches before fusing them
+	Candidates int
+}
+
+func DefaultHybridConfig() HybridConfig {
+	return HybridConfig{
+		Fusion:       FusionRRF,
+		TextWeight:   1,
+		VectorWeight: 1,
+		RankConstant: 60,
+		Candidates:   50,
+	}
+}
+
+// WithDefaults fills in anything left unset from DefaultHybridConfig, so a partially filled in config can be
+// taken from a request
+func (c HybridConfig) WithDefaults() HybridConfig {
+	defaults := DefaultHybridConfig()
+	if c.Fusion == "" {
+		c.Fusion = defaults.Fusion
+	}
+	if c.TextWeight == 0 && c.VectorWeight == 0 {
+		c.TextWeight, c.VectorWeight = defaults.TextWeight, defaults.VectorWeight
+	}
+	if c.RankConstant <= 0 {
+		c.RankConstant = defaults.RankConstant
+	}
+	if c.Candidates <= 0 {
+		c.Candidates = defaults.Candidates
+	}
+	return c
+}
+
+// Validate checks the fusion method and weights
+func (c HybridConfig) Validate() error {
+	if c.Fusion != FusionRRF && c.Fusion != FusionWeighted {
+		return fmt.Errorf("unknown fusion %q, expected %s or %s", c.Fusion, FusionRRF, FusionWeighted)
+	}
+	if c.TextWeight < 0 || c.VectorWeight < 0 {
+		return fmt.Errorf("hybrid search weights can't be negative")
+	}
+	return nil
+}
+
+// QueryHybrid runs a BM25 match on the transcript text alongside QueryEmbedding, and fuses the two lists of
+// transcript chunks together
+func QueryHybrid(ctx context.Context, client *opensearch.Client, index string, queryText string, queryVector []float32, size int, config HybridConfig, filter Filter) ([]SearchHit, error) {
+	config = config.WithDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	candidates := max(size, config.Candidates)
+
+	// Only transcript chunks have any transcript text, so there's nothing else to exclude
+	textQuery := filter.withFilter(query{Match: &matchSearch{Transcript: &matchQuery{Query: queryText}}})
+	textMatches, err := runSearch(ctx, client, index, searchRequest{
+		Size:      candidates,
+		Query:     &textQuery,
+		Explain:   explaining(ctx),
+		Highlight: &highlight{Fields: highlightFields{Transcript: &struct{}{}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute text query: %w", err)
+	}
+
+	vectorMatches, err := queryEmbedding(ctx, client, index, queryVector, candidates*2, candidates, notSummaries, filter)
+	if err != nil {
+		return nil, err
+	}
+	vectorMatches, err = collapseQuestions(vectorMatches, candidates, loadDocuments(ctx, client, index))
+	if err != nil {
+		return nil, err
+	}
+
+	return ranked(fuse([]weightedRanking{{textMatches, config.TextWeight}, {vectorMatches, config.VectorWeight}}, size, config)), nil
+}
+
+// weightedRanking is a ranked list of hits to fuse with others, and how much it counts for
+type weightedRanking struct {
+	hits   []SearchHit
+	weight float64
+}
+
+// fuse combines ranked lists of hits into a single ranking, a fused hit keeps the highlights of the first list it
+// appears in and the first question it was matched through
+func fuse(rankings []weightedRanking, size int, config HybridConfig) []SearchHit {
+	fused := make(map[string]*SearchHit)
+	var order []string
+	for _, ranking := range rankings {
+		low, high := scoreRange(ranking.hits)
+		for rank, match := range ranking.hits {
+			var score float64
+			switch config.Fusion {
+			case FusionRRF:
+				score = ranking.weight / float64(config.RankConstant+rank+1)
+			case FusionWeighted:
+				score = ranking.weight
+				if high > low {
+					score = ranking.weight * (match.Score - low) / (high - low)
+				}
+			}
+			if f, ok := fused[match.Id]; ok {
+				f.Score += score
+				if f.MatchedQuestion == "" {
+					f.MatchedQuestion = match.MatchedQuestion
+				}
+				continue
+			}
+			fusedMatch := match
+			fusedMatch.Score = score
+			fused[match.Id] = &fusedMatch
+			order = append(order, match.Id)
+		}
+	}
+
+	response := make([]SearchHit, 0, len(order))
+	for _, id := range order {
+		response = append(response, *fused[id])
+	}
+	sort.SliceStable(response, func(i, j int) bool { return response[i].Score > response[j].Score })
+	return response[:min(size, len(response))]
+}
+
+// FuseRankings combines any number of rankings into one by reciprocal rank fusion, like the results of searching
+// with several variants of a query
+func FuseRankings(rankings [][]SearchHit, size int, rankConstant int) []SearchHit {
+	weighted := make([]weightedRanking, len(rankings))
+	for i := range rankings {
+		weighted[i] = weightedRanking{hits: rankings[i], weight: 1}
+	}
+	return ranked(fuse(weighted, size, HybridConfig{Fusion: FusionRRF, RankConstant: rankConstant}))
+}
+
+func scoreRange(matches []SearchHit) (float64, float64) {
+	if len(matches) == 0 {
+		return 0, 0
+	}
+	low, high := matches[0].Score, matches[0].Score
+	for _, match := range matches {
+		low = min(low, match.Score)
+		high = max(high, match.Score)
+	}
+	return low, high
+}
@@ -0,0 +1,229 @@
+package search
+
+import (
+	"math"
+	"reflect"
+	"sort"
+	"testing"
+
+	"oxide-search/manifest"
+)
+
+func scoredHit(id string, score float64) SearchHit {
+	return SearchHit{Document: Document{Id: id}, Score: score}
+}
+
+func hitIds(hits []SearchHit) []string {
+	ids := make([]string, len(hits))
+	for i := range hits {
+		ids[i] = hits[i].Id
+	}
+	return ids
+}
+
+func TestFuse(t *testing.T) {
+	tests := []struct {
+		name     string
+		rankings []weightedRanking
+		size     int
+		config   HybridConfig
+		ids      []string
+		scores   []float64
+	}{
+		{
+			name: "rrf adds up the reciprocal ranks",
+			rankings: []weightedRanking{
+				{hits: []SearchHit{scoredHit("a", 9), scoredHit("b", 5)}, weight: 1},
+				{hits: []SearchHit{scoredHit("b", 0.9), scoredHit("c", 0.5)}, weight: 1},
+			},
+			size:   3,
+			config: HybridConfig{Fusion: FusionRRF, RankConstant: 60},
+			ids:    []string{"b", "a", "c"},
+			scores: []float64{1.0/62 + 1.0/61, 1.0 / 61, 1.0 / 62},
+		},
+		{
+			name: "rrf weights each ranking",
+			rankings: []weightedRanking{
+				{hits: []SearchHit{scoredHit("a", 9)}, weight: 1},
+				{hits: []SearchHit{scoredHit("b", 0.9)}, weight: 2},
+			},
+			size:   2,
+			config: HybridConfig{Fusion: FusionRRF, RankConstant: 0},
+			ids:    []string{"b", "a"},
+			scores: []float64{2, 1},
+		},
+		{
+			name: "weighted normalizes the scores of each ranking",
+			rankings: []weightedRanking{
+				{hits: []SearchHit{scoredHit("a", 20), scoredHit("b", 15), scoredHit("c", 10)}, weight: 0.5},
+				{hits: []SearchHit{scoredHit("c", 0.8), scoredHit("a", 0.4)}, weight: 1},
+			},
+			size:   3,
+			config: HybridConfig{Fusion: FusionWeighted},
+			ids:    []string{"c", "a", "b"},
+			scores: []float64{1, 0.5, 0.25},
+		},
+		{
+			name: "weighted gives the full weight to a ranking where every score is the same",
+			rankings: []weightedRanking{
+				{hits: []SearchHit{scoredHit("a", 3), scoredHit("b", 3)}, weight: 0.5},
+			},
+			size:   2,
+			config: HybridConfig{Fusion: FusionWeighted},
+			ids:    []string{"a", "b"},
+			scores: []float64{0.5, 0.5},
+		},
+		{
+			name: "cut to size",
+			rankings: []weightedRanking{
+				{hits: []SearchHit{scoredHit("a", 3), scoredHit("b", 2), scoredHit("c", 1)}, weight: 1},
+			},
+			size:   2,
+			config: HybridConfig{Fusion: FusionRRF, RankConstant: 60},
+			ids:    []string{"a", "b"},
+			scores: []float64{1.0 / 61, 1.0 / 62},
+		},
+		{
+			name:   "no rankings",
+			size:   2,
+			config: HybridConfig{Fusion: FusionRRF, RankConstant: 60},
+			ids:    []string{},
+			scores: []float64{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fused := fuse(test.rankings, test.size, test.config)
+			if ids := hitIds(fused); !reflect.DeepEqual(ids, test.ids) {
+				t.Fatalf("got %v, expected %v", ids, test.ids)
+			}
+			for i := range fused {
+				if math.Abs(fused[i].Score-test.scores[i]) > 1e-9 {
+					t.Errorf("%s scored %f, expected %f", fused[i].Id, fused[i].Score, test.scores[i])
+				}
+			}
+		})
+	}
+}
+
+func TestFuseKeepsFirstMatch(t *testing.T) {
+	text := SearchHit{Document: Document{Id: "a"}, Score: 2, Highlights: []string{"<em>text</em>"}}
+	vector := SearchHit{Document: Document{Id: "a"}, Score: 0.5, MatchedQuestion: "why?"}
+	fused := fuse([]weightedRanking{{hits: []SearchHit{text}, weight: 1}, {hits: []SearchHit{vector}, weight: 1}}, 1, HybridConfig{Fusion: FusionRRF})
+	if len(fused) != 1 {
+		t.Fatalf("got %d hits, expected 1", len(fused))
+	}
+	if !reflect.DeepEqual(fused[0].Highlights, text.Highlights) {
+		t.Errorf("got highlights %v, expected %v", fused[0].Highlights, text.Highlights)
+	}
+	if fused[0].MatchedQuestion != vector.MatchedQuestion {
+		t.Errorf("got matched question %q, expected %q", fused[0].MatchedQuestion, vector.MatchedQuestion)
+	}
+}
+
+func TestFuseRankings(t *testing.T) {
+	rankings := [][]SearchHit{
+		{scoredHit("a", 1), scoredHit("b", 0.5)},
+		{scoredHit("b", 1), scoredHit("c", 0.5)},
+		{scoredHit("b", 1), scoredHit("a", 0.5)},
+	}
+	fused := FuseRankings(rankings, 3, 60)
+	if ids := hitIds(fused); !reflect.DeepEqual(ids, []string{"b", "a", "c"}) {
+		t.Fatalf("got %v, expected [b a c]", ids)
+	}
+	for i := range fused {
+		if fused[i].Rank != i+1 {
+			t.Errorf("%s ranked %d, expected %d", fused[i].Id, fused[i].Rank, i+1)
+		}
+	}
+}
+
+func transcriptDocument(id string, transcript string) Document {
+	return Document{Id: id, EpisodeData: manifest.EpisodeData{Transcript: transcript}}
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := tokenize("The Oxide rack's 32-sled, uh, design!")
+	expected := []string{"the", "oxide", "rack", "s", "32", "sled", "uh", "design"}
+	if !reflect.DeepEqual(tokens, expected) {
+		t.Errorf("got %v, expected %v", tokens, expected)
+	}
+}
+
+func TestBM25(t *testing.T) {
+	documents := []Document{
+		transcriptDocument("rust", "rust rust rust is a language"),
+		transcriptDocument("mention", "we talked about rust and the hubris kernel"),
+		transcriptDocument("hubris", "hubris is a small kernel"),
+		// Summaries have no transcript, and aren't indexed
+		{Id: "summary"},
+	}
+	index := newBM25Index(documents)
+
+	tests := []struct {
+		name  string
+		query string
+		ids   []string
+	}{
+		{
+			name:  "more occurrences score higher",
+			query: "rust",
+			ids:   []string{"rust", "mention"},
+		},
+		{
+			name:  "shorter documents score higher",
+			query: "hubris kernel",
+			ids:   []string{"hubris", "mention"},
+		},
+		{
+			name:  "matching more terms scores higher",
+			query: "rust hubris",
+			ids:   []string{"mention", "rust", "hubris"},
+		},
+		{
+			name:  "case is ignored",
+			query: "RUST",
+			ids:   []string{"rust", "mention"},
+		},
+		{
+			name:  "unknown terms match nothing",
+			query: "illumos",
+			ids:   []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			candidates := index.search(test.query)
+			sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+			ids := make([]string, len(candidates))
+			for i := range candidates {
+				ids[i] = documents[candidates[i].index].Id
+			}
+			if !reflect.DeepEqual(ids, test.ids) {
+				t.Errorf("got %v, expected %v", ids, test.ids)
+			}
+		})
+	}
+}
+
+func TestBM25Score(t *testing.T) {
+	// One document of average length with the term once, out of two documents
+	index := newBM25Index([]Document{transcriptDocument("", "rust kernel"), transcriptDocument("", "hubris kernel")})
+	candidates := index.search("rust")
+	if len(candidates) != 1 {
+		t.Fatalf("got %d candidates, expected 1", len(candidates))
+	}
+	idf := math.Log(1 + (2-1+0.5)/(1+0.5))
+	expected := idf * (bm25K1 + 1) / (1 + bm25K1)
+	if math.Abs(candidates[0].score-expected) > 1e-9 {
+		t.Errorf("got %f, expected %f", candidates[0].score, expected)
+	}
+}
+
+func TestHighlights(t *testing.T) {
+	fragments := highlights("we built the Hubris kernel, in rust", "hubris rust")
+	expected := []string{"we built the <em>Hubris</em> kernel, in <em>rust</em>"}
+	if !reflect.DeepEqual(fragments, expected) {
+		t.Errorf("got %v, expected %v", fragments, expected)
+	}
+}
@@ -0,0 +1,157 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+
+	"oxide-search/manifest"
+)
+
+// DefaultReferences is how many links from the show notes are returned as sources alongside search results
+const DefaultReferences = 5
+
+// Reference is a link from the show notes of an episode
+type Reference struct {
+	manifest.Link
+	GUID  string
+	Title string
+	// EpisodeLink is the page of the episode the link is from
+	EpisodeLink string
+	PublishedAt *time.Time `json:",omitempty"`
+}
+
+func (r Reference) String() string {
+	if r.Text == "" {
+		return fmt.Sprintf("%s (from %s)", r.URL, r.Title)
+	}
+	return fmt.Sprintf("%s - %s (from %s)", r.Text, r.URL, r.Title)
+}
+
+// QueryLinks finds the links in the show notes whose anchor text has every word of the text, or whose URL contains
+// it, from the newest episodes first. Empty text matches every link, and a Domain in the filter keeps only the links
+// to that domain
+func QueryLinks(ctx context.Context, client *opensearch.Client, index string, text string, filter Filter) ([]Reference, error) {
+	// Every document of an episode carries its links, so only the first chunk of each episode is searched
+	linkQuery := query{Bool: &boolSearch{
+		Filter:  []query{{Terms: &termsSearch{VectorId: []int{0}}}},
+		MustNot: []query{notChunks},
+	}}
+	if text = strings.TrimSpace(text); text != "" {
+		linkQuery.Bool.Must = []query{{Bool: &boolSearch{Should: []query{
+			{Match: &matchSearch{LinkText: &matchQuery{Query: text, Operator: "and"}}},
+			{Wildcard: &wildcardSearch{LinkURL: &wildcardQuery{Value: "*" + escapeWildcard(text) + "*", CaseInsensitive: true}}},
+		}}}}
+	}
+	episodes, err := searchDocuments(ctx, client, index, maxEpisodeDocuments, filter.withFilter(linkQuery))
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute link query: %w", err)
+	}
+	return findLinks(episodes, text, filter.Domain), nil
+}
+
+// findLinks picks the links matching the text, and the domain if there is one, out of the documents of each
+// episode, ordering them by when the episode was published and then their order in the show notes
+func findLinks(episodes []Document, text string, domain string) []Reference {
+	text = strings.TrimSpace(text)
+	slices.SortStableFunc(episodes, func(a, b Document) int {
+		switch {
+		case a.PublishedAt != nil && b.PublishedAt != nil:
+			return b.PublishedAt.Compare(*a.PublishedAt)
+		case (a.PublishedAt == nil) != (b.PublishedAt == nil):
+			// Episodes without a publication date go last
+			if a.PublishedAt == nil {
+				return 1
+			}
+			return -1
+		default:
+			return 0
+		}
+	})
+
+	var references []Reference
+	for _, episode := range episodes {
+		for _, link := range episode.Links {
+			if domain != "" && link.Domain != domain {
+				continue
+			}
+			if !matchesLink(link, text) {
+				continue
+			}
+			references = append(references, newReference(episode, link))
+		}
+	}
+	return references
+}
+
+// matchesLink is true if the anchor text of the link has every word of the text, or its URL contains the text
+func matchesLink(link manifest.Link, text string) bool {
+	if strings.Contains(strings.ToLower(link.URL), strings.ToLower(text)) {
+		return true
+	}
+	words := tokenize(link.Text)
+	for _, term := range tokenize(text) {
+		if !slices.Contains(words, term) {
+			return false
+		}
+	}
+	return len(tokenize(text)) > 0
+}
+
+func newReference(doc Document, link manifest.Link) Reference {
+	return Reference{
+		Link:        link,
+		GUID:        doc.GUID,
+		Title:       doc.Title,
+		EpisodeLink: doc.EpisodeData.Link,
+		PublishedAt: doc.PublishedAt,
+	}
+}
+
+// References are the links from the show notes of the episodes behind the hits, up to size of them. Links whose
+// anchor text is said in one of the hits come first, then the rest of the links of the episodes in the order of
+// the hits
+func References(hits []SearchHit, size int) []Reference {
+	seen := make(map[string]bool)
+	var mentioned, rest []Reference
+	for _, hit := range hits {
+		tokens := tokenize(hit.Transcript + " " + hit.Summary)
+		for _, link := range hit.Links {
+			if seen[link.URL] {
+				continue
+			}
+			if mentions(tokens, tokenize(link.Text)) {
+				seen[link.URL] = true
+				mentioned = append(mentioned, newReference(hit.Document, link))
+			}
+		}
+	}
+	for _, hit := range hits {
+		for _, link := range hit.Links {
+			if !seen[link.URL] {
+				seen[link.URL] = true
+				rest = append(rest, newReference(hit.Document, link))
+			}
+		}
+	}
+
+	references := append(mentioned, rest...)
+	return references[:min(size, len(references))]
+}
+
+// mentions is true if the terms are said in order somewhere in the tokens
+func mentions(tokens []string, terms []string) bool {
+	if len(terms) == 0 {
+		return false
+	}
+	for i := range tokens {
+		if _, ok := matchPhrase(tokens, i, terms, 0); ok {
+			return true
+		}
+	}
+	return false
+}
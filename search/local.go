@@ -0,0 +1,441 @@
+package search
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LocalStore is a VectorStore that needs no external services, for laptops and CI. Documents are kept on disk as
+// a JSON lines file of their metadata alongside a flat file of their vectors, and the whole thing is loaded into
+// memory and searched exactly by cosine similarity. That's plenty fast for the few tens of thousands of documents
+// in the podcast
+type LocalStore struct {
+	dir       string
+	documents []Document
+	norms     []float64
+	ids       map[string]int
+	// modified is when the files of the store were last written as of loading it, see Stale
+	modified time.Time
+
+	// text is only built the first time a hybrid search is made
+	textLock sync.Mutex
+	text     *bm25Index
+}
+
+const (
+	localDocumentsFile = "documents.jsonl"
+	localVectorsFile   = "vectors.bin"
+)
+
+// LocalStorePath is the directory a local store for an index is kept in
+func LocalStorePath(dataDirectory string, index string) string {
+	return filepath.Join(dataDirectory, "store", index)
+}
+
+// NewLocalStore creates an empty store that will be saved to dir, replacing anything already there
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{dir: dir, ids: make(map[string]int)}
+}
+
+// OpenLocalStore loads a store from dir, returning an empty store if nothing has been saved there yet
+func OpenLocalStore(dir string) (*LocalStore, error) {
+	store := NewLocalStore(dir)
+	// Taken before reading, so the store is stale if it's written again while being read
+	store.modified = modified(dir)
+
+	documentsFile, err := os.Open(filepath.Join(dir, localDocumentsFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local store %s: %w", dir, err)
+	}
+	defer documentsFile.Close()
+	vectorsFile, err := os.Open(filepath.Join(dir, localVectorsFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open local store %s: %w", dir, err)
+	}
+	defer vectorsFile.Close()
+
+	vectors := bufio.NewReader(vectorsFile)
+	var header [2]uint32
+	err = binary.Read(vectors, binary.LittleEndian, &header)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read vectors of local store %s: %w", dir, err)
+	}
+	dimension, count := int(header[0]), int(header[1])
+
+	decoder := json.NewDecoder(bufio.NewReader(documentsFile))
+	for i := 0; i < count; i++ {
+		var doc Document
+		err = decoder.Decode(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read document %d of local store %s: %w", i, dir, err)
+		}
+		doc.Vectors = make([]float32, dimension)
+		err = binary.Read(vectors, binary.LittleEndian, doc.Vectors)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read vector %d of local store %s: %w", i, dir, err)
+		}
+		store.Put(doc)
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("local store %s has more documents than vectors", dir)
+	}
+
+	return store, nil
+}
+
+// Stale is true if the files of the store have been written since it was loaded, by indexing into it from another
+// process, so it should be opened again to see the changes
+func (s *LocalStore) Stale() bool {
+	return !modified(s.dir).Equal(s.modified)
+}
+
+// modified is when the files of a store were last written, or zero if it hasn't been saved
+func modified(dir string) time.Time {
+	var latest time.Time
+	for _, file := range []string{localDocumentsFile, localVectorsFile} {
+		if info, err := os.Stat(filepath.Join(dir, file)); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+// Len is the number of documents in the store
+func (s *LocalStore) Len() int {
+	return len(s.documents)
+}
+
+// Put adds documents to the store, replacing any with the same Id
+func (s *LocalStore) Put(documents ...Document) {
+	s.textLock.Lock()
+	s.text = nil
+	s.textLock.Unlock()
+	for _, doc := range documents {
+		if i, ok := s.ids[doc.Id]; ok {
+			s.documents[i] = doc
+			s.norms[i] = norm(doc.Vectors)
+			continue
+		}
+		s.ids[doc.Id] = len(s.documents)
+		s.documents = append(s.documents, doc)
+		s.norms = append(s.norms, norm(doc.Vectors))
+	}
+}
+
+// Save writes the store to its directory, each file is written alongside the old one and then renamed over it
+func (s *LocalStore) Save() error {
+	err := os.MkdirAll(s.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to create local store %s: %w", s.dir, err)
+	}
+
+	dimension := 0
+	if len(s.documents) > 0 {
+		dimension = len(s.documents[0].Vectors)
+	}
+
+	err = writeAtomic(filepath.Join(s.dir, localVectorsFile), func(w io.Writer) error {
+		if err := binary.Write(w, binary.LittleEndian, [2]uint32{uint32(dimension), uint32(len(s.documents))}); err != nil {
+			return err
+		}
+		for _, doc := range s.documents {
+			if len(doc.Vectors) != dimension {
+				return fmt.Errorf("document %s has a %d dimension vector, expected %d", doc.Id, len(doc.Vectors), dimension)
+			}
+			if err := binary.Write(w, binary.LittleEndian, doc.Vectors); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write vectors of local store %s: %w", s.dir, err)
+	}
+
+	err = writeAtomic(filepath.Join(s.dir, localDocumentsFile), func(w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		for _, doc := range s.documents {
+			doc.Vectors = nil
+			if err := encoder.Encode(doc); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write documents of local store %s: %w", s.dir, err)
+	}
+	return nil
+}
+
+func writeAtomic(path string, write func(w io.Writer) error) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func norm(vector []float32) float64 {
+	var sum float64
+	for _, v := range vector {
+		sum += float64(v) * float64(v)
+	}
+	return math.Sqrt(sum)
+}
+
+// search scores every document matching the filter against the query vector, and returns the closest
+func (s *LocalStore) search(queryVector []float32, size int, match func(doc *Document) bool) []SearchHit {
+	queryNorm := norm(queryVector)
+	var candidates []scored
+	for i := range s.documents {
+		doc := &s.documents[i]
+		if match != nil && !match(doc) {
+			continue
+		}
+		if len(doc.Vectors) != len(queryVector) || s.norms[i] == 0 || queryNorm == 0 {
+			continue
+		}
+		var dot float64
+		for j, v := range doc.Vectors {
+			dot += float64(v) * float64(queryVector[j])
+		}
+		candidates = append(candidates, scored{index: i, score: dot / (s.norms[i] * queryNorm)})
+	}
+	return s.top(candidates, size)
+}
+
+// scored is the score of the document at index in the store
+type scored struct {
+	index int
+	score float64
+}
+
+// top returns the highest scoring candidates
+func (s *LocalStore) top(candidates []scored, size int) []SearchHit {
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].score > candidates[j].score })
+	response := make([]SearchHit, 0, min(size, len(candidates)))
+	for _, c := range candidates[:min(size, len(candidates))] {
+		response = append(response, SearchHit{Document: s.documents[c.index], Score: c.score})
+	}
+	return response
+}
+
+// get looks up documents by their Id, skipping any that aren't in the store
+func (s *LocalStore) get(ids []string) []Document {
+	var response []Document
+	for _, id := range ids {
+		if i, ok := s.ids[id]; ok {
+			response = append(response, s.documents[i])
+		}
+	}
+	return response
+}
+
+// isChunk matches transcript chunks, which don't have a DocType if they were indexed before there were summaries
+func isChunk(doc *Document) bool {
+	return doc.DocType == DocTypeChunk || doc.DocType == ""
+}
+
+func isSummary(doc *Document) bool {
+	return doc.DocType == DocTypeSummary || doc.DocType == DocTypeChapter
+}
+
+func (s *LocalStore) QueryEmbedding(_ context.Context, queryVector []float32, size int, _ int, filter Filter) ([]SearchHit, error) {
+	matches := s.search(queryVector, size*2, func(doc *Document) bool { return !isSummary(doc) && filter.Matches(doc) })
+	chunks, err := collapseQuestions(matches, size, s.load)
+	return ranked(chunks), err
+}
+
+func (s *LocalStore) load(ids []string) ([]Document, error) {
+	return s.get(ids), nil
+}
+
+func (s *LocalStore) QueryChunks(_ context.Context, queryVector []float32, size int, _ int, filter Filter) ([]SearchHit, error) {
+	return ranked(s.search(queryVector, size, func(doc *Document) bool { return isChunk(doc) && filter.Matches(doc) })), nil
+}
+
+func (s *LocalStore) QueryHybrid(_ context.Context, queryText string, queryVector []float32, size int, config HybridConfig, filter Filter) ([]SearchHit, error) {
+	config = config.WithDefaults()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	candidates := max(size, config.Candidates)
+
+	s.textLock.Lock()
+	if s.text == nil {
+		s.text = newBM25Index(s.documents)
+	}
+	text := s.text
+	s.textLock.Unlock()
+	var textCandidates []scored
+	for _, c := range text.search(queryText) {
+		if filter.Matches(&s.documents[c.index]) {
+			textCandidates = append(textCandidates, c)
+		}
+	}
+	textMatches := s.top(textCandidates, candidates)
+	for i := range textMatches {
+		textMatches[i].Highlights = highlights(textMatches[i].Transcript, queryText)
+	}
+
+	vectorMatches := s.search(queryVector, candidates*2, func(doc *Document) bool { return !isSummary(doc) && filter.Matches(doc) })
+	vectorMatches, err := collapseQuestions(vectorMatches, candidates, s.load)
+	if err != nil {
+		return nil, err
+	}
+
+	return ranked(fuse([]weightedRanking{{textMatches, config.TextWeight}, {vectorMatches, config.VectorWeight}}, size, config)), nil
+}
+
+func (s *LocalStore) QueryEpisodes(_ context.Context, queryVector []float32, episodes int, filter Filter) ([]SearchHit, error) {
+	matches := s.search(queryVector, episodes*5, func(doc *Document) bool { return isSummary(doc) && filter.Matches(doc) })
+	return ranked(bestPerEpisode(matches, episodes)), nil
+}
+
+func (s *LocalStore) QueryHierarchical(ctx context.Context, queryVector []float32, episodes int, size int, filter Filter) ([]SearchHit, error) {
+	matchingEpisodes, err := s.QueryEpisodes(ctx, queryVector, episodes, filter)
+	if err != nil {
+		return nil, err
+	}
+	guids := make(map[string]bool, len(matchingEpisodes))
+	for _, episode := range matchingEpisodes {
+		guids[episode.GUID] = true
+	}
+	return ranked(s.search(queryVector, size, func(doc *Document) bool { return guids[doc.GUID] && isChunk(doc) && filter.Matches(doc) })), nil
+}
+
+func (s *LocalStore) QueryPage(_ context.Context, queryVector []float32, size int, after string, filter Filter) (*Page, error) {
+	c, err := parseCursor(after)
+	if err != nil {
+		return nil, err
+	}
+	matches := s.search(queryVector, MaxPagedResults, func(doc *Document) bool { return isChunk(doc) && filter.Matches(doc) })
+	slices.SortStableFunc(matches, byScore)
+
+	var hits []SearchHit
+	for _, match := range matches {
+		if len(hits) == size {
+			break
+		}
+		if c.after(match) {
+			hits = append(hits, match)
+		}
+	}
+	return newPage(hits, size, c), nil
+}
+
+func (s *LocalStore) MoreLikeThis(_ context.Context, id string, size int, filter Filter) ([]SearchHit, error) {
+	i, ok := s.ids[id]
+	if !ok {
+		return nil, fmt.Errorf("document %s: %w", id, ErrNotFound)
+	}
+	source := s.documents[i]
+	return ranked(s.search(source.Vectors, size, func(doc *Document) bool {
+		return isChunk(doc) && doc.GUID != source.GUID && filter.Matches(doc)
+	})), nil
+}
+
+func (s *LocalStore) RelatedEpisodes(_ context.Context, GUID string, size int, filter Filter) ([]SearchHit, error) {
+	var documents []Document
+	for i := range s.documents {
+		if s.documents[i].GUID == GUID {
+			documents = append(documents, s.documents[i])
+		}
+	}
+	vector, summary := episodeVector(documents)
+	if vector == nil {
+		return nil, fmt.Errorf("episode %s: %w", GUID, ErrNotFound)
+	}
+
+	other := func(doc *Document) bool { return doc.GUID != GUID && filter.Matches(doc) }
+	if summary {
+		matches := s.search(vector, size*5, func(doc *Document) bool { return isSummary(doc) && other(doc) })
+		if len(matches) > 0 {
+			return ranked(bestPerEpisode(matches, size)), nil
+		}
+	}
+	matches := s.search(vector, size*10, func(doc *Document) bool { return isChunk(doc) && other(doc) })
+	return ranked(bestPerEpisode(matches, size)), nil
+}
+
+func (s *LocalStore) QueryThreshold(_ context.Context, queryVector []float32, threshold float64, filter Filter) ([]SearchHit, error) {
+	matches := s.search(queryVector, len(s.documents), func(doc *Document) bool { return isChunk(doc) && filter.Matches(doc) })
+	end := sort.Search(len(matches), func(i int) bool { return matches[i].Score < threshold })
+	return ranked(matches[:end]), nil
+}
+
+func (s *LocalStore) QueryQuotes(_ context.Context, phrase string, slop int, filter Filter) ([]Quote, error) {
+	if len(tokenize(phrase)) == 0 {
+		return nil, fmt.Errorf("a phrase to search for is required")
+	}
+	var chunks []Document
+	for i := range s.documents {
+		if isChunk(&s.documents[i]) && filter.Matches(&s.documents[i]) {
+			chunks = append(chunks, s.documents[i])
+		}
+	}
+	return findQuotes(chunks, phrase, slop), nil
+}
+
+func (s *LocalStore) QueryLinks(_ context.Context, text string, filter Filter) ([]Reference, error) {
+	// Every document of an episode carries its links, so only the first chunk of each episode is searched
+	var episodes []Document
+	for i := range s.documents {
+		if isChunk(&s.documents[i]) && s.documents[i].VectorId == 0 && filter.Matches(&s.documents[i]) {
+			episodes = append(episodes, s.documents[i])
+		}
+	}
+	return findLinks(episodes, text, filter.Domain), nil
+}
+
+func (s *LocalStore) ExpandContext(_ context.Context, sources []Document, radius int) ([]Passage, error) {
+	neighborhoods := make(map[string][]offsetBounds)
+	for _, source := range sources {
+		neighborhoods[source.GUID] = append(neighborhoods[source.GUID], neighborhood(source, radius))
+	}
+
+	var chunks []Document
+	for i := range s.documents {
+		doc := &s.documents[i]
+		if !isChunk(doc) {
+			continue
+		}
+		for _, bounds := range neighborhoods[doc.GUID] {
+			if doc.Offset >= bounds.Gte && doc.Offset <= bounds.Lte {
+				chunks = append(chunks, *doc)
+				break
+			}
+		}
+	}
+
+	return mergePassages(sources, chunks), nil
+}
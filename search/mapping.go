@@ -0,0 +1,358 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// IndexSettings are the knn settings an index is created with, the dimension has to match the embedding model.
+// The lucene engine is the default as it can filter while searching, indexes built with nmslib still work but
+// filtered searches of them fall back to an exact search
+type IndexSettings struct {
+	Dimension      int
+	SpaceType      string
+	Engine         string
+	M              int
+	EfConstruction int
+	EfSearch       int
+}
+
+func DefaultIndexSettings(dimension int) IndexSettings {
+	return IndexSettings{
+		Dimension:      dimension,
+		SpaceType:      "cosinesimil",
+		Engine:         "lucene",
+		M:              16,
+		EfConstruction: 100,
+		EfSearch:       100,
+	}
+}
+
+type indexBody struct {
+	Settings indexSettingsBody `json:"settings"`
+	Mappings mappingBody       `json:"mappings"`
+}
+
+type indexSettingsBody struct {
+	Knn      bool `json:"knn"`
+	EfSearch int  `json:"knn.algo_param.ef_search,omitempty"`
+}
+
+type mappingBody struct {
+	Properties map[string]fieldMapping `json:"properties"`
+}
+
+// fieldMapping is the mapping of one field, objects have Properties instead of a Type
+type fieldMapping struct {
+	Type       string                  `json:"type,omitempty"`
+	Dimension  int                     `json:"dimension,omitempty"`
+	Method     *knnMethod              `json:"method,omitempty"`
+	Format     string                  `json:"format,omitempty"`
+	Analyzer   string                  `json:"analyzer,omitempty"`
+	Fields     map[string]fieldMapping `json:"fields,omitempty"`
+	Properties map[string]fieldMapping `json:"properties,omitempty"`
+}
+
+type knnMethod struct {
+	Name       string         `json:"name"`
+	SpaceType  string         `json:"space_type"`
+	Engine     string         `json:"engine"`
+	Parameters knnMethodParam `json:"parameters"`
+}
+
+type knnMethodParam struct {
+	M              int `json:"m,omitempty"`
+	EfConstruction int `json:"ef_construction,omitempty"`
+}
+
+// metadataFields are the mappings for everything in a Document besides its vector
+var metadataFields = map[string]fieldMapping{
+	"Id":          {Type: "keyword"},
+	"GUID":        {Type: "keyword"},
+	"DocType":     {Type: "keyword"},
+	"ParentId":    {Type: "keyword"},
+	"VectorId":    {Type: "integer"},
+	"Offset":      {Type: "integer"},
+	"Title":       {Type: "text", Fields: map[string]fieldMapping{"keyword": {Type: "keyword"}}},
+	"Description": {Type: "text"},
+	"Link":        {Type: "keyword"},
+	"Filename":    {Type: "keyword"},
+	"Published":   {Type: "keyword"},
+	"Feed":        {Type: "keyword"},
+	"Speakers":    {Type: "keyword"},
+	"Topics":      {Type: "keyword"},
+	"Entities":    {Type: "keyword"},
+	"Links": {Properties: map[string]fieldMapping{
+		"URL":    {Type: "keyword"},
+		"Text":   {Type: "text"},
+		"Domain": {Type: "keyword"},
+	}},
+	"PublishedAt": {Type: "date", Format: "strict_date_optional_time"},
+	"Transcript":  {Type: "text", Analyzer: "english"},
+	"Summary":     {Type: "text"},
+	"Chapter":     {Type: "text"},
+	"Question":    {Type: "text"},
+}
+
+// flatten lists the fields of a mapping by their full name, naming the fields of an object after it like
+// Links.URL
+func flatten(prefix string, properties map[string]fieldMapping, fields map[string]fieldMapping) {
+	for name, field := range properties {
+		if len(field.Properties) > 0 {
+			flatten(prefix+name+".", field.Properties, fields)
+			continue
+		}
+		fields[prefix+name] = field
+	}
+}
+
+func (s IndexSettings) body() indexBody {
+	properties := make(map[string]fieldMapping, len(metadataFields)+1)
+	for name, field := range metadataFields {
+		properties[name] = field
+	}
+	properties["vector_data"] = fieldMapping{
+		Type:      "knn_vector",
+		Dimension: s.Dimension,
+		Method: &knnMethod{
+			Name:      "hnsw",
+			SpaceType: s.SpaceType,
+			Engine:    s.Engine,
+			Parameters: knnMethodParam{
+				M:              s.M,
+				EfConstruction: s.EfConstruction,
+			},
+		},
+	}
+
+	settings := indexSettingsBody{Knn: true}
+	// ef_search is only an index setting for the nmslib engine, the others take it per query
+	if s.Engine == "nmslib" {
+		settings.EfSearch = s.EfSearch
+	}
+
+	return indexBody{
+		Settings: settings,
+		Mappings: mappingBody{Properties: properties},
+	}
+}
+
+// CreateIndex creates a knn index with mappings for all the document fields
+func CreateIndex(ctx context.Context, client *opensearch.Client, index string, settings IndexSettings) error {
+	body, err := json.Marshal(settings.body())
+	if err != nil {
+		return fmt.Errorf("failed to build index settings: %w", err)
+	}
+
+	err = do(ctx, client, opensearchapi.IndicesCreateRequest{Index: index, Body: bytes.NewReader(body)}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create index %s: %w", index, err)
+	}
+	return nil
+}
+
+func DeleteIndex(ctx context.Context, client *opensearch.Client, index string) error {
+	err := do(ctx, client, opensearchapi.IndicesDeleteRequest{Index: []string{index}}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to delete index %s: %w", index, err)
+	}
+	return nil
+}
+
+// IndexDescription is what's currently configured on an index
+type IndexDescription struct {
+	Name     string
+	Aliases  []string
+	Settings IndexSettings
+	// Fields maps each field in the mapping to its type, and Analyzers any text fields to their analyzer
+	Fields    map[string]string
+	Analyzers map[string]string
+	DocCount  int
+}
+
+func (d *IndexDescription) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "index:           %s\n", d.Name)
+	if len(d.Aliases) > 0 {
+		fmt.Fprintf(&b, "aliases:         %s\n", strings.Join(d.Aliases, ", "))
+	}
+	fmt.Fprintf(&b, "documents:       %d\n", d.DocCount)
+	fmt.Fprintf(&b, "dimension:       %d\n", d.Settings.Dimension)
+	fmt.Fprintf(&b, "space type:      %s\n", d.Settings.SpaceType)
+	fmt.Fprintf(&b, "engine:          %s\n", d.Settings.Engine)
+	fmt.Fprintf(&b, "m:               %d\n", d.Settings.M)
+	fmt.Fprintf(&b, "ef_construction: %d\n", d.Settings.EfConstruction)
+	fmt.Fprintf(&b, "ef_search:       %d\n", d.Settings.EfSearch)
+	b.WriteString("fields:\n")
+	names := make([]string, 0, len(d.Fields))
+	for name := range d.Fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if analyzer, ok := d.Analyzers[name]; ok {
+			fmt.Fprintf(&b, "  %-14s %s (%s)\n", name, d.Fields[name], analyzer)
+			continue
+		}
+		fmt.Fprintf(&b, "  %-14s %s\n", name, d.Fields[name])
+	}
+	return b.String()
+}
+
+// DescribeIndex reads back the settings and mappings of an index, returning nil if the index doesn't exist
+func DescribeIndex(ctx context.Context, client *opensearch.Client, index string) (*IndexDescription, error) {
+	flat := true
+	var indices map[string]struct {
+		Aliases  map[string]any `json:"aliases"`
+		Mappings mappingBody    `json:"mappings"`
+		Settings map[string]any `json:"settings"`
+	}
+	err := do(ctx, client, opensearchapi.IndicesGetRequest{Index: []string{index}, FlatSettings: &flat}, &indices)
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe index %s: %w", index, err)
+	}
+	if len(indices) != 1 {
+		return nil, fmt.Errorf("expected %s to be a single index but found %d", index, len(indices))
+	}
+
+	var description IndexDescription
+	for name, i := range indices {
+		description.Name = name
+		for alias := range i.Aliases {
+			description.Aliases = append(description.Aliases, alias)
+		}
+		sort.Strings(description.Aliases)
+
+		fields := make(map[string]fieldMapping, len(i.Mappings.Properties))
+		flatten("", i.Mappings.Properties, fields)
+		description.Fields = make(map[string]string, len(fields))
+		description.Analyzers = make(map[string]string)
+		for field, mapping := range fields {
+			description.Fields[field] = mapping.Type
+			if mapping.Analyzer != "" {
+				description.Analyzers[field] = mapping.Analyzer
+			}
+			if mapping.Type == "knn_vector" && mapping.Method != nil {
+				description.Settings.Dimension = mapping.Dimension
+				description.Settings.SpaceType = mapping.Method.SpaceType
+				description.Settings.Engine = mapping.Method.Engine
+				description.Settings.M = mapping.Method.Parameters.M
+				description.Settings.EfConstruction = mapping.Method.Parameters.EfConstruction
+			}
+		}
+		if efSearch, ok := i.Settings["index.knn.algo_param.ef_search"].(string); ok {
+			description.Settings.EfSearch, _ = strconv.Atoi(efSearch)
+		}
+	}
+
+	var count struct {
+		Count int `json:"count"`
+	}
+	err = do(ctx, client, opensearchapi.CountRequest{Index: []string{index}}, &count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count documents in index %s: %w", index, err)
+	}
+	description.DocCount = count.Count
+
+	return &description, nil
+}
+
+// CheckMapping makes sure an existing index can hold documents with the given settings. Only the vector
+// dimension and space type, and the types of the metadata fields, are checked. The engine and other knn parameters
+// only affect how it's searched, and searches read the engine from the index itself
+func CheckMapping(description *IndexDescription, settings IndexSettings) error {
+	var mismatches []string
+	if description.Settings.Dimension != settings.Dimension {
+		mismatches = append(mismatches, fmt.Sprintf("vector dimension is %d, expected %d", description.Settings.Dimension, settings.Dimension))
+	}
+	if description.Settings.SpaceType != settings.SpaceType {
+		mismatches = append(mismatches, fmt.Sprintf("space type is %q, expected %q", description.Settings.SpaceType, settings.SpaceType))
+	}
+	expectedFields := make(map[string]fieldMapping, len(metadataFields))
+	flatten("", metadataFields, expectedFields)
+	fields := make([]string, 0, len(expectedFields))
+	for field := range expectedFields {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		if actual := description.Fields[field]; actual != expectedFields[field].Type {
+			mismatches = append(mismatches, fmt.Sprintf("field %s is mapped as %q, expected %q", field, actual, expectedFields[field].Type))
+		}
+		if expected := expectedFields[field].Analyzer; expected != "" && description.Analyzers[field] != expected {
+			mismatches = append(mismatches, fmt.Sprintf("field %s is analyzed with %q, expected %q", field, description.Analyzers[field], expected))
+		}
+	}
+
+	if len(mismatches) > 0 {
+		return fmt.Errorf("index %s does not match the expected mapping, it should be recreated: %s: %w", description.Name, strings.Join(mismatches, "; "), ErrMappingMismatch)
+	}
+	return nil
+}
+
+// EnsureIndex creates the index if it doesn't exist, or checks that its mapping matches the settings if it does
+func EnsureIndex(ctx context.Context, client *opensearch.Client, index string, settings IndexSettings) error {
+	description, err := DescribeIndex(ctx, client, index)
+	if err != nil {
+		return err
+	}
+	if description == nil {
+		return CreateIndex(ctx, client, index, settings)
+	}
+	return CheckMapping(description, settings)
+}
+
+// engineCacheTTL is how long the engine of an index is remembered, so a rebuild or rollback onto a different
+// engine is picked up by a running service
+const engineCacheTTL = time.Minute
+
+type cachedEngine struct {
+	engine  string
+	checked time.Time
+}
+
+// engines caches the knn engine of each index or alias searched
+var engines sync.Map
+
+// filtersWhileSearching is true for the knn engines that can apply a filter during the search itself
+func filtersWhileSearching(engine string) bool {
+	return engine == "lucene" || engine == "faiss"
+}
+
+// indexEngine reads the knn engine the vectors of an index or alias were built with. Indexes from before the
+// engine was configurable have no method in their mapping, and were built with nmslib
+func indexEngine(ctx context.Context, client *opensearch.Client, index string) (string, error) {
+	if cached, ok := engines.Load(index); ok && time.Since(cached.(cachedEngine).checked) < engineCacheTTL {
+		return cached.(cachedEngine).engine, nil
+	}
+
+	var mappings map[string]struct {
+		Mappings mappingBody `json:"mappings"`
+	}
+	err := do(ctx, client, opensearchapi.IndicesGetMappingRequest{Index: []string{index}}, &mappings)
+	if err != nil {
+		return "", fmt.Errorf("failed to read the mapping of index %s: %w", index, err)
+	}
+	engine := "nmslib"
+	for _, m := range mappings {
+		if method := m.Mappings.Properties["vector_data"].Method; method != nil && method.Engine != "" {
+			engine = method.Engine
+		}
+	}
+	engines.Store(index, cachedEngine{engine: engine, checked: time.Now()})
+	return engine, nil
+}
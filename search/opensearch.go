@@ -0,0 +1,175 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// Errors from opensearch are classified as one of these where possible, so callers can tell them apart with
+// errors.Is
+var (
+	// ErrNotFound is an index, alias or document that doesn't exist
+	ErrNotFound = errors.New("not found in opensearch")
+	// ErrMappingMismatch is an index whose mapping can't hold the documents, it needs to be recreated
+	ErrMappingMismatch = errors.New("index mapping mismatch")
+	// ErrClusterUnavailable is opensearch being unreachable or too overloaded to answer
+	ErrClusterUnavailable = errors.New("opensearch cluster unavailable")
+)
+
+// ResponseError is an error response from opensearch
+type ResponseError struct {
+	Status int
+	Type   string
+	Reason string
+}
+
+func (e *ResponseError) Error() string {
+	if e.Type == "" {
+		return fmt.Sprintf("opensearch responded %d: %s", e.Status, e.Reason)
+	}
+	return fmt.Sprintf("opensearch responded %d %s: %s", e.Status, e.Type, e.Reason)
+}
+
+// Is classifies the response as one of ErrNotFound, ErrMappingMismatch or ErrClusterUnavailable
+func (e *ResponseError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound || e.Type == "index_not_found_exception"
+	case ErrMappingMismatch:
+		return isMappingError(e.Type, e.Reason)
+	case ErrClusterUnavailable:
+		return retryableStatus(e.Status) || e.Type == "cluster_block_exception" || e.Type == "no_shard_available_action_exception"
+	}
+	return false
+}
+
+// isMappingError is true for documents or queries that don't fit the mapping of the index, including vectors of
+// the wrong dimension, which the knn plugin reports as an illegal argument
+func isMappingError(errorType string, reason string) bool {
+	switch errorType {
+	case "mapper_parsing_exception", "strict_dynamic_mapping_exception", "document_parsing_exception":
+		return true
+	case "illegal_argument_exception":
+		return strings.Contains(strings.ToLower(reason), "dimension")
+	}
+	return false
+}
+
+// responseError reads the error out of a failed response, which is usually a JSON object with an error type and
+// reason but sometimes just a message
+func responseError(response *opensearchapi.Response) error {
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		return &ResponseError{Status: response.StatusCode, Reason: fmt.Sprintf("failed to read response body: %s", err)}
+	}
+
+	var errorBody struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if json.Unmarshal(body, &errorBody) != nil || len(errorBody.Error) == 0 {
+		return &ResponseError{Status: response.StatusCode, Reason: string(body)}
+	}
+	var cause struct {
+		Type      string `json:"type"`
+		Reason    string `json:"reason"`
+		RootCause []struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"root_cause"`
+	}
+	if json.Unmarshal(errorBody.Error, &cause) != nil {
+		var message string
+		_ = json.Unmarshal(errorBody.Error, &message)
+		return &ResponseError{Status: response.StatusCode, Reason: message}
+	}
+	// Search errors wrap the actual cause in a generic search_phase_execution_exception
+	if len(cause.RootCause) > 0 && cause.Type == "search_phase_execution_exception" {
+		return &ResponseError{Status: response.StatusCode, Type: cause.RootCause[0].Type, Reason: cause.RootCause[0].Reason}
+	}
+	return &ResponseError{Status: response.StatusCode, Type: cause.Type, Reason: cause.Reason}
+}
+
+// request is any of the opensearchapi requests
+type request interface {
+	Do(ctx context.Context, transport opensearchapi.Transport) (*opensearchapi.Response, error)
+}
+
+// do sends a request and decodes a successful response into result, which can be nil if the response doesn't
+// matter. The response body is always closed, and errors are classified where possible
+func do(ctx context.Context, client *opensearch.Client, r request, result any) error {
+	response, err := r.Do(ctx, client)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrClusterUnavailable, err)
+	}
+	defer response.Body.Close()
+	if response.IsError() {
+		return responseError(response)
+	}
+	if result == nil {
+		return nil
+	}
+	err = json.NewDecoder(response.Body).Decode(result)
+	if err != nil {
+		return fmt.Errorf("failed to parse response: %w", err)
+	}
+	return nil
+}
+
+// searchResponse is the part of a search response that's used, the hits and the buckets of any aggregations
+type searchResponse struct {
+	Hits struct {
+		Hits []struct {
+			Id          string          `json:"_id"`
+			Score       float64         `json:"_score"`
+			Source      Document        `json:"_source"`
+			Explanation json.RawMessage `json:"_explanation"`
+			Highlight   struct {
+				Transcript []string `json:"Transcript"`
+			} `json:"highlight"`
+		} `json:"hits"`
+	} `json:"hits"`
+	Aggregations map[string]struct {
+		Buckets []bucket `json:"buckets"`
+	} `json:"aggregations"`
+}
+
+type bucket struct {
+	Key      string `json:"key"`
+	DocCount int    `json:"doc_count"`
+}
+
+func (r *searchResponse) hits() []SearchHit {
+	hits := make([]SearchHit, len(r.Hits.Hits))
+	for i, h := range r.Hits.Hits {
+		hits[i] = SearchHit{
+			Document:    h.Source,
+			Score:       h.Score,
+			Highlights:  h.Highlight.Transcript,
+			Explanation: h.Explanation,
+		}
+	}
+	return hits
+}
+
+// executeSearch runs a search request against an index
+func executeSearch(ctx context.Context, client *opensearch.Client, index string, request searchRequest) (*searchResponse, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal query: %w", err)
+	}
+	var response searchResponse
+	err = do(ctx, client, opensearchapi.SearchRequest{Index: []string{index}, Body: bytes.NewReader(body)}, &response)
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
@@ -0,0 +1,145 @@
+package search
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+// MaxPagedResults is as deep as pagination goes, the knn search has to find the results of every page up to the
+// one asked for each time
+const MaxPagedResults = 500
+
+// ErrInvalidCursor is a page cursor that wasn't made by a previous page
+var ErrInvalidCursor = errors.New("invalid page cursor")
+
+// Page is one page of search results
+type Page struct {
+	Hits []SearchHit
+	// Next is the cursor for the following page, it's empty on the last page
+	Next string
+}
+
+// cursor is the position after the last hit of a page. Hits are ordered by score and then Id, so it's stable for
+// the same search even when scores tie
+type cursor struct {
+	Score float64
+	Id    string
+	// Seen is how many hits came before, so ranks carry on from one page to the next
+	Seen int
+}
+
+func (c cursor) String() string {
+	cursorBytes, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(cursorBytes)
+}
+
+// parseCursor reads a cursor from a previous page, an empty cursor is the first page
+func parseCursor(value string) (*cursor, error) {
+	if value == "" {
+		return nil, nil
+	}
+	cursorBytes, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	var c cursor
+	err = json.Unmarshal(cursorBytes, &c)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidCursor, err)
+	}
+	return &c, nil
+}
+
+// after is true if a hit comes after the cursor
+func (c *cursor) after(hit SearchHit) bool {
+	return c == nil || hit.Score < c.Score || (hit.Score == c.Score && hit.Id > c.Id)
+}
+
+// newPage ranks a page of hits following the cursor, and makes the cursor for the next page
+func newPage(hits []SearchHit, size int, after *cursor) *Page {
+	seen := 0
+	if after != nil {
+		seen = after.Seen
+	}
+	ranked(hits)
+	for i := range hits {
+		hits[i].Rank += seen
+	}
+
+	page := &Page{Hits: hits}
+	if len(hits) == size && seen+size < MaxPagedResults {
+		last := hits[len(hits)-1]
+		page.Next = cursor{Score: last.Score, Id: last.Id, Seen: seen + size}.String()
+	}
+	return page
+}
+
+// byScore orders hits by score and then by Id, the same way for both stores
+func byScore(a, b SearchHit) int {
+	switch {
+	case a.Score > b.Score:
+		return -1
+	case a.Score < b.Score:
+		return 1
+	case a.Id < b.Id:
+		return -1
+	case a.Id > b.Id:
+		return 1
+	}
+	return 0
+}
+
+// QueryPage finds the transcript chunks nearest to the query vector a page at a time, starting after the cursor
+// from the previous page. Synthetic questions are left out, since collapsing them into their chunks would shift
+// results between pages
+func QueryPage(ctx context.Context, client *opensearch.Client, index string, queryVector []float32, size int, after string, filter Filter) (*Page, error) {
+	c, err := parseCursor(after)
+	if err != nil {
+		return nil, err
+	}
+
+	q, err := vectorQuery(ctx, client, index, queryVector, MaxPagedResults, notChunks, filter)
+	if err != nil {
+		return nil, err
+	}
+	request := searchRequest{
+		Size:        size,
+		Query:       &q,
+		Explain:     explaining(ctx),
+		Sort:        []sortClause{{Score: "desc"}, {Id: "asc"}},
+		TrackScores: true,
+	}
+	if c != nil {
+		request.SearchAfter = []any{c.Score, c.Id}
+	}
+	hits, err := runSearch(ctx, client, index, request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute vector query: %w", err)
+	}
+	return newPage(hits, size, c), nil
+}
+
+// MoreLikeThis finds the transcript chunks from other episodes nearest to the stored vector of a document
+func MoreLikeThis(ctx context.Context, client *opensearch.Client, index string, id string, size int, filter Filter) ([]SearchHit, error) {
+	documents, err := loadDocuments(ctx, client, index)([]string{id})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load document %s: %w", id, err)
+	}
+	if len(documents) == 0 {
+		return nil, fmt.Errorf("document %s: %w", id, ErrNotFound)
+	}
+
+	// The episode of the document is left out after the knn search rather than filtered out in it, which every
+	// engine can do. Its own chunks are likely the nearest, so K allows for every document of the episode as well
+	exclude := otherEpisodes(notChunks, documents[0].GUID)
+	matches, err := queryEmbedding(ctx, client, index, documents[0].Vectors, size, size+maxEpisodeDocuments, exclude, filter)
+	if err != nil {
+		return nil, err
+	}
+	return ranked(matches), nil
+}
@@ -0,0 +1,141 @@
+package search
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestParseCursor(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		cursor *cursor
+		err    error
+	}{
+		{
+			name:   "round trip",
+			value:  cursor{Score: 1.25, Id: "abc_chunk_3", Seen: 20}.String(),
+			cursor: &cursor{Score: 1.25, Id: "abc_chunk_3", Seen: 20},
+		},
+		{
+			name:  "empty is the first page",
+			value: "",
+		},
+		{
+			name:  "not base64",
+			value: "not a cursor!",
+			err:   ErrInvalidCursor,
+		},
+		{
+			name:  "not json",
+			value: base64.RawURLEncoding.EncodeToString([]byte("score=1")),
+			err:   ErrInvalidCursor,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := parseCursor(test.value)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("got error %v, expected %v", err, test.err)
+			}
+			if !reflect.DeepEqual(c, test.cursor) {
+				t.Errorf("got %+v, expected %+v", c, test.cursor)
+			}
+		})
+	}
+}
+
+func TestCursorAfter(t *testing.T) {
+	c := &cursor{Score: 0.5, Id: "b"}
+	tests := []struct {
+		name   string
+		cursor *cursor
+		hit    SearchHit
+		after  bool
+	}{
+		{name: "no cursor", cursor: nil, hit: scoredHit("a", 0.9), after: true},
+		{name: "higher score", cursor: c, hit: scoredHit("z", 0.9), after: false},
+		{name: "lower score", cursor: c, hit: scoredHit("a", 0.1), after: true},
+		{name: "same score and lower id", cursor: c, hit: scoredHit("a", 0.5), after: false},
+		{name: "the cursor's own hit", cursor: c, hit: scoredHit("b", 0.5), after: false},
+		{name: "same score and higher id", cursor: c, hit: scoredHit("c", 0.5), after: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if after := test.cursor.after(test.hit); after != test.after {
+				t.Errorf("got %t, expected %t", after, test.after)
+			}
+		})
+	}
+}
+
+func TestNewPage(t *testing.T) {
+	tests := []struct {
+		name  string
+		hits  []SearchHit
+		size  int
+		after *cursor
+		ranks []int
+		next  *cursor
+	}{
+		{
+			name:  "full first page",
+			hits:  []SearchHit{scoredHit("a", 0.9), scoredHit("b", 0.8)},
+			size:  2,
+			ranks: []int{1, 2},
+			next:  &cursor{Score: 0.8, Id: "b", Seen: 2},
+		},
+		{
+			name:  "ranks carry on from the cursor",
+			hits:  []SearchHit{scoredHit("c", 0.7), scoredHit("d", 0.6)},
+			size:  2,
+			after: &cursor{Score: 0.8, Id: "b", Seen: 2},
+			ranks: []int{3, 4},
+			next:  &cursor{Score: 0.6, Id: "d", Seen: 4},
+		},
+		{
+			name:  "short page is the last",
+			hits:  []SearchHit{scoredHit("e", 0.5)},
+			size:  2,
+			after: &cursor{Score: 0.6, Id: "d", Seen: 4},
+			ranks: []int{5},
+		},
+		{
+			name:  "no page past MaxPagedResults",
+			hits:  []SearchHit{scoredHit("y", 0.2), scoredHit("z", 0.1)},
+			size:  2,
+			after: &cursor{Score: 0.3, Id: "x", Seen: MaxPagedResults - 2},
+			ranks: []int{MaxPagedResults - 1, MaxPagedResults},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			page := newPage(test.hits, test.size, test.after)
+			ranks := make([]int, len(page.Hits))
+			for i := range page.Hits {
+				ranks[i] = page.Hits[i].Rank
+			}
+			if !reflect.DeepEqual(ranks, test.ranks) {
+				t.Errorf("got ranks %v, expected %v", ranks, test.ranks)
+			}
+			next, err := parseCursor(page.Next)
+			if err != nil {
+				t.Fatalf("next page cursor %q: %v", page.Next, err)
+			}
+			if !reflect.DeepEqual(next, test.next) {
+				t.Errorf("got next page %+v, expected %+v", next, test.next)
+			}
+		})
+	}
+}
+
+func TestByScore(t *testing.T) {
+	hits := []SearchHit{scoredHit("c", 0.5), scoredHit("a", 0.1), scoredHit("b", 0.5), scoredHit("d", 0.9)}
+	slices.SortFunc(hits, byScore)
+	if ids := hitIds(hits); !reflect.DeepEqual(ids, []string{"d", "b", "c", "a"}) {
+		t.Errorf("got %v, expected [d b c a]", ids)
+	}
+}
@@ -0,0 +1,152 @@
+package search
+
+// The opensearch query DSL is deeply nested JSON keyed by clause type, these types build it up without resorting
+// to maps or anonymous structs
+
+// query is one clause of the opensearch query DSL, only one of its fields should be set
+type query struct {
+	Knn         *knnSearch         `json:"knn,omitempty"`
+	Terms       *termsSearch       `json:"terms,omitempty"`
+	Bool        *boolSearch        `json:"bool,omitempty"`
+	ScriptScore *scriptScoreSearch `json:"script_score,omitempty"`
+	Match       *matchSearch       `json:"match,omitempty"`
+	MatchPhrase *matchPhraseSearch `json:"match_phrase,omitempty"`
+	Range       *rangeSearch       `json:"range,omitempty"`
+	Wildcard    *wildcardSearch    `json:"wildcard,omitempty"`
+}
+
+type termsSearch struct {
+	Ids      []string `json:"_id,omitempty"`
+	GUID     []string `json:"GUID,omitempty"`
+	DocType  []string `json:"DocType,omitempty"`
+	Feed     []string `json:"Feed,omitempty"`
+	Speakers []string `json:"Speakers,omitempty"`
+	Topics   []string `json:"Topics,omitempty"`
+	Entities []string `json:"Entities,omitempty"`
+	VectorId []int    `json:"VectorId,omitempty"`
+	// LinkDomains matches the domains of the links in the show notes
+	LinkDomains []string `json:"Links.Domain,omitempty"`
+}
+
+type boolSearch struct {
+	Must    []query `json:"must,omitempty"`
+	Should  []query `json:"should,omitempty"`
+	Filter  []query `json:"filter,omitempty"`
+	MustNot []query `json:"must_not,omitempty"`
+}
+
+// scriptScoreSearch is an exact knn search over only the documents matching its query, which is better suited to
+// searching small filtered sets of documents than the approximate knn query
+type scriptScoreSearch struct {
+	Query  query     `json:"query"`
+	Script knnScript `json:"script"`
+}
+
+type knnScript struct {
+	Source string          `json:"source"`
+	Lang   string          `json:"lang"`
+	Params knnScriptParams `json:"params"`
+}
+
+type knnScriptParams struct {
+	Field      string    `json:"field"`
+	QueryValue []float32 `json:"query_value"`
+	SpaceType  string    `json:"space_type"`
+}
+
+// notSummaries excludes summary documents from searches meant for transcript chunks. Chunks indexed before there
+// were summaries don't have a DocType, so this can't just filter for chunks
+var notSummaries = query{Terms: &termsSearch{DocType: []string{DocTypeSummary, DocTypeChapter}}}
+
+// notChunks excludes everything but transcript chunks
+var notChunks = query{Terms: &termsSearch{DocType: []string{DocTypeSummary, DocTypeChapter, DocTypeQuestion}}}
+
+func exactKnn(filter query, queryVector []float32) query {
+	return query{
+		ScriptScore: &scriptScoreSearch{
+			Query: filter,
+			Script: knnScript{
+				Source: "knn_score",
+				Lang:   "knn",
+				Params: knnScriptParams{
+					Field:      "vector_data",
+					QueryValue: queryVector,
+					SpaceType:  "cosinesimil",
+				},
+			},
+		},
+	}
+}
+
+type knnSearch struct {
+	vectorData `json:"vector_data"`
+}
+
+type vectorData struct {
+	Vector []float32 `json:"vector"`
+	K      int       `json:"k"`
+	// Filter is applied while searching rather than to the K nearest neighbors afterwards, which needs the lucene
+	// or faiss engine
+	Filter *query `json:"filter,omitempty"`
+}
+
+type matchSearch struct {
+	Transcript *matchQuery `json:"Transcript,omitempty"`
+	LinkText   *matchQuery `json:"Links.Text,omitempty"`
+}
+
+type matchQuery struct {
+	Query string `json:"query"`
+	// Operator is and to only match text with every word of the query, rather than any of them
+	Operator string `json:"operator,omitempty"`
+}
+
+// matchPhraseSearch matches the words of a phrase in order, with up to Slop other words in between them
+type matchPhraseSearch struct {
+	Transcript *matchPhraseQuery `json:"Transcript,omitempty"`
+}
+
+type matchPhraseQuery struct {
+	Query string `json:"query"`
+	Slop  int    `json:"slop,omitempty"`
+}
+
+// searchRequest is the body of a search, Query can be left out to run only aggregations
+type searchRequest struct {
+	Size      int                    `json:"size"`
+	Query     *query                 `json:"query,omitempty"`
+	Explain   bool                   `json:"explain,omitempty"`
+	Highlight *highlight             `json:"highlight,omitempty"`
+	Aggs      map[string]aggregation `json:"aggs,omitempty"`
+	// Sort replaces ordering by score, TrackScores keeps the scores anyway, and SearchAfter starts after the hit with
+	// these sort values
+	Sort        []sortClause `json:"sort,omitempty"`
+	TrackScores bool         `json:"track_scores,omitempty"`
+	SearchAfter []any        `json:"search_after,omitempty"`
+	// MinScore leaves out hits scoring less
+	MinScore float64 `json:"min_score,omitempty"`
+	// Source limits the fields of the documents returned to these, rather than all of them
+	Source []string `json:"_source,omitempty"`
+}
+
+type sortClause struct {
+	Score string `json:"_score,omitempty"`
+	Id    string `json:"Id,omitempty"`
+}
+
+type highlight struct {
+	Fields highlightFields `json:"fields"`
+}
+
+type highlightFields struct {
+	Transcript *struct{} `json:"Transcript,omitempty"`
+}
+
+type aggregation struct {
+	Terms *termsAggregation `json:"terms,omitempty"`
+}
+
+type termsAggregation struct {
+	Field string `json:"field"`
+	Size  int    `json:"size"`
+}
@@ -0,0 +1,159 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+const (
+	// DefaultQuoteSlop lets a phrase match with a word or two said in between, transcripts of speech are rarely
+	// word perfect
+	DefaultQuoteSlop = 1
+	// quoteContextWords is how many words either side of a phrase are included with it
+	quoteContextWords = 15
+)
+
+// Quote is one place a phrase was said
+type Quote struct {
+	GUID        string
+	Title       string
+	Link        string
+	PublishedAt *time.Time `json:",omitempty"`
+	// Id is the chunk the phrase was found in
+	Id string
+	// Start and End are the words of the transcript the phrase covers, End is exclusive
+	Start int
+	End   int
+	// Context is the phrase along with the words around it, with the phrase in <em> tags
+	Context string
+	// Playback is how far into the recording the phrase was said, in seconds, for episodes transcribed with
+	// timestamps
+	Playback *float64 `json:",omitempty"`
+}
+
+func (q Quote) String() string {
+	if q.Playback != nil {
+		seconds := int(*q.Playback)
+		return fmt.Sprintf("%s at %d:%02d:%02d (words %d-%d of %s): %s", q.Title, seconds/3600, seconds/60%60, seconds%60, q.Start, q.End, q.GUID, q.Context)
+	}
+	return fmt.Sprintf("%s (words %d-%d of %s): %s", q.Title, q.Start, q.End, q.GUID, q.Context)
+}
+
+// QueryQuotes finds every place a phrase was said, allowing up to slop words in between its words. Opensearch
+// finds the chunks containing the phrase and the occurrences are then picked out of their text, so the words have
+// to match exactly besides case and punctuation
+func QueryQuotes(ctx context.Context, client *opensearch.Client, index string, phrase string, slop int, filter Filter) ([]Quote, error) {
+	if len(tokenize(phrase)) == 0 {
+		return nil, fmt.Errorf("a phrase to search for is required")
+	}
+
+	// Only transcript chunks have any transcript text, so there's nothing else to exclude
+	phraseQuery := filter.withFilter(query{MatchPhrase: &matchPhraseSearch{Transcript: &matchPhraseQuery{Query: phrase, Slop: slop}}})
+	hits, err := searchAll(ctx, client, index, searchRequest{Query: &phraseQuery})
+	chunks := Documents(hits)
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute phrase query: %w", err)
+	}
+	return findQuotes(chunks, phrase, slop), nil
+}
+
+// findQuotes picks every occurrence of the phrase out of the chunks, in order of publication and then position in
+// the transcript. Chunks overlap, so an occurrence found in more than one chunk is only returned once
+func findQuotes(chunks []Document, phrase string, slop int) []Quote {
+	terms := tokenize(phrase)
+	seen := make(map[string]bool)
+	var quotes []Quote
+	for _, chunk := range chunks {
+		words := strings.Fields(chunk.Transcript)
+		// Words like "don't" are more than one token, so keep track of which word each token came from
+		var tokens []string
+		var tokenWords []int
+		for i, word := range words {
+			for _, token := range tokenize(word) {
+				tokens = append(tokens, token)
+				tokenWords = append(tokenWords, i)
+			}
+		}
+
+		for i := 0; i < len(tokens); i++ {
+			last, ok := matchPhrase(tokens, i, terms, slop)
+			if !ok {
+				continue
+			}
+			start, end := tokenWords[i], tokenWords[last]+1
+			key := fmt.Sprintf("%s-%d", chunk.GUID, chunk.Offset+start)
+			i = last
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+
+			quote := Quote{
+				GUID:        chunk.GUID,
+				Title:       chunk.Title,
+				Link:        chunk.Link,
+				PublishedAt: chunk.PublishedAt,
+				Id:          chunk.Id,
+				Start:       chunk.Offset + start,
+				End:         chunk.Offset + end,
+				Context:     quoteContext(words, start, end),
+			}
+			if playback, ok := chunk.EpisodeData.Playback(quote.Start); ok {
+				quote.Playback = &playback
+			}
+			quotes = append(quotes, quote)
+		}
+	}
+
+	slices.SortStableFunc(quotes, func(a, b Quote) int {
+		switch {
+		case a.PublishedAt != nil && b.PublishedAt != nil && !a.PublishedAt.Equal(*b.PublishedAt):
+			return a.PublishedAt.Compare(*b.PublishedAt)
+		case (a.PublishedAt == nil) != (b.PublishedAt == nil):
+			// Episodes without a publication date go last
+			if a.PublishedAt == nil {
+				return 1
+			}
+			return -1
+		case a.GUID != b.GUID:
+			return strings.Compare(a.GUID, b.GUID)
+		}
+		return a.Start - b.Start
+	})
+	return quotes
+}
+
+// matchPhrase checks whether the terms appear in order from token start, with up to slop other tokens between
+// them in total, returning the position of the last term
+func matchPhrase(tokens []string, start int, terms []string, slop int) (int, bool) {
+	if tokens[start] != terms[0] {
+		return 0, false
+	}
+	position := start
+	for _, term := range terms[1:] {
+		next := position + 1
+		for next < len(tokens) && next-position-1 <= slop && tokens[next] != term {
+			next++
+		}
+		if next >= len(tokens) || next-position-1 > slop {
+			return 0, false
+		}
+		slop -= next - position - 1
+		position = next
+	}
+	return position, true
+}
+
+// quoteContext is the words from start to end in <em> tags, along with a few words either side of them
+func quoteContext(words []string, start int, end int) string {
+	from, until := max(0, start-quoteContextWords), min(len(words), end+quoteContextWords)
+	fragment := slices.Clone(words[from:until])
+	fragment[start-from] = "<em>" + fragment[start-from]
+	fragment[end-1-from] += "</em>"
+	return strings.Join(fragment, " ")
+}
@@ -0,0 +1,90 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+// maxEpisodeDocuments is more than the chunks and summary of even the longest episode
+const maxEpisodeDocuments = 1000
+
+// RelatedEpisodes finds the episodes most like an episode, returning the best matching document of each. An
+// episode with a summary is compared to the summaries of the others, otherwise the centroid of its chunks is
+// compared to their chunks. Returns ErrNotFound if the episode has no documents
+func RelatedEpisodes(ctx context.Context, client *opensearch.Client, index string, GUID string, size int, filter Filter) ([]SearchHit, error) {
+	documents, err := searchDocuments(ctx, client, index, maxEpisodeDocuments, query{
+		Bool: &boolSearch{
+			Filter:  []query{{Terms: &termsSearch{GUID: []string{GUID}}}},
+			MustNot: []query{{Terms: &termsSearch{DocType: []string{DocTypeChapter, DocTypeQuestion}}}},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load documents of episode %s: %w", GUID, err)
+	}
+	vector, summary := episodeVector(documents)
+	if vector == nil {
+		return nil, fmt.Errorf("episode %s: %w", GUID, ErrNotFound)
+	}
+
+	if summary {
+		others := filter
+		others.ExcludeGUIDs = append(slices.Clone(filter.ExcludeGUIDs), GUID)
+		episodes, err := QueryEpisodes(ctx, client, index, vector, size, others)
+		if err != nil || len(episodes) > 0 {
+			return episodes, err
+		}
+		// None of the other episodes have been summarized, so fall back to their chunks
+	}
+	// Several chunks of each episode will match, so over fetch to get enough distinct episodes. The episode itself
+	// is left out after the knn search, and its chunks are the nearest to their own centroid, so K allows for them
+	chunks, err := queryEmbedding(ctx, client, index, vector, size*10, size*10+maxEpisodeDocuments, otherEpisodes(notChunks, GUID), filter)
+	if err != nil {
+		return nil, err
+	}
+	return ranked(bestPerEpisode(chunks, size)), nil
+}
+
+// episodeVector picks the vector standing in for a whole episode, the vector of its summary if it has one and
+// otherwise the centroid of its chunks. summary is true for a summary vector, and the vector is nil if there are
+// no chunks either
+func episodeVector(documents []Document) (vector []float32, summary bool) {
+	var chunks [][]float32
+	for i := range documents {
+		switch {
+		case documents[i].DocType == DocTypeSummary && len(documents[i].Vectors) > 0:
+			return documents[i].Vectors, true
+		case isChunk(&documents[i]) && len(documents[i].Vectors) > 0:
+			chunks = append(chunks, documents[i].Vectors)
+		}
+	}
+	return centroid(chunks), false
+}
+
+// centroid is the mean of the vectors, or nil if there aren't any
+func centroid(vectors [][]float32) []float32 {
+	if len(vectors) == 0 {
+		return nil
+	}
+	sum := make([]float64, len(vectors[0]))
+	for _, vector := range vectors {
+		if len(vector) != len(sum) {
+			continue
+		}
+		for i, v := range vector {
+			sum[i] += float64(v)
+		}
+	}
+	mean := make([]float32, len(sum))
+	for i := range sum {
+		mean[i] = float32(sum[i] / float64(len(vectors)))
+	}
+	return mean
+}
+
+// otherEpisodes leaves out an episode as well as whatever exclude does, for use as the exclude of a knnQuery
+func otherEpisodes(exclude query, GUID string) query {
+	return query{Bool: &boolSearch{Should: []query{exclude, {Terms: &termsSearch{GUID: []string{GUID}}}}}}
+}
@@ -1,15 +1,13 @@
 package search
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"fmt"
-	"io"
+	"time"
 
 	"github.com/opensearch-project/opensearch-go"
-	"github.com/opensearch-project/opensearch-go/opensearchapi"
 
+	"oxide-search/embedding"
 	"oxide-search/manifest"
 )
 
@@ -17,154 +15,272 @@ const (
 	indexName = "oxide"
 )
 
+// IndexName returns the alias of the index holding documents embedded by a model, each model gets its own index so
+// they can be evaluated side by side. The original model keeps the original index name. Searches should only ever
+// go through this alias, never a particular version of the index (see IndexVersions)
+func IndexName(model string) string {
+	if model == embedding.DefaultModel {
+		return indexName
+	}
+	return fmt.Sprintf("%s-%s", indexName, model)
+}
+
+// Documents in the index are either chunks of a transcript, summaries of a whole episode or one of its chapters,
+// or synthetic questions pointing back to the chunk that answers them
+const (
+	DocTypeChunk    = "chunk"
+	DocTypeSummary  = "summary"
+	DocTypeChapter  = "chapter"
+	DocTypeQuestion = "question"
+)
+
 type Document struct {
 	Id string
 	manifest.EpisodeData
-	VectorId int
+	PublishedAt *time.Time `json:",omitempty"`
+	DocType     string
+	VectorId    int
+	// Offset is the position in the transcript of the first word of a chunk
+	Offset int `json:",omitempty"`
+	// Summary and Chapter are only set on summary and chapter documents
+	Summary string `json:",omitempty"`
+	Chapter string `json:",omitempty"`
+	// Question and ParentId are only set on question documents, ParentId is the Id of the chunk they came from
+	Question string `json:",omitempty"`
+	ParentId string `json:",omitempty"`
+	// Topics are the labels of the topic a chunk or question is about, or of the main topics of an episode on its
+	// summaries, once the topics command has clustered the chunks
+	Topics []string `json:",omitempty"`
+	// Entities are the names of the companies, people, products, projects and papers named in a chunk or question,
+	// or anywhere in an episode on its summaries, once the entities command has extracted them
+	Entities []string  `json:",omitempty"`
 	Vectors  []float32 `json:"vector_data"`
 }
 
-// Opensearch API is stupid :(
-type query struct {
-	Knn   *knnSearch   `json:"knn,omitempty"`
-	Terms *termsSearch `json:"terms,omitempty"`
-}
-
-type termsSearch struct {
-	Ids []string `json:"_id,omitempty"`
+// QueryEmbedding finds the transcript chunks nearest to the query vector, either directly or through one of the
+// synthetic questions generated for them
+func QueryEmbedding(ctx context.Context, client *opensearch.Client, index string, queryVector []float32, size int, K int, filter Filter) ([]SearchHit, error) {
+	// Several questions from the same chunk may match, so over fetch a bit before collapsing them
+	matches, err := queryEmbedding(ctx, client, index, queryVector, size*2, K, notSummaries, filter)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := collapseQuestions(matches, size, loadDocuments(ctx, client, index))
+	return ranked(chunks), err
 }
 
-type knnSearch struct {
-	vectorData `json:"vector_data"`
+// QueryChunks finds the transcript chunks nearest to the query vector, ignoring any synthetic questions
+func QueryChunks(ctx context.Context, client *opensearch.Client, index string, queryVector []float32, size int, K int, filter Filter) ([]SearchHit, error) {
+	matches, err := queryEmbedding(ctx, client, index, queryVector, size, K, notChunks, filter)
+	return ranked(matches), err
 }
 
-type vectorData struct {
-	Vector []float32 `json:"vector"`
-	K      int       `json:"k"`
+// loadDocuments fetches documents by their ids
+func loadDocuments(ctx context.Context, client *opensearch.Client, index string) func(ids []string) ([]Document, error) {
+	return func(ids []string) ([]Document, error) {
+		return searchDocuments(ctx, client, index, len(ids), query{Terms: &termsSearch{Ids: ids}})
+	}
 }
 
-// AddNearbySegments makes an additional search query for any context near matching embeddings to add additional conversational context
-func AddNearbySegments(ctx context.Context, client *opensearch.Client, sources []Document, maxSegments int) ([]Document, error) {
-	var nearbyIds = make([]string, 0)
+// collapseQuestions replaces any question documents with the chunk they were generated from, keeping the order,
+// score and matching question of the best match for each chunk. load fetches the chunks by their ids
+func collapseQuestions(matches []SearchHit, size int, load func(ids []string) ([]Document, error)) ([]SearchHit, error) {
+	chunks := make(map[string]SearchHit)
+	var order []string
+	var parents []string
+	for _, match := range matches {
+		id := match.Id
+		if match.DocType == DocTypeQuestion {
+			id = match.ParentId
+		}
+		if _, seen := chunks[id]; seen {
+			continue
+		}
+		if match.DocType == DocTypeQuestion {
+			parents = append(parents, id)
+			match.MatchedQuestion = match.Question
+		}
+		chunks[id] = match
+		order = append(order, id)
+	}
 
-	for _, source := range sources {
-		nearbyIds = append(nearbyIds, fmt.Sprintf("episode-%s-embedding-%d", source.EpisodeData.GUID, source.VectorId+1))
-		if source.VectorId > 0 {
-			nearbyIds = append(nearbyIds, fmt.Sprintf("episode-%s-embedding-%d", source.EpisodeData.GUID, source.VectorId-1))
+	if len(parents) > 0 {
+		parentDocuments, err := load(parents)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load chunks for matching questions: %w", err)
+		}
+		for _, parent := range parentDocuments {
+			match := chunks[parent.Id]
+			match.Document = parent
+			chunks[parent.Id] = match
 		}
 	}
 
-	// TODO Should probably deduplicate the nearbyIds from each other, and from the passed in sources
+	response := make([]SearchHit, 0, min(size, len(order)))
+	for _, id := range order {
+		if len(response) >= size {
+			break
+		}
+		if chunk := chunks[id]; chunk.DocType != DocTypeQuestion {
+			response = append(response, chunk)
+		}
+	}
+	return response, nil
+}
 
-	queryBytes, err := json.Marshal(struct {
-		Size  int   `json:"size"`
-		Query query `json:"query"`
-	}{
-		Size: maxSegments,
-		Query: query{
-			Terms: &termsSearch{
-				Ids: nearbyIds,
-			},
-		},
-	})
+func queryEmbedding(ctx context.Context, client *opensearch.Client, index string, queryVector []float32, size int, K int, exclude query, filter Filter) ([]SearchHit, error) {
+	// The lucene and faiss engines find K neighbors for the whole index rather than each segment, so asking for
+	// fewer than size would cut the results short
+	K = max(K, size)
+	q, err := vectorQuery(ctx, client, index, queryVector, K, exclude, filter)
+	if err != nil {
+		return nil, err
+	}
+	matches, err := searchHits(ctx, client, index, size, q)
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal query: %w", err)
+		return nil, fmt.Errorf("failed to execute vector query: %w", err)
 	}
+	return matches, nil
+}
 
-	searchRequest := opensearchapi.SearchRequest{
-		Index: []string{indexName},
-		Body:  bytes.NewReader(queryBytes),
+// vectorQuery is a knnQuery when the engine of the index can filter while searching, or there's no filter. The
+// nmslib engine can't, so a filtered search of it is an exact search of the matching documents instead, which is
+// slower but still finds the nearest of them
+func vectorQuery(ctx context.Context, client *opensearch.Client, index string, queryVector []float32, K int, exclude query, filter Filter) (query, error) {
+	if filter.IsEmpty() {
+		return knnQuery(queryVector, K, exclude, filter), nil
 	}
-	searchResponse, err := searchRequest.Do(ctx, client)
+	engine, err := indexEngine(ctx, client, index)
 	if err != nil {
-		return nil, fmt.Errorf("query failed: %w", err)
+		return query{}, err
 	}
-	if searchResponse.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected response to vector query: %s", searchResponse.String())
+	if filtersWhileSearching(engine) {
+		return knnQuery(queryVector, K, exclude, filter), nil
 	}
+	return exactKnn(filter.withFilter(query{Bool: &boolSearch{MustNot: []query{exclude}}}), queryVector), nil
+}
 
-	bodyBytes, err := io.ReadAll(searchResponse.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read search response body")
+// knnQuery finds the K nearest neighbors of the query vector that match the filter, leaving out anything matching
+// exclude
+func knnQuery(queryVector []float32, K int, exclude query, filter Filter) query {
+	return query{
+		Bool: &boolSearch{
+			Must: []query{{
+				Knn: &knnSearch{
+					vectorData: vectorData{
+						Vector: queryVector,
+						K:      K,
+						Filter: filter.query(),
+					},
+				},
+			}},
+			MustNot: []query{exclude},
+		},
 	}
+}
 
-	result := struct {
-		Hits struct {
-			Hits []struct {
-				Id     string   `json:"_id"`
-				Source Document `json:"_source"`
-			} `json:"hits"`
-		} `json:"hits"`
-	}{}
+// searchDocuments runs a query and returns the source documents of the hits
+func searchDocuments(ctx context.Context, client *opensearch.Client, index string, size int, q query) ([]Document, error) {
+	hits, err := runSearch(ctx, client, index, searchRequest{Size: size, Query: &q})
+	return Documents(hits), err
+}
+
+// searchHits runs a query and returns the hits along with their scores, and their explanations if the context
+// asks for them
+func searchHits(ctx context.Context, client *opensearch.Client, index string, size int, q query) ([]SearchHit, error) {
+	return runSearch(ctx, client, index, searchRequest{Size: size, Query: &q, Explain: explaining(ctx)})
+}
 
-	err = json.Unmarshal(bodyBytes, &result)
+func runSearch(ctx context.Context, client *opensearch.Client, index string, request searchRequest) ([]SearchHit, error) {
+	response, err := executeSearch(ctx, client, index, request)
 	if err != nil {
-		return nil, fmt.Errorf("failed to deserialize search results")
+		return nil, fmt.Errorf("failed to execute query: %w", err)
 	}
+	return response.hits(), nil
+}
 
-	response := make([]Document, len(result.Hits.Hits))
-	for i := range result.Hits.Hits {
-		response[i] = result.Hits.Hits[i].Source
-	}
+// searchPageSize is how many hits searchAll fetches at a time
+const searchPageSize = 1000
 
-	return response, nil
+// searchAll runs a search a page at a time until it runs out of hits, for searches that have to find every match
+// rather than the best few. Hits are ordered by score and then Id, so each page can carry on from the last
+func searchAll(ctx context.Context, client *opensearch.Client, index string, request searchRequest) ([]SearchHit, error) {
+	request.Size = searchPageSize
+	request.Sort = []sortClause{{Score: "desc"}, {Id: "asc"}}
+	request.TrackScores = true
+	var hits []SearchHit
+	for {
+		page, err := runSearch(ctx, client, index, request)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, page...)
+		if len(page) < searchPageSize {
+			return hits, nil
+		}
+		last := page[len(page)-1]
+		request.SearchAfter = []any{last.Score, last.Id}
+	}
 }
 
-func QueryEmbedding(ctx context.Context, client *opensearch.Client, queryVector []float32, size int, K int) ([]Document, error) {
-	queryBytes, err := json.Marshal(struct {
-		Size  int   `json:"size"`
-		Query query `json:"query"`
-	}{
-		Size: size,
-		Query: query{
-			Knn: &knnSearch{
-				vectorData: vectorData{
-					Vector: queryVector,
-					K:      K,
-				},
-			},
-		},
-	})
+// QueryEpisodes finds the episodes whose summary, or one of whose chapter summaries, is closest to the query
+// vector. The best matching summary document is returned for each episode
+func QueryEpisodes(ctx context.Context, client *opensearch.Client, index string, queryVector []float32, episodes int, filter Filter) ([]SearchHit, error) {
+	// Each episode may have several chapter summaries, so over fetch a bit to get enough distinct episodes
+	summaries, err := searchHits(ctx, client, index, episodes*5, exactKnn(filter.withFilter(query{
+		Terms: &termsSearch{DocType: []string{DocTypeSummary, DocTypeChapter}},
+	}), queryVector))
 	if err != nil {
-		return nil, fmt.Errorf("failed to marshal vector query: %w", err)
+		return nil, fmt.Errorf("failed to query episode summaries: %w", err)
 	}
 
-	searchReq := opensearchapi.SearchRequest{
-		Index: []string{indexName},
-		Body:  bytes.NewReader(queryBytes),
+	return ranked(bestPerEpisode(summaries, episodes)), nil
+}
+
+// bestPerEpisode keeps only the first of the ordered summaries for each episode
+func bestPerEpisode(summaries []SearchHit, episodes int) []SearchHit {
+	seen := make(map[string]bool)
+	var response []SearchHit
+	for _, s := range summaries {
+		if seen[s.GUID] {
+			continue
+		}
+		seen[s.GUID] = true
+		response = append(response, s)
+		if len(response) >= episodes {
+			break
+		}
 	}
+	return response
+}
 
-	searchResponse, err := searchReq.Do(ctx, client)
+// QueryHierarchical is a two stage search, first picking the episodes most relevant to the query by their
+// summaries, then finding the closest transcript chunks from within just those episodes. This works better than
+// QueryEmbedding for broad questions that are about an episode as a whole rather than a particular moment
+func QueryHierarchical(ctx context.Context, client *opensearch.Client, index string, queryVector []float32, episodes int, size int, filter Filter) ([]SearchHit, error) {
+	matchingEpisodes, err := QueryEpisodes(ctx, client, index, queryVector, episodes, filter)
 	if err != nil {
-		return nil, fmt.Errorf("failed to execute vector query: %w", err)
+		return nil, err
 	}
-
-	if searchResponse.StatusCode != 200 {
-		return nil, fmt.Errorf("unexpected response to vector query: %s", searchResponse.String())
+	if len(matchingEpisodes) == 0 {
+		return nil, nil
 	}
 
-	bodyBytes, err := io.ReadAll(searchResponse.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read search response body")
-	}
-
-	result := struct {
-		Hits struct {
-			Hits []struct {
-				Id     string   `json:"_id"`
-				Source Document `json:"_source"`
-			} `json:"hits"`
-		} `json:"hits"`
-	}{}
-	err = json.Unmarshal(bodyBytes, &result)
-	if err != nil {
-		return nil, fmt.Errorf("failed to deserialize search results")
+	guids := make([]string, len(matchingEpisodes))
+	for i := range matchingEpisodes {
+		guids[i] = matchingEpisodes[i].GUID
 	}
 
-	response := make([]Document, len(result.Hits.Hits))
-	for i := range result.Hits.Hits {
-		response[i] = result.Hits.Hits[i].Source
+	// The filter applies to the chunks as well as the episodes, chunks have their own topics and entities
+	chunks, err := searchHits(ctx, client, index, size, exactKnn(filter.withFilter(query{
+		Bool: &boolSearch{
+			Filter:  []query{{Terms: &termsSearch{GUID: guids}}},
+			MustNot: []query{notChunks},
+		},
+	}), queryVector))
+	if err != nil {
+		return nil, fmt.Errorf("failed to query chunks of matching episodes: %w", err)
 	}
 
-	return response, nil
+	return ranked(chunks), nil
 }
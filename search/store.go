@@ -0,0 +1,128 @@
+package search
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+// Kinds of VectorStore, picked with the OXIDE_VECTOR_STORE environment variable or a --store flag
+const (
+	StoreOpenSearch = "opensearch"
+	StoreLocal      = "local"
+)
+
+// VectorStore is somewhere the documents for an embedding model have been indexed, which can be searched by vector.
+// Searches return hits ranked best first
+type VectorStore interface {
+	// QueryEmbedding finds the transcript chunks nearest to the query vector, either directly or through one of the
+	// synthetic questions generated for them
+	QueryEmbedding(ctx context.Context, queryVector []float32, size int, K int, filter Filter) ([]SearchHit, error)
+	// QueryChunks finds the transcript chunks nearest to the query vector, ignoring any synthetic questions
+	QueryChunks(ctx context.Context, queryVector []float32, size int, K int, filter Filter) ([]SearchHit, error)
+	// QueryHybrid fuses a BM25 text search on the transcripts with QueryEmbedding
+	QueryHybrid(ctx context.Context, queryText string, queryVector []float32, size int, config HybridConfig, filter Filter) ([]SearchHit, error)
+	// QueryEpisodes finds the best matching summary document of the episodes closest to the query vector
+	QueryEpisodes(ctx context.Context, queryVector []float32, episodes int, filter Filter) ([]SearchHit, error)
+	// QueryHierarchical finds the transcript chunks nearest to the query vector from within the closest episodes
+	QueryHierarchical(ctx context.Context, queryVector []float32, episodes int, size int, filter Filter) ([]SearchHit, error)
+	// QueryPage finds the transcript chunks nearest to the query vector a page at a time, after is the Next cursor
+	// of the previous page, or empty for the first page
+	QueryPage(ctx context.Context, queryVector []float32, size int, after string, filter Filter) (*Page, error)
+	// MoreLikeThis finds the transcript chunks from other episodes nearest to the stored vector of a document,
+	// returning ErrNotFound if there's no such document
+	MoreLikeThis(ctx context.Context, id string, size int, filter Filter) ([]SearchHit, error)
+	// RelatedEpisodes finds the episodes most like an episode, by its summary or the centroid of its chunks,
+	// returning ErrNotFound if there's no such episode
+	RelatedEpisodes(ctx context.Context, GUID string, size int, filter Filter) ([]SearchHit, error)
+	// QueryThreshold finds every transcript chunk whose cosine similarity to the query vector is at least threshold,
+	// with the similarity as their score
+	QueryThreshold(ctx context.Context, queryVector []float32, threshold float64, filter Filter) ([]SearchHit, error)
+	// QueryQuotes finds every place a phrase was said, allowing up to slop words in between its words
+	QueryQuotes(ctx context.Context, phrase string, slop int, filter Filter) ([]Quote, error)
+	// QueryLinks finds the links in the show notes whose anchor text has every word of the text, or whose URL
+	// contains it, newest episodes first
+	QueryLinks(ctx context.Context, text string, filter Filter) ([]Reference, error)
+	// ExpandContext merges the sources and the chunks within radius words of them into passages, for additional
+	// conversational context
+	ExpandContext(ctx context.Context, sources []Document, radius int) ([]Passage, error)
+}
+
+// OpenStore opens the kind of store holding a models documents, the local store has to have been written by
+// indexing with it first
+func OpenStore(kind string, dataDirectory string, model string) (VectorStore, error) {
+	switch kind {
+	case StoreOpenSearch, "":
+		client, err := NewClient()
+		if err != nil {
+			return nil, err
+		}
+		return &OpenSearchStore{Client: client, Index: IndexName(model)}, nil
+	case StoreLocal:
+		store, err := OpenLocalStore(LocalStorePath(dataDirectory, IndexName(model)))
+		if err != nil {
+			return nil, err
+		}
+		if store.Len() == 0 {
+			return nil, fmt.Errorf("the local store for %s is empty, index into it with --store %s first", model, StoreLocal)
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown vector store %q, expected %s or %s", kind, StoreOpenSearch, StoreLocal)
+	}
+}
+
+// OpenSearchStore searches an opensearch index, Index should be the alias from IndexName
+type OpenSearchStore struct {
+	Client *opensearch.Client
+	Index  string
+}
+
+func (s *OpenSearchStore) QueryEmbedding(ctx context.Context, queryVector []float32, size int, K int, filter Filter) ([]SearchHit, error) {
+	return QueryEmbedding(ctx, s.Client, s.Index, queryVector, size, K, filter)
+}
+
+func (s *OpenSearchStore) QueryChunks(ctx context.Context, queryVector []float32, size int, K int, filter Filter) ([]SearchHit, error) {
+	return QueryChunks(ctx, s.Client, s.Index, queryVector, size, K, filter)
+}
+
+func (s *OpenSearchStore) QueryHybrid(ctx context.Context, queryText string, queryVector []float32, size int, config HybridConfig, filter Filter) ([]SearchHit, error) {
+	return QueryHybrid(ctx, s.Client, s.Index, queryText, queryVector, size, config, filter)
+}
+
+func (s *OpenSearchStore) QueryEpisodes(ctx context.Context, queryVector []float32, episodes int, filter Filter) ([]SearchHit, error) {
+	return QueryEpisodes(ctx, s.Client, s.Index, queryVector, episodes, filter)
+}
+
+func (s *OpenSearchStore) QueryHierarchical(ctx context.Context, queryVector []float32, episodes int, size int, filter Filter) ([]SearchHit, error) {
+	return QueryHierarchical(ctx, s.Client, s.Index, queryVector, episodes, size, filter)
+}
+
+func (s *OpenSearchStore) QueryPage(ctx context.Context, queryVector []float32, size int, after string, filter Filter) (*Page, error) {
+	return QueryPage(ctx, s.Client, s.Index, queryVector, size, after, filter)
+}
+
+func (s *OpenSearchStore) MoreLikeThis(ctx context.Context, id string, size int, filter Filter) ([]SearchHit, error) {
+	return MoreLikeThis(ctx, s.Client, s.Index, id, size, filter)
+}
+
+func (s *OpenSearchStore) RelatedEpisodes(ctx context.Context, GUID string, size int, filter Filter) ([]SearchHit, error) {
+	return RelatedEpisodes(ctx, s.Client, s.Index, GUID, size, filter)
+}
+
+func (s *OpenSearchStore) QueryThreshold(ctx context.Context, queryVector []float32, threshold float64, filter Filter) ([]SearchHit, error) {
+	return QueryThreshold(ctx, s.Client, s.Index, queryVector, threshold, filter)
+}
+
+func (s *OpenSearchStore) QueryQuotes(ctx context.Context, phrase string, slop int, filter Filter) ([]Quote, error) {
+	return QueryQuotes(ctx, s.Client, s.Index, phrase, slop, filter)
+}
+
+func (s *OpenSearchStore) QueryLinks(ctx context.Context, text string, filter Filter) ([]Reference, error) {
+	return QueryLinks(ctx, s.Client, s.Index, text, filter)
+}
+
+func (s *OpenSearchStore) ExpandContext(ctx context.Context, sources []Document, radius int) ([]Passage, error) {
+	return ExpandContext(ctx, s.Client, s.Index, sources, radius)
+}
@@ -0,0 +1,71 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// deleteByQuery deletes every document matching the query, returning how many were deleted
+func deleteByQuery(ctx context.Context, client *opensearch.Client, index string, q query) (int, error) {
+	body, err := json.Marshal(searchRequest{Query: &q})
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal delete query: %w", err)
+	}
+
+	refresh := true
+	var result struct {
+		Deleted  int   `json:"deleted"`
+		Failures []any `json:"failures"`
+	}
+	err = do(ctx, client, opensearchapi.DeleteByQueryRequest{
+		Index:     []string{index},
+		Body:      bytes.NewReader(body),
+		Conflicts: "proceed",
+		Refresh:   &refresh,
+	}, &result)
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete documents from %s: %w", index, err)
+	}
+	if len(result.Failures) > 0 {
+		return result.Deleted, fmt.Errorf("%d documents could not be deleted from %s: %v", len(result.Failures), index, result.Failures)
+	}
+	return result.Deleted, nil
+}
+
+// DeleteStale deletes every document of an episode besides the ones listed, which cleans up after an episode that
+// now has fewer chunks, summaries or questions than when it was last indexed
+func DeleteStale(ctx context.Context, client *opensearch.Client, index string, GUID string, keep []string) (int, error) {
+	return deleteByQuery(ctx, client, index, query{
+		Bool: &boolSearch{
+			Filter:  []query{{Terms: &termsSearch{GUID: []string{GUID}}}},
+			MustNot: []query{{Terms: &termsSearch{Ids: keep}}},
+		},
+	})
+}
+
+// DeleteEpisodes deletes every document belonging to the episodes
+func DeleteEpisodes(ctx context.Context, client *opensearch.Client, index string, GUIDs []string) (int, error) {
+	return deleteByQuery(ctx, client, index, query{Terms: &termsSearch{GUID: GUIDs}})
+}
+
+// IndexedEpisodes lists the GUID of every episode with documents in the index
+func IndexedEpisodes(ctx context.Context, client *opensearch.Client, index string) ([]string, error) {
+	response, err := executeSearch(ctx, client, index, searchRequest{
+		Aggs: map[string]aggregation{"episodes": {Terms: &termsAggregation{Field: "GUID", Size: 10000}}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list episodes in %s: %w", index, err)
+	}
+
+	buckets := response.Aggregations["episodes"].Buckets
+	guids := make([]string, len(buckets))
+	for i, bucket := range buckets {
+		guids[i] = bucket.Key
+	}
+	return guids, nil
+}
@@ -0,0 +1,146 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/opensearch-project/opensearch-go"
+)
+
+// Timeline intervals are how long each bucket of a timeline covers
+const (
+	IntervalMonth   = "month"
+	IntervalQuarter = "quarter"
+	IntervalYear    = "year"
+)
+
+// ValidInterval checks the interval is one a timeline can be bucketed by
+func ValidInterval(interval string) error {
+	if !slices.Contains([]string{IntervalMonth, IntervalQuarter, IntervalYear}, interval) {
+		return fmt.Errorf("unknown timeline interval %q, expected %s, %s or %s", interval, IntervalMonth, IntervalQuarter, IntervalYear)
+	}
+	return nil
+}
+
+const (
+	// DefaultTimelineThreshold is the cosine similarity a chunk needs to count as discussing a query. It suits
+	// text-embedding-ada-002, whose similarities are all fairly high, the text-embedding-3 models need a lower one
+	DefaultTimelineThreshold = 0.8
+	// snippetWords is how much of a chunk is quoted as a snippet
+	snippetWords = 40
+)
+
+// Bucket is the chunks discussing a query published in one interval of a timeline
+type Bucket struct {
+	// Start is the beginning of the interval, in UTC
+	Start    time.Time
+	Chunks   int
+	Episodes int
+	// Snippets are from the chunks most similar to the query, at most one from each episode
+	Snippets []Snippet `json:",omitempty"`
+}
+
+// Snippet is the start of a chunk, as an example of what was said
+type Snippet struct {
+	Id    string
+	GUID  string
+	Title string
+	Score float64
+	Text  string
+}
+
+// QueryThreshold finds every transcript chunk whose cosine similarity to the query vector is at least threshold,
+// most similar first, with the similarity as their score. It's an exact search over all of the chunks, so it's
+// much slower than QueryChunks
+func QueryThreshold(ctx context.Context, client *opensearch.Client, index string, queryVector []float32, threshold float64, filter Filter) ([]SearchHit, error) {
+	// The knn_score script scores cosine similarity as 1 + similarity, to keep it positive
+	q := exactKnn(filter.withFilter(query{Bool: &boolSearch{MustNot: []query{notChunks}}}), queryVector)
+	// Thousands of chunks can match, so only the fields a timeline needs are fetched rather than their vectors
+	hits, err := searchAll(ctx, client, index, searchRequest{
+		Query:    &q,
+		MinScore: 1 + threshold,
+		Source:   []string{"Id", "GUID", "Title", "Link", "PublishedAt", "Transcript"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to execute threshold query: %w", err)
+	}
+	for i := range hits {
+		hits[i].Score -= 1
+	}
+	return ranked(hits), nil
+}
+
+// Timeline buckets the hits by the interval their episode was published in, from the first interval with any
+// hits to the last, including the empty ones in between. Hits from episodes without a publication date are left
+// out. Each bucket gets up to snippets snippets, from the highest scoring hits
+func Timeline(hits []SearchHit, interval string, snippets int) ([]Bucket, error) {
+	var truncate func(t time.Time) time.Time
+	var next func(t time.Time) time.Time
+	switch interval {
+	case IntervalMonth:
+		truncate = func(t time.Time) time.Time { return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC) }
+		next = func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	case IntervalQuarter:
+		truncate = func(t time.Time) time.Time {
+			return time.Date(t.Year(), t.Month()-(t.Month()-1)%3, 1, 0, 0, 0, 0, time.UTC)
+		}
+		next = func(t time.Time) time.Time { return t.AddDate(0, 3, 0) }
+	case IntervalYear:
+		truncate = func(t time.Time) time.Time { return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC) }
+		next = func(t time.Time) time.Time { return t.AddDate(1, 0, 0) }
+	default:
+		return nil, ValidInterval(interval)
+	}
+
+	byStart := make(map[time.Time][]SearchHit)
+	var first, last time.Time
+	for _, hit := range hits {
+		if hit.PublishedAt == nil {
+			continue
+		}
+		start := truncate(hit.PublishedAt.UTC())
+		if len(byStart) == 0 || start.Before(first) {
+			first = start
+		}
+		if len(byStart) == 0 || start.After(last) {
+			last = start
+		}
+		byStart[start] = append(byStart[start], hit)
+	}
+	if len(byStart) == 0 {
+		return nil, nil
+	}
+
+	var buckets []Bucket
+	for start := first; !start.After(last); start = next(start) {
+		bucketHits := byStart[start]
+		sort.SliceStable(bucketHits, func(i, j int) bool { return bucketHits[i].Score > bucketHits[j].Score })
+		bucket := Bucket{Start: start, Chunks: len(bucketHits)}
+		episodes := make(map[string]bool)
+		for _, hit := range bucketHits {
+			if episodes[hit.GUID] {
+				continue
+			}
+			episodes[hit.GUID] = true
+			if len(bucket.Snippets) < snippets {
+				bucket.Snippets = append(bucket.Snippets, newSnippet(hit))
+			}
+		}
+		bucket.Episodes = len(episodes)
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+func newSnippet(hit SearchHit) Snippet {
+	words := strings.Fields(hit.Transcript)
+	text := strings.Join(words[:min(snippetWords, len(words))], " ")
+	if len(words) > snippetWords {
+		text += "..."
+	}
+	return Snippet{Id: hit.Id, GUID: hit.GUID, Title: hit.Title, Score: hit.Score, Text: text}
+}
@@ -0,0 +1,206 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/opensearch-project/opensearch-go"
+	"github.com/opensearch-project/opensearch-go/opensearchapi"
+)
+
+// Indexes are built as numbered versions like oxide-v3, and searched through an alias (see IndexName) that points
+// at whichever version is live. A new version can then be built and checked while the old one is still serving,
+// and the alias swapped over in a single atomic request
+
+// IndexVersion is one physical version of an aliased index
+type IndexVersion struct {
+	Name     string
+	Version  int
+	DocCount int
+	Live     bool
+}
+
+// VersionName is the physical index name for a version of an aliased index
+func VersionName(alias string, version int) string {
+	return fmt.Sprintf("%s-v%d", alias, version)
+}
+
+// LiveIndex returns the physical index an alias currently points to, or an empty string if there isn't one. Indexes
+// from before versioning were created directly under the alias name, in which case that name is returned
+func LiveIndex(ctx context.Context, client *opensearch.Client, alias string) (string, error) {
+	var indices map[string]json.RawMessage
+	err := do(ctx, client, opensearchapi.IndicesGetRequest{Index: []string{alias}}, &indices)
+	if errors.Is(err, ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up index %s: %w", alias, err)
+	}
+	if len(indices) != 1 {
+		return "", fmt.Errorf("expected alias %s to point at a single index but found %d", alias, len(indices))
+	}
+	for name := range indices {
+		return name, nil
+	}
+	return "", nil
+}
+
+// IndexVersions lists every version of an aliased index, oldest first
+func IndexVersions(ctx context.Context, client *opensearch.Client, alias string) ([]IndexVersion, error) {
+	live, err := LiveIndex(ctx, client, alias)
+	if err != nil {
+		return nil, err
+	}
+
+	var indices []struct {
+		Index    string `json:"index"`
+		DocCount string `json:"docs.count"`
+	}
+	err = do(ctx, client, opensearchapi.CatIndicesRequest{
+		Index:  []string{alias + "-v*"},
+		Format: "json",
+		H:      []string{"index", "docs.count"},
+	}, &indices)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list versions of index %s: %w", alias, err)
+	}
+
+	versionPattern := regexp.MustCompile("^" + regexp.QuoteMeta(alias) + `-v(\d+)$`)
+	var versions []IndexVersion
+	for _, i := range indices {
+		match := versionPattern.FindStringSubmatch(i.Index)
+		if match == nil {
+			// Another models index which happens to share a prefix with this one
+			continue
+		}
+		version, _ := strconv.Atoi(match[1])
+		docCount, _ := strconv.Atoi(i.DocCount)
+		versions = append(versions, IndexVersion{
+			Name:     i.Index,
+			Version:  version,
+			DocCount: docCount,
+			Live:     i.Index == live,
+		})
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+
+	return versions, nil
+}
+
+type aliasAction struct {
+	Add         *aliasTarget `json:"add,omitempty"`
+	Remove      *aliasTarget `json:"remove,omitempty"`
+	RemoveIndex *aliasTarget `json:"remove_index,omitempty"`
+}
+
+type aliasTarget struct {
+	Index string `json:"index"`
+	Alias string `json:"alias,omitempty"`
+}
+
+// LegacyVersion is the version an index from before versioning is kept as once an alias replaces it
+const LegacyVersion = 0
+
+// SwapAlias atomically points an alias at a new physical index. If the alias name is still taken by an index from
+// before versioning, it's first cloned to version LegacyVersion so it can still be rolled back to, and then deleted
+// in the same request since the alias can't be created alongside it
+func SwapAlias(ctx context.Context, client *opensearch.Client, alias string, to string) error {
+	from, err := LiveIndex(ctx, client, alias)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return nil
+	}
+
+	actions := []aliasAction{{Add: &aliasTarget{Index: to, Alias: alias}}}
+	switch from {
+	case "":
+	case alias:
+		err = cloneIndex(ctx, client, from, VersionName(alias, LegacyVersion))
+		if err != nil {
+			return fmt.Errorf("failed to keep %s before replacing it with an alias, it has been left in place: %w", from, err)
+		}
+		actions = append(actions, aliasAction{RemoveIndex: &aliasTarget{Index: from}})
+	default:
+		actions = append(actions, aliasAction{Remove: &aliasTarget{Index: from, Alias: alias}})
+	}
+
+	body, err := json.Marshal(struct {
+		Actions []aliasAction `json:"actions"`
+	}{actions})
+	if err != nil {
+		return fmt.Errorf("failed to build alias update: %w", err)
+	}
+
+	err = do(ctx, client, opensearchapi.IndicesUpdateAliasesRequest{Body: bytes.NewReader(body)}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to point alias %s at %s: %w", alias, to, err)
+	}
+	return nil
+}
+
+// cloneIndex copies an index, documents and all, to a new index with the same settings and mapping. The source has
+// to be blocked from writes while it's cloned, and is unblocked again afterwards
+func cloneIndex(ctx context.Context, client *opensearch.Client, index string, target string) error {
+	err := setWriteBlock(ctx, client, index, true)
+	if err != nil {
+		return err
+	}
+	defer setWriteBlock(ctx, client, index, false)
+
+	// The clone would inherit the block from the source otherwise
+	body := strings.NewReader(`{"settings": {"index.blocks.write": false}}`)
+	err = do(ctx, client, opensearchapi.IndicesCloneRequest{Index: index, Target: target, Body: body}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to clone index %s to %s: %w", index, target, err)
+	}
+	return nil
+}
+
+func setWriteBlock(ctx context.Context, client *opensearch.Client, index string, block bool) error {
+	body := strings.NewReader(fmt.Sprintf(`{"index.blocks.write": %t}`, block))
+	err := do(ctx, client, opensearchapi.IndicesPutSettingsRequest{Index: []string{index}, Body: body}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to set the write block on index %s: %w", index, err)
+	}
+	return nil
+}
+
+// SanityCheck makes sure a freshly built index is fit to serve before it's swapped in, it should hold exactly the
+// documents that were written to it, and searching for one of their vectors should find that document
+func SanityCheck(ctx context.Context, client *opensearch.Client, index string, expectedDocs int, probe Document) error {
+	err := do(ctx, client, opensearchapi.IndicesRefreshRequest{Index: []string{index}}, nil)
+	if err != nil {
+		return fmt.Errorf("failed to refresh index %s: %w", index, err)
+	}
+
+	description, err := DescribeIndex(ctx, client, index)
+	if err != nil {
+		return err
+	}
+	if description == nil {
+		return fmt.Errorf("index %s: %w", index, ErrNotFound)
+	}
+	if expectedDocs == 0 || description.DocCount != expectedDocs {
+		return fmt.Errorf("index %s holds %d documents, expected %d", index, description.DocCount, expectedDocs)
+	}
+
+	matches, err := searchDocuments(ctx, client, index, 10, query{Knn: &knnSearch{vectorData{Vector: probe.Vectors, K: 10}}})
+	if err != nil {
+		return fmt.Errorf("failed to query index %s: %w", index, err)
+	}
+	for _, match := range matches {
+		if match.Id == probe.Id {
+			return nil
+		}
+	}
+	return fmt.Errorf("searching index %s for the vector of document %s did not find it", index, probe.Id)
+}
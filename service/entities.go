@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"oxide-search/entities"
+)
+
+// EntitySummary is an entry of the glossary, without its mentions
+type EntitySummary struct {
+	Name     string
+	Slug     string
+	Kind     string
+	Episodes int
+	Mentions int
+}
+
+type EntitiesResponse struct {
+	// Entities are ordered by how often they're mentioned, any of their names can be used as the Entity of a Filter
+	Entities []EntitySummary
+}
+
+// loadPages reads the glossary written by the entities command, writing the error response if it can't
+func (s *server) loadPages(ctx *gin.Context) ([]entities.Page, bool) {
+	pages, err := entities.LoadPages(dataDirectory)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong loading the glossary"})
+		s.logger.ErrorContext(ctx, "failed to load glossary", slog.Any("error", err))
+		return nil, false
+	}
+	if pages == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no entities have been extracted"})
+		return nil, false
+	}
+	return pages, true
+}
+
+// entitiesHandler lists every entity in the glossary
+func (s *server) entitiesHandler(ctx *gin.Context) {
+	pages, ok := s.loadPages(ctx)
+	if !ok {
+		return
+	}
+	kind := ctx.Query("kind")
+
+	response := &EntitiesResponse{Entities: []EntitySummary{}}
+	for _, page := range pages {
+		if kind != "" && page.Kind != kind {
+			continue
+		}
+		response.Entities = append(response.Entities, EntitySummary{
+			Name:     page.Name,
+			Slug:     page.Slug,
+			Kind:     page.Kind,
+			Episodes: page.Episodes,
+			Mentions: len(page.Mentions),
+		})
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// entityHandler returns the glossary page of the entity in the path, with every mention of it
+func (s *server) entityHandler(ctx *gin.Context) {
+	pages, ok := s.loadPages(ctx)
+	if !ok {
+		return
+	}
+	slug := entities.Slug(ctx.Param("slug"))
+	for _, page := range pages {
+		if page.Slug == slug {
+			ctx.JSON(http.StatusOK, &page)
+			return
+		}
+	}
+	ctx.JSON(http.StatusNotFound, gin.H{"error": "no entity named " + ctx.Param("slug")})
+}
@@ -1,56 +1,129 @@
 package main
 
 import (
-	"crypto/tls"
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"sync"
 
 	"github.com/gin-contrib/cors"
 	"github.com/gin-gonic/gin"
-	"github.com/opensearch-project/opensearch-go"
 	"github.com/sashabaranov/go-openai"
 
+	"oxide-search/expand"
 	"oxide-search/meta"
+	"oxide-search/rerank"
 	"oxide-search/search"
 )
 
+const (
+	dataDirectory = "data"
+
+	// modelHeader lets a request pick which embedding model, and so which index, to search with
+	modelHeader = "X-Embedding-Model"
+)
+
 type QueryPayload struct {
 	UserQuery string
+	// Hierarchical picks the most relevant episodes by their summaries before searching within them
+	Hierarchical bool
+	// Hybrid combines a BM25 text search with the vector search when set, any fields left out use the defaults
+	Hybrid *search.HybridConfig
+	// Diversity picks results covering more distinct parts of the transcripts when set, by maximal marginal relevance
+	// and a cap on results per episode. Lambda and Candidates use the defaults when left out
+	Diversity *search.DiversityConfig
+	// Filter restricts the search to matching episodes, PublishedFrom and PublishedUntil are RFC 3339 timestamps
+	Filter search.Filter
+	// ContextRadius is how far either side of each result, in words, to pull in neighboring chunks for context, at most
+	// search.MaxContextRadius
+	ContextRadius int
+	// Explain adds how opensearch scored each hit to the response
+	Explain bool
+	// Expand turns on transformations of the query, each variant is searched and the results fused
+	Expand expand.Config
 }
 
 type QueryResponse struct {
 	UserQuery    string
+	Model        string
 	ChatResponse string
 	Sources      []string
 	Embeddings   []string
+	// Hits are the search results behind the sources, with why each was found
+	Hits []Hit
+	// References are links from the show notes of the source episodes, those mentioned in the sources first
+	References []search.Reference `json:",omitempty"`
+	// Variants are the transformed queries searched alongside the users query, when any were turned on
+	Variants []expand.Variant `json:",omitempty"`
+}
+
+// Hit is a search.SearchHit without the vectors and text of its document
+type Hit struct {
+	Id      string
+	GUID    string
+	Title   string
+	DocType string
+	Score   float64
+	// Rank is the position the hit was retrieved at, before any reranking
+	Rank            int
+	Start           int
+	End             int
+	MatchedQuestion string          `json:",omitempty"`
+	Highlights      []string        `json:",omitempty"`
+	Explanation     json.RawMessage `json:",omitempty"`
+	// RerankScore is only set when a reranker is configured
+	RerankScore *float64 `json:",omitempty"`
+}
+
+func newHit(hit search.SearchHit) Hit {
+	return Hit{
+		Id:              hit.Id,
+		GUID:            hit.GUID,
+		Title:           hit.Title,
+		DocType:         hit.DocType,
+		Score:           hit.Score,
+		Rank:            hit.Rank,
+		Start:           hit.Start,
+		End:             hit.End,
+		MatchedQuestion: hit.MatchedQuestion,
+		Highlights:      hit.Highlights,
+		Explanation:     hit.Explanation,
+	}
 }
 
 type server struct {
-	searchClient *opensearch.Client
+	// storeKind is the kind of search.VectorStore to search, from OXIDE_VECTOR_STORE
+	storeKind    string
+	storesLock   sync.Mutex
+	stores       map[string]search.VectorStore
 	openaiClient *openai.Client
-	logger       *slog.Logger
+	// reranker reorders the top search results, from OXIDE_RERANKER and OXIDE_RERANK_URL
+	reranker rerank.Reranker
+	expander *expand.Expander
+	logger   *slog.Logger
 }
 
 func main() {
-	searchClient, err := opensearch.NewClient(opensearch.Config{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
-		},
-		Addresses: []string{"https://localhost:9200"},
-		Username:  "admin", // For testing only. Don't store credentials in code.
-		Password:  "admin",
-	})
+	openaiClient := openai.NewClient(os.Getenv("OPENAI_API_KEY"))
+	reranker, err := rerank.Open(os.Getenv("OXIDE_RERANKER"), os.Getenv("OXIDE_RERANK_URL"), openaiClient)
 	if err != nil {
-		panic(err)
+		log.Fatal("Failed to configure reranker:", err)
+	}
+	expander, err := expand.NewExpander(openaiClient, dataDirectory)
+	if err != nil {
+		log.Fatal("Failed to load glossary:", err)
 	}
-
 	s := &server{
-		searchClient: searchClient,
-		openaiClient: openai.NewClient(os.Getenv("OPENAI_API_KEY")),
+		storeKind:    os.Getenv("OXIDE_VECTOR_STORE"),
+		stores:       make(map[string]search.VectorStore),
+		openaiClient: openaiClient,
+		reranker:     reranker,
+		expander:     expander,
 		logger:       slog.Default(),
 	}
 
@@ -58,6 +131,15 @@ func main() {
 	router.Use(cors.Default()) // Allow all origins
 
 	router.POST("/chatQuery", s.queryHandler)
+	router.POST("/search", s.searchHandler)
+	router.GET("/chunks/:id/similar", s.similarHandler)
+	router.POST("/quotes", s.quoteHandler)
+	router.POST("/links", s.linksHandler)
+	router.GET("/episodes/:guid/related", s.relatedHandler)
+	router.GET("/topics", s.topicsHandler)
+	router.POST("/timeline", s.timelineHandler)
+	router.GET("/entities", s.entitiesHandler)
+	router.GET("/entities/:slug", s.entityHandler)
 
 	err = router.Run() // listen and serve on 0.0.0.0:8080 (for windows "localhost:8080")
 	if err != nil && !errors.Is(err, http.ErrServerClosed) {
@@ -65,6 +147,40 @@ func main() {
 	}
 }
 
+// store opens the vector store for a model the first time it's searched, local stores are loaded into memory so
+// they're kept around rather than opened for each request, and loaded again once they've been reindexed
+func (s *server) store(model string) (search.VectorStore, error) {
+	s.storesLock.Lock()
+	defer s.storesLock.Unlock()
+	cached, ok := s.stores[model]
+	if local, isLocal := cached.(*search.LocalStore); ok && (!isLocal || !local.Stale()) {
+		return cached, nil
+	}
+	store, err := search.OpenStore(s.storeKind, dataDirectory, model)
+	if err != nil && ok {
+		// The files may be caught half written, so keep serving what was loaded before and try again next time
+		s.logger.Error("failed to reload local store", slog.String("model", model), slog.Any("error", err))
+		return cached, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.stores[model] = store
+	return store, nil
+}
+
+// searchStatus picks the response status for a failed search, so clients can tell an outage worth retrying from a
+// missing index or a bug
+func searchStatus(err error) int {
+	switch {
+	case errors.Is(err, search.ErrClusterUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, search.ErrNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
 func (s *server) queryHandler(ctx *gin.Context) {
 	var query QueryPayload
 	if err := ctx.Bind(&query); err != nil {
@@ -73,9 +189,49 @@ func (s *server) queryHandler(ctx *gin.Context) {
 		return
 	}
 
+	if query.Hybrid != nil {
+		*query.Hybrid = query.Hybrid.WithDefaults()
+		if err := query.Hybrid.Validate(); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if query.Diversity != nil {
+		*query.Diversity = query.Diversity.WithDefaults()
+		if err := query.Diversity.Validate(); err != nil {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if query.ContextRadius > search.MaxContextRadius {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("context radius can be at most %d words", search.MaxContextRadius)})
+		return
+	}
+
+	// The active model is checked on every request, so a migration to a new model takes effect without a restart
+	model, store, ok := s.openStore(ctx)
+	if !ok {
+		return
+	}
+
+	var err error
+	variants := []expand.Variant{{Kind: expand.KindOriginal, Text: query.UserQuery}}
+	if query.Expand.Enabled() {
+		variants, err = s.expander.Expand(ctx, query.UserQuery, query.Expand)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong talking to openai"})
+			s.logger.ErrorContext(ctx, "failed to expand user query", slog.Any("error", err))
+			return
+		}
+	}
+	inputs := make([]string, len(variants))
+	for i := range variants {
+		inputs[i] = variants[i].Text
+	}
+
 	queryEmbeddingResponse, err := s.openaiClient.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
-		Input: []string{query.UserQuery},
-		Model: openai.AdaEmbeddingV2,
+		Input: inputs,
+		Model: openai.EmbeddingModel(model.Name),
 	})
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong talking to openai"})
@@ -83,22 +239,75 @@ func (s *server) queryHandler(ctx *gin.Context) {
 		return
 	}
 
-	nearbyEmbeddings, err := search.QueryEmbedding(ctx, s.searchClient, queryEmbeddingResponse.Data[0].Embedding, 10, 2)
+	queryVectors := make([][]float32, len(queryEmbeddingResponse.Data))
+	for _, e := range queryEmbeddingResponse.Data {
+		queryVectors[e.Index] = e.Embedding
+	}
+	queryVector := queryVectors[0]
+	_, noRerank := s.reranker.(rerank.None)
+	candidates, size := 10, 10
+	if !noRerank {
+		candidates = rerank.DefaultCandidates
+		size = candidates
+	}
+	if query.Diversity != nil {
+		size = query.Diversity.CandidateCount(size)
+	}
+
+	var searchCtx context.Context = ctx
+	if query.Explain {
+		searchCtx = search.WithExplain(searchCtx)
+	}
+	nearbyEmbeddings, err := expand.Search(searchCtx, variants, queryVectors, size, func(searchCtx context.Context, text string, vector []float32) ([]search.SearchHit, error) {
+		switch {
+		case query.Hierarchical:
+			return store.QueryHierarchical(searchCtx, vector, 3, size, query.Filter)
+		case query.Hybrid != nil:
+			return store.QueryHybrid(searchCtx, text, vector, size, *query.Hybrid, query.Filter)
+		default:
+			return store.QueryEmbedding(searchCtx, vector, size, size*2, query.Filter)
+		}
+	})
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong talking to the search index"})
+		ctx.JSON(searchStatus(err), gin.H{"error": "something went wrong talking to the search index"})
 		s.logger.ErrorContext(ctx, "failed to locate nearby embeddings from user query", slog.Any("error", err))
 		return
 	}
+	if query.Diversity != nil {
+		nearbyEmbeddings = search.Diversify(queryVector, nearbyEmbeddings, candidates, *query.Diversity)
+	}
+	var hits []Hit
+	if !noRerank {
+		results, err := rerank.Rerank(ctx, s.reranker, query.UserQuery, nearbyEmbeddings, candidates, 10)
+		if err != nil {
+			ctx.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong reranking search results"})
+			s.logger.ErrorContext(ctx, "failed to rerank search results", slog.Any("error", err))
+			return
+		}
+		for _, r := range results {
+			hit, score := newHit(r.SearchHit), r.RerankScore
+			hit.RerankScore = &score
+			hits = append(hits, hit)
+		}
+		nearbyEmbeddings = rerank.Hits(results)
+	} else {
+		for _, h := range nearbyEmbeddings {
+			hits = append(hits, newHit(h))
+		}
+	}
 
-	additionalEmbeddings, err := search.AddNearbySegments(ctx, s.searchClient, nearbyEmbeddings, 20)
+	radius := query.ContextRadius
+	if radius <= 0 {
+		radius = search.DefaultContextRadius
+	}
+	passages, err := store.ExpandContext(ctx, search.Documents(nearbyEmbeddings), radius)
 	if err != nil {
-		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong talking to the search index"})
-		s.logger.ErrorContext(ctx, "failed to add additional embeddings from user query", slog.Any("error", err))
+		ctx.JSON(searchStatus(err), gin.H{"error": "something went wrong talking to the search index"})
+		s.logger.ErrorContext(ctx, "failed to expand context around search results", slog.Any("error", err))
 		return
 	}
 
-	contextEmbeddings := append(nearbyEmbeddings, additionalEmbeddings...)
-	conversationContext := meta.CreateConversation(meta.GetPrompt(), contextEmbeddings, query.UserQuery)
+	conversationContext := meta.CreateConversation(meta.GetPrompt(), passages, query.UserQuery)
 	chatResponse, err := s.openaiClient.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
 		Model:       openai.GPT4TurboPreview,
 		Messages:    conversationContext,
@@ -116,11 +325,21 @@ func (s *server) queryHandler(ctx *gin.Context) {
 		sources[i] = fmt.Sprintf("%s - %s", nearbyEmbeddings[i].EpisodeData.Title, nearbyEmbeddings[i].EpisodeData.Link)
 	}
 
+	// Each passage is described by the span of chunks it was merged from
+	embeddings := make([]string, len(passages))
+	for i := range passages {
+		embeddings[i] = passages[i].String()
+	}
+
 	response := &QueryResponse{
 		UserQuery:    query.UserQuery,
+		Model:        model.Name,
 		ChatResponse: chatResponse.Choices[0].Message.Content,
 		Sources:      sources,
-		Embeddings:   []string{"Embedding id 31", "Embedding id 64"},
+		References:   search.References(nearbyEmbeddings, search.DefaultReferences),
+		Embeddings:   embeddings,
+		Hits:         hits,
+		Variants:     variants[1:],
 	}
 
 	ctx.JSON(http.StatusOK, &response)
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sashabaranov/go-openai"
+
+	"oxide-search/embedding"
+	"oxide-search/search"
+	"oxide-search/topics"
+)
+
+type SearchPayload struct {
+	UserQuery string
+	// PageSize is how many hits to return, defaulting to 10
+	PageSize int
+	// Cursor is the Next cursor of the previous page, left out for the first page
+	Cursor string
+	// Filter restricts the search to matching episodes, PublishedFrom and PublishedUntil are RFC 3339 timestamps
+	Filter search.Filter
+	// Explain adds how opensearch scored each hit to the response
+	Explain bool
+}
+
+type SearchResponse struct {
+	Hits []Hit
+	// References are links from the show notes of the episodes of the hits, those mentioned in the hits first
+	References []search.Reference `json:",omitempty"`
+	// Next is the cursor for the following page, it's empty on the last page
+	Next string `json:",omitempty"`
+}
+
+type QuotePayload struct {
+	Phrase string
+	// Slop is how many other words can be said in between the words of the phrase, defaulting to
+	// search.DefaultQuoteSlop when left out
+	Slop *int
+	// Filter restricts the search to matching episodes, PublishedFrom and PublishedUntil are RFC 3339 timestamps
+	Filter search.Filter
+}
+
+type QuoteResponse struct {
+	Phrase string
+	Quotes []search.Quote
+}
+
+type LinksPayload struct {
+	// Text is matched against the anchor text and URL of the links, it can be left out to list every link to the
+	// Domain of the Filter
+	Text string
+	// Filter restricts the search to matching episodes, PublishedFrom and PublishedUntil are RFC 3339 timestamps
+	Filter search.Filter
+}
+
+type LinksResponse struct {
+	Text  string
+	Links []search.Reference
+}
+
+type RelatedResponse struct {
+	GUID string
+	// Episodes are the best matching document of each related episode, its summary or one of its chunks
+	Episodes []Hit
+}
+
+type TimelinePayload struct {
+	UserQuery string
+	// Threshold is the cosine similarity a chunk needs to count as discussing the query, defaulting to
+	// search.DefaultTimelineThreshold
+	Threshold float64
+	// Interval is month, quarter or year, defaulting to month
+	Interval string
+	// Snippets is how many example snippets to return for each bucket, defaulting to 1
+	Snippets *int
+	// Filter restricts the search to matching episodes, PublishedFrom and PublishedUntil are RFC 3339 timestamps
+	Filter search.Filter
+}
+
+type TimelineResponse struct {
+	UserQuery string
+	Interval  string
+	// Chunks is how many chunks were similar enough to the query, across every bucket
+	Chunks  int
+	Buckets []search.Bucket
+}
+
+type TopicsResponse struct {
+	Model string
+	// Topics are ordered by how many chunks they have, any of their labels can be used as the Topic of a Filter
+	Topics []topics.Topic
+}
+
+// openStore resolves the embedding model asked for by the request and opens its store, writing the error response
+// if it can't
+func (s *server) openStore(ctx *gin.Context) (embedding.Model, search.VectorStore, bool) {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.GetHeader(modelHeader))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to resolve embedding model", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return model, nil, false
+	}
+	store, err := s.store(model.Name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong opening the search index"})
+		s.logger.ErrorContext(ctx, "failed to open vector store", slog.String("model", model.Name), slog.Any("error", err))
+		return model, nil, false
+	}
+	return model, store, true
+}
+
+// searchHandler pages through the chunks nearest to a query, without generating a chat response
+func (s *server) searchHandler(ctx *gin.Context) {
+	var payload SearchPayload
+	if err := ctx.Bind(&payload); err != nil {
+		s.logger.ErrorContext(ctx, "failed to bind request to expected object", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if payload.PageSize == 0 {
+		payload.PageSize = 10
+	}
+	if payload.PageSize < 0 || payload.PageSize > search.MaxPagedResults {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "page size must be between 1 and " + strconv.Itoa(search.MaxPagedResults)})
+		return
+	}
+
+	model, store, ok := s.openStore(ctx)
+	if !ok {
+		return
+	}
+	queryEmbeddingResponse, err := s.openaiClient.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: []string{payload.UserQuery},
+		Model: openai.EmbeddingModel(model.Name),
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong talking to openai"})
+		s.logger.ErrorContext(ctx, "failed to generate embedding from user query", slog.Any("error", err))
+		return
+	}
+
+	var searchCtx context.Context = ctx
+	if payload.Explain {
+		searchCtx = search.WithExplain(searchCtx)
+	}
+	page, err := store.QueryPage(searchCtx, queryEmbeddingResponse.Data[0].Embedding, payload.PageSize, payload.Cursor, payload.Filter)
+	if errors.Is(err, search.ErrInvalidCursor) {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		ctx.JSON(searchStatus(err), gin.H{"error": "something went wrong talking to the search index"})
+		s.logger.ErrorContext(ctx, "failed to search for user query", slog.Any("error", err))
+		return
+	}
+
+	response := &SearchResponse{Next: page.Next}
+	for _, hit := range page.Hits {
+		response.Hits = append(response.Hits, newHit(hit))
+	}
+	response.References = search.References(page.Hits, search.DefaultReferences)
+	ctx.JSON(http.StatusOK, response)
+}
+
+// similarHandler finds the chunks from other episodes most like the chunk in the path
+func (s *server) similarHandler(ctx *gin.Context) {
+	size := 10
+	if value := ctx.Query("size"); value != "" {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "size must be a positive number"})
+			return
+		}
+	}
+
+	_, store, ok := s.openStore(ctx)
+	if !ok {
+		return
+	}
+	hits, err := store.MoreLikeThis(ctx, ctx.Param("id"), size, search.Filter{})
+	if err != nil {
+		ctx.JSON(searchStatus(err), gin.H{"error": "something went wrong finding similar chunks"})
+		s.logger.ErrorContext(ctx, "failed to find similar chunks", slog.String("id", ctx.Param("id")), slog.Any("error", err))
+		return
+	}
+
+	response := &SearchResponse{}
+	for _, hit := range hits {
+		response.Hits = append(response.Hits, newHit(hit))
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// quoteHandler finds every place a phrase was said, without generating a chat response
+func (s *server) quoteHandler(ctx *gin.Context) {
+	var payload QuotePayload
+	if err := ctx.Bind(&payload); err != nil {
+		s.logger.ErrorContext(ctx, "failed to bind request to expected object", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	slop := search.DefaultQuoteSlop
+	if payload.Slop != nil {
+		slop = *payload.Slop
+	}
+	if strings.TrimSpace(payload.Phrase) == "" || slop < 0 {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "a phrase is required, and slop can't be negative"})
+		return
+	}
+
+	_, store, ok := s.openStore(ctx)
+	if !ok {
+		return
+	}
+	quotes, err := store.QueryQuotes(ctx, payload.Phrase, slop, payload.Filter)
+	if err != nil {
+		ctx.JSON(searchStatus(err), gin.H{"error": "something went wrong talking to the search index"})
+		s.logger.ErrorContext(ctx, "failed to find quotes", slog.String("phrase", payload.Phrase), slog.Any("error", err))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &QuoteResponse{Phrase: payload.Phrase, Quotes: quotes})
+}
+
+// linksHandler searches the links in the show notes
+func (s *server) linksHandler(ctx *gin.Context) {
+	var payload LinksPayload
+	if err := ctx.Bind(&payload); err != nil {
+		s.logger.ErrorContext(ctx, "failed to bind request to expected object", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(payload.Text) == "" && payload.Filter.Domain == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "text or a domain to filter by is required"})
+		return
+	}
+
+	_, store, ok := s.openStore(ctx)
+	if !ok {
+		return
+	}
+	references, err := store.QueryLinks(ctx, payload.Text, payload.Filter)
+	if err != nil {
+		ctx.JSON(searchStatus(err), gin.H{"error": "something went wrong talking to the search index"})
+		s.logger.ErrorContext(ctx, "failed to search links", slog.String("text", payload.Text), slog.Any("error", err))
+		return
+	}
+
+	response := &LinksResponse{Text: payload.Text, Links: references}
+	if response.Links == nil {
+		response.Links = []search.Reference{}
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// relatedHandler finds the episodes most like the episode in the path
+func (s *server) relatedHandler(ctx *gin.Context) {
+	size := 5
+	if value := ctx.Query("size"); value != "" {
+		var err error
+		size, err = strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			ctx.JSON(http.StatusBadRequest, gin.H{"error": "size must be a positive number"})
+			return
+		}
+	}
+
+	_, store, ok := s.openStore(ctx)
+	if !ok {
+		return
+	}
+	GUID := ctx.Param("guid")
+	episodes, err := store.RelatedEpisodes(ctx, GUID, size, search.Filter{})
+	if err != nil {
+		ctx.JSON(searchStatus(err), gin.H{"error": "something went wrong finding related episodes"})
+		s.logger.ErrorContext(ctx, "failed to find related episodes", slog.String("guid", GUID), slog.Any("error", err))
+		return
+	}
+
+	response := &RelatedResponse{GUID: GUID}
+	for _, episode := range episodes {
+		response.Episodes = append(response.Episodes, newHit(episode))
+	}
+	ctx.JSON(http.StatusOK, response)
+}
+
+// topicsHandler lists the topics found among the chunks of the requested models index, to browse and filter by
+func (s *server) topicsHandler(ctx *gin.Context) {
+	model, err := embedding.ResolveModel(dataDirectory, ctx.GetHeader(modelHeader))
+	if err != nil {
+		s.logger.ErrorContext(ctx, "failed to resolve embedding model", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	clustering, err := topics.Load(dataDirectory, model.Name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong loading the topics"})
+		s.logger.ErrorContext(ctx, "failed to load topics", slog.String("model", model.Name), slog.Any("error", err))
+		return
+	}
+	if clustering == nil {
+		ctx.JSON(http.StatusNotFound, gin.H{"error": "no topics have been found for " + model.Name})
+		return
+	}
+
+	response := &TopicsResponse{Model: model.Name, Topics: clustering.Topics}
+	sort.SliceStable(response.Topics, func(i, j int) bool { return response.Topics[i].Chunks > response.Topics[j].Chunks })
+	ctx.JSON(http.StatusOK, response)
+}
+
+// timelineHandler buckets the chunks similar to a query by when they were published, without generating a chat
+// response
+func (s *server) timelineHandler(ctx *gin.Context) {
+	var payload TimelinePayload
+	if err := ctx.Bind(&payload); err != nil {
+		s.logger.ErrorContext(ctx, "failed to bind request to expected object", slog.Any("error", err))
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if strings.TrimSpace(payload.UserQuery) == "" {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": "a query to chart is required"})
+		return
+	}
+	if payload.Threshold == 0 {
+		payload.Threshold = search.DefaultTimelineThreshold
+	}
+	if payload.Interval == "" {
+		payload.Interval = search.IntervalMonth
+	}
+	snippets := 1
+	if payload.Snippets != nil {
+		snippets = *payload.Snippets
+	}
+	if err := search.ValidInterval(payload.Interval); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	model, store, ok := s.openStore(ctx)
+	if !ok {
+		return
+	}
+	queryEmbeddingResponse, err := s.openaiClient.CreateEmbeddings(ctx, openai.EmbeddingRequestStrings{
+		Input: []string{payload.UserQuery},
+		Model: openai.EmbeddingModel(model.Name),
+	})
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{"error": "something went wrong talking to openai"})
+		s.logger.ErrorContext(ctx, "failed to generate embedding from user query", slog.Any("error", err))
+		return
+	}
+
+	hits, err := store.QueryThreshold(ctx, queryEmbeddingResponse.Data[0].Embedding, payload.Threshold, payload.Filter)
+	if err != nil {
+		ctx.JSON(searchStatus(err), gin.H{"error": "something went wrong talking to the search index"})
+		s.logger.ErrorContext(ctx, "failed to search for chunks above the threshold", slog.Any("error", err))
+		return
+	}
+	buckets, err := search.Timeline(hits, payload.Interval, snippets)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx.JSON(http.StatusOK, &TimelineResponse{
+		UserQuery: payload.UserQuery,
+		Interval:  payload.Interval,
+		Chunks:    len(hits),
+		Buckets:   buckets,
+	})
+}
@@ -0,0 +1,167 @@
+package summary
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+
+	"oxide-search/manifest"
+)
+
+const (
+	summaryPrompt = "You summarize episodes of Oxide and Friends, a podcast about computer hardware, systems software and the computer industry. " +
+		"Given the transcript of an episode, write a summary of a few paragraphs covering the main topics discussed, the projects, companies and people mentioned, and any conclusions reached. " +
+		"If a list of chapters is given, also write a short summary of the discussion in each chapter. " +
+		`Respond with a JSON object of the form {"summary": "...", "chapters": [{"title": "...", "summary": "..."}]}, with the chapters in the order given.`
+)
+
+// Chapter is a section of an episode, as listed in the show notes
+type Chapter struct {
+	Title   string
+	Start   string
+	Summary string
+	// Vectors are the embeddings of the summary, keyed by embedding model
+	Vectors map[string][]float32 `json:",omitempty"`
+}
+
+// Episode holds the generated summaries for an episode
+type Episode struct {
+	GUID     string
+	Summary  string
+	Chapters []Chapter
+	// Vectors are the embeddings of the summary, keyed by embedding model
+	Vectors map[string][]float32 `json:",omitempty"`
+}
+
+func path(dataDirectory string, GUID string) string {
+	return filepath.Join(dataDirectory, fmt.Sprintf("%s.summary.json", GUID))
+}
+
+// Load reads the summaries for an episode, returning nil if the episode hasn't been summarized
+func Load(dataDirectory string, GUID string) (*Episode, error) {
+	summaryBytes, err := os.ReadFile(path(dataDirectory, GUID))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read summary for episode %s: %w", GUID, err)
+	}
+
+	var episode Episode
+	err = json.Unmarshal(summaryBytes, &episode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse summary for episode %s: %w", GUID, err)
+	}
+	return &episode, nil
+}
+
+func Save(dataDirectory string, episode *Episode) error {
+	summaryBytes, err := json.MarshalIndent(episode, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize summary for episode %s: %w", episode.GUID, err)
+	}
+	err = os.WriteFile(path(dataDirectory, episode.GUID), summaryBytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write summary for episode %s: %w", episode.GUID, err)
+	}
+	return nil
+}
+
+var (
+	htmlTag = regexp.MustCompile(`<[^>]*>`)
+	// Chapter markers in show notes look like "[00:12:34] Some topic" or "12:34 - Some topic"
+	chapterLine = regexp.MustCompile(`^\[?((?:\d{1,2}:)?\d{1,2}:\d{2})\]?\s*[-–:]?\s*(.+)$`)
+)
+
+// ParseChapters finds any chapter markers in an episode description
+func ParseChapters(description string) []Chapter {
+	text := strings.NewReplacer("<br>", "\n", "<br/>", "\n", "<br />", "\n", "</p>", "\n", "</li>", "\n").Replace(description)
+	text = htmlTag.ReplaceAllString(text, "")
+
+	var chapters []Chapter
+	for _, line := range strings.Split(text, "\n") {
+		match := chapterLine.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		chapters = append(chapters, Chapter{Start: match[1], Title: strings.TrimSpace(match[2])})
+	}
+	return chapters
+}
+
+// Generate asks a chat model to summarize an episode, and each of its chapters when the show notes list them
+func Generate(ctx context.Context, client *openai.Client, episode manifest.EpisodeData) (*Episode, error) {
+	chapters := ParseChapters(episode.Description)
+
+	var request strings.Builder
+	fmt.Fprintf(&request, "Episode title: %s\n\n", episode.Title)
+	if len(chapters) > 0 {
+		request.WriteString("Chapters:\n")
+		for _, chapter := range chapters {
+			fmt.Fprintf(&request, "%s %s\n", chapter.Start, chapter.Title)
+		}
+		request.WriteString("\n")
+	}
+	fmt.Fprintf(&request, "Transcript:\n%s", episode.Transcript)
+
+	response, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: openai.GPT4TurboPreview,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: summaryPrompt,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: request.String(),
+			},
+		},
+		ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
+		Temperature:    0.2,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate summary for episode %s: %w", episode.GUID, err)
+	}
+
+	var generated struct {
+		Summary  string `json:"summary"`
+		Chapters []struct {
+			Title   string `json:"title"`
+			Summary string `json:"summary"`
+		} `json:"chapters"`
+	}
+	err = json.Unmarshal([]byte(response.Choices[0].Message.Content), &generated)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse generated summary for episode %s: %w", episode.GUID, err)
+	}
+	if generated.Summary == "" {
+		return nil, fmt.Errorf("generated summary for episode %s was empty", episode.GUID)
+	}
+
+	// Only keep chapter summaries that line up with the chapters we asked for, the model occasionally invents or
+	// drops some, in which case match them back up by title
+	for i := range chapters {
+		if len(generated.Chapters) == len(chapters) {
+			chapters[i].Summary = generated.Chapters[i].Summary
+			continue
+		}
+		for _, g := range generated.Chapters {
+			if strings.EqualFold(strings.TrimSpace(g.Title), chapters[i].Title) {
+				chapters[i].Summary = g.Summary
+			}
+		}
+	}
+
+	return &Episode{
+		GUID:     episode.GUID,
+		Summary:  generated.Summary,
+		Chapters: chapters,
+	}, nil
+}
@@ -0,0 +1,171 @@
+package topics
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// KMeans clusters the vectors into k clusters by cosine similarity (spherical k-means), returning the cluster of
+// each vector and the normalized centroid of each cluster. Initial centroids are picked by k-means++ from a
+// generator seeded with seed, so the same vectors and seed always give the same clusters. Clusters can end up
+// empty
+func KMeans(vectors [][]float32, k int, iterations int, seed int64) ([]int, [][]float32) {
+	points := make([][]float32, len(vectors))
+	for i := range vectors {
+		points[i] = normalize(vectors[i])
+	}
+	k = min(k, len(points))
+	if k == 0 {
+		return nil, nil
+	}
+
+	random := rand.New(rand.NewSource(seed))
+	centroids := initialCentroids(points, k, random)
+	assignments := make([]int, len(points))
+	for i := range assignments {
+		assignments[i] = -1
+	}
+
+	for iteration := 0; iteration < iterations; iteration++ {
+		changed := false
+		for i, point := range points {
+			if best := nearest(point, centroids); best != assignments[i] {
+				assignments[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+
+		sums := make([][]float64, k)
+		counts := make([]int, k)
+		for i, point := range points {
+			cluster := assignments[i]
+			if sums[cluster] == nil {
+				sums[cluster] = make([]float64, len(point))
+			}
+			for j, v := range point {
+				sums[cluster][j] += float64(v)
+			}
+			counts[cluster]++
+		}
+		for cluster := range centroids {
+			if counts[cluster] == 0 {
+				// An empty cluster restarts from a random point, rather than being left behind
+				centroids[cluster] = points[random.Intn(len(points))]
+				continue
+			}
+			centroid := make([]float32, len(sums[cluster]))
+			for j := range centroid {
+				centroid[j] = float32(sums[cluster][j] / float64(counts[cluster]))
+			}
+			centroids[cluster] = normalize(centroid)
+		}
+	}
+
+	// The centroids move after the last assignment when k-means runs out of iterations before converging, so
+	// assign every point once more to make sure it's in the cluster of its nearest centroid
+	for i, point := range points {
+		assignments[i] = nearest(point, centroids)
+	}
+	return assignments, centroids
+}
+
+// initialCentroids picks k of the points by k-means++, each one more likely the further it is from the points
+// already picked
+func initialCentroids(points [][]float32, k int, random *rand.Rand) [][]float32 {
+	centroids := [][]float32{points[random.Intn(len(points))]}
+	distances := make([]float64, len(points))
+	for i := range distances {
+		distances[i] = math.Inf(1)
+	}
+	for len(centroids) < k {
+		var total float64
+		for i, point := range points {
+			distances[i] = min(distances[i], distance(point, centroids[len(centroids)-1]))
+			total += distances[i]
+		}
+		if total == 0 {
+			// Every point is on a centroid already, so any point will do
+			centroids = append(centroids, points[random.Intn(len(points))])
+			continue
+		}
+		target := random.Float64() * total
+		picked := len(points) - 1
+		for i := range points {
+			target -= distances[i]
+			if target <= 0 {
+				picked = i
+				break
+			}
+		}
+		centroids = append(centroids, points[picked])
+	}
+	return centroids
+}
+
+// nearest is the centroid most similar to the normalized point
+func nearest(point []float32, centroids [][]float32) int {
+	best, bestSimilarity := 0, math.Inf(-1)
+	for i, centroid := range centroids {
+		if similarity := dot(point, centroid); similarity > bestSimilarity {
+			best, bestSimilarity = i, similarity
+		}
+	}
+	return best
+}
+
+// distance is the squared cosine distance between normalized vectors, which k-means++ weights its picks by
+func distance(a []float32, b []float32) float64 {
+	d := 1 - dot(a, b)
+	return d * d
+}
+
+func dot(a []float32, b []float32) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var sum float64
+	for i := range a {
+		sum += float64(a[i]) * float64(b[i])
+	}
+	return sum
+}
+
+func normalize(vector []float32) []float32 {
+	norm := math.Sqrt(dot(vector, vector))
+	normalized := make([]float32, len(vector))
+	if norm == 0 {
+		return normalized
+	}
+	for i, v := range vector {
+		normalized[i] = float32(float64(v) / norm)
+	}
+	return normalized
+}
+
+// Representatives are the indexes of up to n vectors from each cluster closest to its centroid, closest first
+func Representatives(vectors [][]float32, assignments []int, centroids [][]float32, n int) [][]int {
+	type member struct {
+		index      int
+		similarity float64
+	}
+	members := make([][]member, len(centroids))
+	for i, cluster := range assignments {
+		if cluster < 0 || cluster >= len(centroids) {
+			continue
+		}
+		members[cluster] = append(members[cluster], member{i, dot(normalize(vectors[i]), centroids[cluster])})
+	}
+
+	representatives := make([][]int, len(centroids))
+	for cluster := range members {
+		sort.Slice(members[cluster], func(i, j int) bool { return members[cluster][i].similarity > members[cluster][j].similarity })
+		for _, m := range members[cluster][:min(n, len(members[cluster]))] {
+			representatives[cluster] = append(representatives[cluster], m.index)
+		}
+	}
+	return representatives
+}
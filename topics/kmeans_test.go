@@ -0,0 +1,122 @@
+package topics
+
+import (
+	"reflect"
+	"testing"
+)
+
+// separated are three tight groups of vectors pointing in different directions, with differing lengths since only
+// the direction counts
+var separated = [][]float32{
+	{1, 0.05, 0}, {2, -0.1, 0}, {0.9, 0, 0.05},
+	{0, 1, 0.1}, {0.05, 3, 0}, {-0.05, 1, 0},
+	{0, 0.1, 1}, {0, 0, 0.5}, {0.1, -0.05, 2},
+}
+
+// groups are the vectors which belong together in separated
+func groups(assignments []int) [][]int {
+	return [][]int{assignments[0:3], assignments[3:6], assignments[6:9]}
+}
+
+func TestKMeans(t *testing.T) {
+	tests := []struct {
+		name     string
+		vectors  [][]float32
+		k        int
+		clusters int
+	}{
+		{name: "separated groups", vectors: separated, k: 3, clusters: 3},
+		{name: "k over the number of vectors", vectors: separated[:2], k: 5, clusters: 2},
+		{name: "no vectors", vectors: nil, k: 3, clusters: 0},
+		{name: "no clusters", vectors: separated, k: 0, clusters: 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assignments, centroids := KMeans(test.vectors, test.k, 20, 1)
+			if len(centroids) != test.clusters {
+				t.Fatalf("got %d centroids, expected %d", len(centroids), test.clusters)
+			}
+			if test.clusters == 0 {
+				if assignments != nil {
+					t.Errorf("got assignments %v, expected none", assignments)
+				}
+				return
+			}
+			if len(assignments) != len(test.vectors) {
+				t.Fatalf("got %d assignments, expected %d", len(assignments), len(test.vectors))
+			}
+			// Every vector is in the cluster of its nearest centroid
+			for i, vector := range test.vectors {
+				if expected := nearest(normalize(vector), centroids); assignments[i] != expected {
+					t.Errorf("vector %d is in cluster %d, expected its nearest %d", i, assignments[i], expected)
+				}
+			}
+		})
+	}
+}
+
+func TestKMeansSeparated(t *testing.T) {
+	for seed := int64(0); seed < 10; seed++ {
+		assignments, _ := KMeans(separated, 3, 20, seed)
+		seen := make(map[int]bool)
+		for _, group := range groups(assignments) {
+			if group[0] != group[1] || group[0] != group[2] {
+				t.Errorf("seed %d split a group: %v", seed, assignments)
+			}
+			if seen[group[0]] {
+				t.Errorf("seed %d merged two groups: %v", seed, assignments)
+			}
+			seen[group[0]] = true
+		}
+	}
+}
+
+func TestKMeansSeed(t *testing.T) {
+	vectors := make([][]float32, 50)
+	for i := range vectors {
+		vectors[i] = []float32{float32(i % 7), float32(i % 5), float32(i % 3)}
+	}
+	assignments, centroids := KMeans(vectors, 4, 10, 42)
+	again, againCentroids := KMeans(vectors, 4, 10, 42)
+	if !reflect.DeepEqual(assignments, again) || !reflect.DeepEqual(centroids, againCentroids) {
+		t.Errorf("the same seed gave different clusters: %v and %v", assignments, again)
+	}
+}
+
+func TestRepresentatives(t *testing.T) {
+	vectors := [][]float32{{1, 0.5}, {1, 0}, {1, 0.2}, {0, 1}}
+	centroids := [][]float32{{1, 0}, {0, 1}, {-1, 0}}
+	tests := []struct {
+		name            string
+		assignments     []int
+		n               int
+		representatives [][]int
+	}{
+		{
+			name:            "closest first",
+			assignments:     []int{0, 0, 0, 1},
+			n:               2,
+			representatives: [][]int{{1, 2}, {3}, nil},
+		},
+		{
+			name:            "every member",
+			assignments:     []int{0, 0, 0, 1},
+			n:               5,
+			representatives: [][]int{{1, 2, 0}, {3}, nil},
+		},
+		{
+			name:            "out of range clusters are skipped",
+			assignments:     []int{0, -1, 3, 1},
+			n:               2,
+			representatives: [][]int{{0}, {3}, nil},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			representatives := Representatives(vectors, test.assignments, centroids, test.n)
+			if !reflect.DeepEqual(representatives, test.representatives) {
+				t.Errorf("got %v, expected %v", representatives, test.representatives)
+			}
+		})
+	}
+}
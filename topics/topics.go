@@ -0,0 +1,195 @@
+package topics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+const (
+	labelPrompt = "You are helping organize an archive of Oxide and Friends, a podcast about computer hardware, systems software and the computer industry. " +
+		"Given excerpts from transcripts that were clustered together because they discuss the same topic, name that topic in two to five words. " +
+		"Be specific, prefer the technology, project or question discussed over a generic category like \"technology\". " +
+		`Respond with a JSON object of the form {"label": "..."}.`
+
+	// EpisodeShare is the share of an episodes chunks a topic has to cover to count as one of the episodes topics
+	EpisodeShare = 0.2
+	// maxEpisodeTopics caps the topics of an episode
+	maxEpisodeTopics = 3
+)
+
+// Topic is a cluster of transcript chunks about the same thing
+type Topic struct {
+	Id    int
+	Label string
+	// Chunks and Episodes are how many chunks are in the topic, and how many episodes have it as one of their topics
+	Chunks   int
+	Episodes int
+}
+
+// Clustering is the topics found among the chunks embedded by one model, and the topic of each chunk
+type Clustering struct {
+	Model  string
+	Topics []Topic
+	// Assignments are the topic of each chunk of each episode, keyed by GUID and indexed by the chunks VectorId.
+	// Chunks that weren't embedded have no topic, which is -1
+	Assignments map[string][]int
+}
+
+func path(dataDirectory string, model string) string {
+	return filepath.Join(dataDirectory, fmt.Sprintf("topics.%s.json", model))
+}
+
+// Load reads the topics found among a models chunks, returning nil if the topics command hasn't been run for it
+func Load(dataDirectory string, model string) (*Clustering, error) {
+	topicBytes, err := os.ReadFile(path(dataDirectory, model))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s topics: %w", model, err)
+	}
+
+	var clustering Clustering
+	err = json.Unmarshal(topicBytes, &clustering)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s topics: %w", model, err)
+	}
+	return &clustering, nil
+}
+
+func Save(dataDirectory string, clustering *Clustering) error {
+	topicBytes, err := json.MarshalIndent(clustering, "", " ")
+	if err != nil {
+		return fmt.Errorf("failed to serialize %s topics: %w", clustering.Model, err)
+	}
+	err = os.WriteFile(path(dataDirectory, clustering.Model), topicBytes, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write %s topics: %w", clustering.Model, err)
+	}
+	return nil
+}
+
+// ChunkTopic is the label of the topic of one of an episodes chunks, or empty if it hasn't been clustered
+func (c *Clustering) ChunkTopic(GUID string, vectorId int) string {
+	if c == nil {
+		return ""
+	}
+	assignments := c.Assignments[GUID]
+	if vectorId < 0 || vectorId >= len(assignments) || !c.valid(assignments[vectorId]) {
+		return ""
+	}
+	return c.Topics[assignments[vectorId]].Label
+}
+
+// valid is true if id is one of the topics
+func (c *Clustering) valid(id int) bool {
+	return id >= 0 && id < len(c.Topics)
+}
+
+// EpisodeTopics are the labels of the topics covering at least EpisodeShare of an episodes chunks, most covered
+// first. The most covered topic is always included, so every clustered episode has at least one
+func (c *Clustering) EpisodeTopics(GUID string) []string {
+	if c == nil {
+		return nil
+	}
+	ids := episodeTopics(c.Assignments[GUID])
+	labels := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if c.valid(id) {
+			labels = append(labels, c.Topics[id].Label)
+		}
+	}
+	return labels
+}
+
+func episodeTopics(assignments []int) []int {
+	counts := make(map[int]int)
+	clustered := 0
+	for _, topic := range assignments {
+		if topic < 0 {
+			continue
+		}
+		counts[topic]++
+		clustered++
+	}
+	ids := make([]int, 0, len(counts))
+	for id := range counts {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if counts[ids[i]] != counts[ids[j]] {
+			return counts[ids[i]] > counts[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	var response []int
+	for _, id := range ids {
+		if len(response) >= maxEpisodeTopics || (len(response) > 0 && float64(counts[id]) < EpisodeShare*float64(clustered)) {
+			break
+		}
+		response = append(response, id)
+	}
+	return response
+}
+
+// Count fills in how many chunks and episodes each topic has
+func (c *Clustering) Count() {
+	for i := range c.Topics {
+		c.Topics[i].Chunks, c.Topics[i].Episodes = 0, 0
+	}
+	for _, assignments := range c.Assignments {
+		for _, id := range assignments {
+			if c.valid(id) {
+				c.Topics[id].Chunks++
+			}
+		}
+		for _, id := range episodeTopics(assignments) {
+			if c.valid(id) {
+				c.Topics[id].Episodes++
+			}
+		}
+	}
+}
+
+// Label asks a chat model to name the topic shared by a few excerpts of transcripts
+func Label(ctx context.Context, client *openai.Client, chatModel string, excerpts []string) (string, error) {
+	response, err := client.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
+		Model: chatModel,
+		Messages: []openai.ChatCompletionMessage{
+			{
+				Role:    openai.ChatMessageRoleSystem,
+				Content: labelPrompt,
+			},
+			{
+				Role:    openai.ChatMessageRoleUser,
+				Content: strings.Join(excerpts, "\n\n---\n\n"),
+			},
+		},
+		ResponseFormat: &openai.ChatCompletionResponseFormat{Type: openai.ChatCompletionResponseFormatTypeJSONObject},
+		Temperature:    0.2,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to label topic: %w", err)
+	}
+
+	var generated struct {
+		Label string `json:"label"`
+	}
+	err = json.Unmarshal([]byte(response.Choices[0].Message.Content), &generated)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse topic label: %w", err)
+	}
+	if strings.TrimSpace(generated.Label) == "" {
+		return "", fmt.Errorf("chat model returned an empty topic label")
+	}
+	return strings.TrimSpace(generated.Label), nil
+}